@@ -1,39 +1,23 @@
 package main
 
-import (
-	"github.com/tatsushid/go-prettytable"
-	_ "text/tabwriter"
-)
-
 type AboutCommand struct{}
 
 // 'about' command handler
-func (c *AboutCommand) Execute(v *Vcli, args ...string) (*prettytable.Table, error) {
+func (c *AboutCommand) Execute(v *Vcli, args ...string) (*Result, error) {
 	a := v.client.Client.ServiceContent.About
-	tbl, err := prettytable.NewTable([]prettytable.Column{
-		{Header: "Key"},
-		{Header: "Value"},
+	tbl := NewTable([]Column{
+		{Header: "Name", Field: "name"},
+		{Header: "Vendor", Field: "vendor"},
+		{Header: "Version", Field: "version"},
+		{Header: "Build", Field: "build"},
+		{Header: "OS type", Field: "os_type"},
+		{Header: "API type", Field: "api_type"},
+		{Header: "API version", Field: "api_version"},
+		{Header: "Product ID", Field: "product_id"},
+		{Header: "UUID", Field: "uuid"},
 	}...)
 
-	if err != nil {
-		return nil, err
-	}
-
-	aboutTbl := []KeyValue{
-		{"Name", a.Name},
-		{"Vendor", a.Vendor},
-		{"Version:", a.Version},
-		{"Build:", a.Build},
-		{"OS type:", a.OsType},
-		{"API type:", a.ApiType},
-		{"API version:", a.ApiVersion},
-		{"Product ID:", a.ProductLineId},
-		{"UUID:", a.InstanceUuid},
-	}
-
-	tbl.NoHeader = true
-	for _, k := range aboutTbl {
-		tbl.AddRow(k.key, k.value)
-	}
-	return tbl, nil
+	tbl.Vertical = true
+	tbl.AddRow(a.Name, a.Vendor, a.Version, a.Build, a.OsType, a.ApiType, a.ApiVersion, a.ProductLineId, a.InstanceUuid)
+	return TableResult(tbl), nil
 }
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// characters that have to be quoted or escaped in an argument
+const specialArgChars = " \t'\"\\"
+
+// commandLine is a command line split into arguments
+type commandLine struct {
+	args []string
+	// quote is the quote left open at the end of the line, 0 if none
+	quote rune
+	// escape is set if the line ends with a backslash
+	escape bool
+	// space is set if the line ends between arguments
+	space bool
+}
+
+// parseCommandLine splits a line into arguments like a shell: arguments
+// are separated by spaces or tabs, single quotes keep everything up to the
+// closing quote, double quotes keep everything except that a backslash
+// escapes '"' and '\', and outside of quotes a backslash escapes any
+// character. An empty quoted string is an empty argument.
+func parseCommandLine(line string) *commandLine {
+	cl := &commandLine{space: true}
+	var arg strings.Builder
+	inArg := false
+
+	for _, c := range line {
+		switch {
+		case cl.escape:
+			// inside double quotes other characters keep the backslash
+			if cl.quote == '"' && c != '"' && c != '\\' {
+				arg.WriteRune('\\')
+			}
+			arg.WriteRune(c)
+			cl.escape = false
+		case cl.quote == '\'':
+			if c == '\'' {
+				cl.quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		case cl.quote == '"':
+			if c == '"' {
+				cl.quote = 0
+			} else if c == '\\' {
+				cl.escape = true
+			} else {
+				arg.WriteRune(c)
+			}
+		case c == ' ' || c == '\t':
+			if inArg {
+				cl.args = append(cl.args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+			cl.space = true
+			continue
+		case c == '\'' || c == '"':
+			cl.quote = c
+		case c == '\\':
+			cl.escape = true
+		default:
+			arg.WriteRune(c)
+		}
+		inArg = true
+		cl.space = false
+	}
+
+	if inArg {
+		cl.args = append(cl.args, arg.String())
+	}
+	return cl
+}
+
+// splitArgs splits a command line into arguments, see parseCommandLine
+func splitArgs(line string) ([]string, error) {
+	cl := parseCommandLine(line)
+	if cl.quote != 0 {
+		return nil, fmt.Errorf("Missing closing quote %c", cl.quote)
+	}
+	if cl.escape {
+		return nil, errors.New("Missing character after '\\'")
+	}
+	return cl.args, nil
+}
+
+// quoteArg quotes an argument if it contains spaces, quotes or backslashes
+// so that splitArgs returns it unchanged
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, specialArgChars) {
+		return arg
+	}
+	return `"` + escapeQuoted(arg) + `"`
+}
+
+// escapeQuoted escapes the characters that end or escape a double quoted
+// argument
+func escapeQuoted(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+// escapeUnquoted escapes the characters of an unquoted argument
+func escapeUnquoted(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(specialArgChars, c) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
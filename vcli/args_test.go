@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		args []string
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"vm list", []string{"vm", "list"}},
+		{"  vm \t info   Ubuntu-01 ", []string{"vm", "info", "Ubuntu-01"}},
+		{`vm poweron "Windows 2019 Template"`, []string{"vm", "poweron", "Windows 2019 Template"}},
+		{`vm poweron 'Windows 2019 Template'`, []string{"vm", "poweron", "Windows 2019 Template"}},
+		{`vm info Windows\ 2019\ Template`, []string{"vm", "info", "Windows 2019 Template"}},
+		{`vm info web-"01 a"b`, []string{"vm", "info", "web-01 ab"}},
+		{`echo "" ''`, []string{"echo", "", ""}},
+		{`a "say \"hi\" \\ now"`, []string{"a", `say "hi" \ now`}},
+		{`a "a\d"`, []string{"a", `a\d`}},
+		{`a "C:\path"`, []string{"a", `C:\path`}},
+		{`vm list -re "^hx-\d+$"`, []string{"vm", "list", "-re", `^hx-\d+$`}},
+		{`a "\\\d"`, []string{"a", `\\d`}},
+		{`a 'no \escapes "here"'`, []string{"a", `no \escapes "here"`}},
+		{`a "it's"`, []string{"a", "it's"}},
+		{`a \'b\" \\`, []string{"a", `'b"`, `\`}},
+		{`vm list -filter 'state==poweredOn && name=~"^hx"'`, []string{"vm", "list", "-filter", `state==poweredOn && name=~"^hx"`}},
+	}
+
+	for _, tt := range tests {
+		args, err := splitArgs(tt.line)
+		if err != nil {
+			t.Errorf("splitArgs(%q): unexpected error %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("splitArgs(%q) = %q, want %q", tt.line, args, tt.args)
+		}
+	}
+}
+
+func TestSplitArgsErrors(t *testing.T) {
+	tests := []struct {
+		line string
+		err  string
+	}{
+		{`vm info "Ubuntu`, "Missing closing quote \""},
+		{`vm info 'Ubuntu`, "Missing closing quote '"},
+		{`vm info Ubuntu\`, "Missing character after '\\'"},
+		{`vm info "Ubuntu\`, "Missing closing quote \""},
+	}
+
+	for _, tt := range tests {
+		_, err := splitArgs(tt.line)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("splitArgs(%q): got error %v, want %q", tt.line, err, tt.err)
+		}
+	}
+}
+
+func TestParseCommandLineState(t *testing.T) {
+	tests := []struct {
+		line   string
+		quote  rune
+		escape bool
+		space  bool
+		args   []string
+	}{
+		{"", 0, false, true, nil},
+		{"vm ", 0, false, true, []string{"vm"}},
+		{"vm info", 0, false, false, []string{"vm", "info"}},
+		{`vm info "Win`, '"', false, false, []string{"vm", "info", "Win"}},
+		{`vm info 'Win 20`, '\'', false, false, []string{"vm", "info", "Win 20"}},
+		{`vm info Win\`, 0, true, false, []string{"vm", "info", "Win"}},
+		{`vm info "Win\`, '"', true, false, []string{"vm", "info", "Win"}},
+		{`vm info ""`, 0, false, false, []string{"vm", "info", ""}},
+	}
+
+	for _, tt := range tests {
+		cl := parseCommandLine(tt.line)
+		if cl.quote != tt.quote || cl.escape != tt.escape || cl.space != tt.space || !reflect.DeepEqual(cl.args, tt.args) {
+			t.Errorf("parseCommandLine(%q) = {args: %q, quote: %q, escape: %v, space: %v}, want {args: %q, quote: %q, escape: %v, space: %v}",
+				tt.line, cl.args, cl.quote, cl.escape, cl.space, tt.args, tt.quote, tt.escape, tt.space)
+		}
+	}
+}
+
+func TestQuoteArgRoundTrip(t *testing.T) {
+	tests := []struct {
+		arg    string
+		quoted string
+	}{
+		{"Ubuntu-01", "Ubuntu-01"},
+		{"", `""`},
+		{"Windows 2019", `"Windows 2019"`},
+		{"tab\there", "\"tab\there\""},
+		{`it's`, `"it's"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\VMs`, `"C:\\VMs"`},
+		{`\"`, `"\\\""`},
+		{"web-*,db[1]", "web-*,db[1]"},
+		{"née", "née"},
+	}
+
+	for _, tt := range tests {
+		quoted := quoteArg(tt.arg)
+		if quoted != tt.quoted {
+			t.Errorf("quoteArg(%q) = %s, want %s", tt.arg, quoted, tt.quoted)
+		}
+		args, err := splitArgs("cmd " + quoted + " next")
+		if err != nil || !reflect.DeepEqual(args, []string{"cmd", tt.arg, "next"}) {
+			t.Errorf("splitArgs(quoteArg(%q)) = %q, %v", tt.arg, args, err)
+		}
+	}
+}
+
+func TestEscapeUnquotedRoundTrip(t *testing.T) {
+	for _, arg := range []string{"Windows 2019 Template", `it's "quoted"`, `back\slash`, "tab\tand space", "plain"} {
+		args, err := splitArgs(escapeUnquoted(arg))
+		if err != nil || !reflect.DeepEqual(args, []string{arg}) {
+			t.Errorf("splitArgs(escapeUnquoted(%q)) = %q, %v", arg, args, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+type CacheCommand struct{}
+type CacheStatusCommand struct{}
+type CacheRefreshCommand struct{}
+
+const (
+	CACHE_STATUS  = "status"
+	CACHE_REFRESH = "refresh"
+)
+
+var cacheCommands = map[string]Command{
+	CACHE_STATUS:  &CacheStatusCommand{},
+	CACHE_REFRESH: &CacheRefreshCommand{},
+}
+
+// kinds of cached objects shown by 'cache status'
+var cacheKinds = []struct {
+	kind   string
+	header string
+	field  string
+}{
+	{INVENTORY_VM, "VMs", "vms"},
+	{INVENTORY_CLUSTER, "Clusters", "clusters"},
+	{INVENTORY_HOST, "Hosts", "hosts"},
+	{INVENTORY_DATACENTER, "Datacenters", "datacenters"},
+	{INVENTORY_FOLDER, "Folders", "folders"},
+	{INVENTORY_DATASTORE, "Datastores", "datastores"},
+	{INVENTORY_EXTENSION, "Extensions", "extensions"},
+}
+
+func (c *CacheCommand) Execute(v *Vcli, args ...string) (*Result, error) {
+	if len(args) > 0 {
+		cmd := args[0]
+		options := args[1:]
+		if fn, ok := cacheCommands[cmd]; ok {
+			t, err := fn.Execute(v, options...)
+			return t, err
+		}
+		return nil, fmt.Errorf("Unknown subcommand '%s' for cache", cmd)
+	}
+	return UsageResult(c.Usage()), nil
+}
+
+func (c *CacheCommand) Usage() string {
+	return `Usage: cache [command]
+
+The inventory cache keeps the VMs, clusters, hosts, datacenters, folders,
+datastores and extensions of a connection up to date with vCenter for the
+session.
+'vm list', 'cr list', 'dc list', name lookups and tab completion read it.
+
+Commands:
+  status     Show the state of the inventory cache
+  refresh    Load the inventory cache again from vCenter`
+}
+
+func (cmd *CacheStatusCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	s := cli.inventory.Status()
+
+	columns := []Column{
+		{Header: "State", Field: "state"},
+	}
+	row := []interface{}{s.State}
+	for _, k := range cacheKinds {
+		columns = append(columns, Column{Header: k.header, Field: k.field})
+		row = append(row, s.Objects[k.kind])
+	}
+	columns = append(columns, []Column{
+		{Header: "Version", Field: "version"},
+		{Header: "Updates", Field: "updates"},
+		{Header: "Loaded in", Field: "load_time"},
+		{Header: "Last update", Field: "last_update"},
+		{Header: "Error", Field: "error"},
+	}...)
+
+	var lastUpdate interface{}
+	if !s.Updated.IsZero() {
+		lastUpdate = NewCell(time.Since(s.Updated).Round(time.Second).String()+" ago", s.Updated)
+	}
+	var errMsg string
+	if s.Err != nil {
+		errMsg = s.Err.Error()
+	}
+	row = append(row, s.Version, s.Updates, NewCell(s.LoadTime.Round(time.Millisecond).String(), s.LoadTime.Seconds()), lastUpdate, errMsg)
+
+	tbl := NewTable(columns...)
+	tbl.Vertical = true
+	tbl.AddRow(row...)
+	return TableResult(tbl), nil
+}
+
+func (cmd *CacheRefreshCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	if err := cli.inventory.Refresh(cli.ctx, cli); err != nil {
+		return nil, err
+	}
+	s := cli.inventory.Status()
+	total := 0
+	for _, count := range s.Objects {
+		total += count
+	}
+	return MessageResult("Inventory cache refreshed: %d objects loaded in %s", total, s.LoadTime.Round(time.Millisecond)), nil
+}
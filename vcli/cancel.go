@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+)
+
+// errCancelled is the error of a command interrupted with Ctrl-C
+var errCancelled = errors.New("Command cancelled")
+
+// commandContext returns the context of a single command run, it is
+// cancelled when Ctrl-C is pressed while the command runs. The context is
+// passed to the vCenter calls, HX requests and task waits of the command.
+// A second Ctrl-C exits vcli, in case the command is stuck somewhere the
+// context doesn't reach. stop has to be called once the command returns.
+func commandContext() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-sigCh:
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case <-sigCh:
+			Spinner.Stop()
+			Errorln("Interrupted")
+			os.Exit(EXIT_INTERRUPTED)
+		case <-done:
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(sigCh)
+		close(done)
+		cancel()
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"strings"
+	"sync"
+)
+
+type VmCloneCommand struct{}
+type VmDeployCommand struct{}
+
+const (
+	VM_CLONE  = "clone"
+	VM_DEPLOY = "deploy"
+)
+
+const defaultNetmask = "255.255.255.0"
+
+// cloneOptions holds the placement and guest customization options shared
+// by vm clone and vm deploy
+type cloneOptions struct {
+	folder    string
+	pool      string
+	datastore string
+	host      string
+	linked    bool
+	powerOn   bool
+	template  bool
+
+	spec     string
+	hostname string
+	ip       string
+	netmask  string
+	gateway  string
+	dns      string
+	domain   string
+}
+
+func newCloneFlagSet(name string) (*flag.FlagSet, *cloneOptions) {
+	o := &cloneOptions{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&o.folder, "folder", "", "Inventory folder of the new VM")
+	fs.StringVar(&o.pool, "pool", "", "Resource pool of the new VM")
+	fs.StringVar(&o.datastore, "datastore", "", "Datastore of the new VM")
+	fs.StringVar(&o.host, "host", "", "Host of the new VM")
+	fs.BoolVar(&o.linked, "linked", false, "Create a linked clone")
+	fs.BoolVar(&o.powerOn, "poweron", false, "Power on the new VM")
+	fs.BoolVar(&o.template, "template", false, "Mark the new VM as template")
+	fs.StringVar(&o.spec, "spec", "", "Name of a guest customization spec")
+	fs.StringVar(&o.ip, "ip", "", "Static IP address(es) of the guest")
+	fs.StringVar(&o.netmask, "netmask", defaultNetmask, "Subnet mask of the guest")
+	fs.StringVar(&o.gateway, "gateway", "", "Default gateway of the guest")
+	fs.StringVar(&o.dns, "dns", "", "Comma separated DNS servers of the guest")
+	fs.StringVar(&o.domain, "domain", "", "Domain name of the guest")
+	return fs, o
+}
+
+// customize reports whether a guest customization has to be applied
+func (o *cloneOptions) customize() bool {
+	return o.spec != "" || o.hostname != "" || o.ip != "" || o.domain != ""
+}
+
+func (cmd *VmCloneCommand) Usage() string {
+	return `Usage: vm clone [options] source-vm new-vm-name
+
+Clone a VM or template. The new VM is placed in the folder, resource pool
+and datastore of the source VM unless given otherwise.
+
+Options:
+  -folder=path       Inventory folder of the new VM
+  -pool=name         Resource pool of the new VM
+  -datastore=name    Datastore of the new VM
+  -host=name         Host of the new VM
+  -linked            Create a linked clone from the current snapshot of the source
+  -poweron           Power on the new VM
+  -template          Mark the new VM as template
+
+Guest customization (Linux):
+  -spec=name         Apply a customization spec saved in vCenter
+  -hostname=name     Host name of the guest, defaults to the new VM name
+  -ip=address        Static IP address of the guest, DHCP if not given
+  -netmask=mask      Subnet mask of the guest (default 255.255.255.0)
+  -gateway=address   Default gateway of the guest
+  -dns=a,b           DNS servers of the guest
+  -domain=name       Domain name of the guest
+
+Examples:
+  vm clone Ubuntu-01 Ubuntu-02
+  vm clone -linked -poweron Ubuntu-01 Ubuntu-02
+  vm clone -pool=Test -datastore=ds1 Ubuntu-18.04-template Ubuntu-03
+  vm clone -ip=10.64.55.20 -gateway=10.64.55.1 -dns=10.64.1.8 Ubuntu-18.04-template Ubuntu-04
+`
+}
+
+func (cmd *VmCloneCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	cloneCmd, opts := newCloneFlagSet(VM_CLONE)
+	cloneCmd.StringVar(&opts.hostname, "hostname", "", "Host name of the guest")
+	names, err := parseFlags(cloneCmd, args)
+	if err != nil || len(names) != 2 {
+		return UsageResult(cmd.Usage()), nil
+	}
+	return cloneVirtualMachines(cli, names[0], []string{names[1]}, opts)
+}
+
+func (cmd *VmDeployCommand) Usage() string {
+	return `Usage: vm deploy [options] template new-vm-name1 [,new-vm-name2, ...]
+
+Deploy VM(s) from a template in parallel. The VM name is used as host name
+of the guest when a customization is applied.
+
+Options:
+  -folder=path       Inventory folder of the new VMs
+  -pool=name         Resource pool of the new VMs
+  -datastore=name    Datastore of the new VMs
+  -host=name         Host of the new VMs
+  -linked            Create linked clones from the current snapshot of the template
+  -poweron           Power on the new VMs
+
+Guest customization (Linux):
+  -spec=name         Apply a customization spec saved in vCenter
+  -ip=a,b,...        Static IP address of each VM, in the order of the names
+  -netmask=mask      Subnet mask of the guests (default 255.255.255.0)
+  -gateway=address   Default gateway of the guests
+  -dns=a,b           DNS servers of the guests
+  -domain=name       Domain name of the guests
+
+Examples:
+  vm deploy Ubuntu-18.04-template tb-01,tb-02,tb-03
+  vm deploy -poweron -ip=10.64.55.21,10.64.55.22 -gateway=10.64.55.1 Ubuntu-18.04-template tb-01,tb-02
+`
+}
+
+func (cmd *VmDeployCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	deployCmd, opts := newCloneFlagSet(VM_DEPLOY)
+	names, err := parseFlags(deployCmd, args)
+	if err != nil || len(names) != 2 || len(strings.Trim(names[1], ",")) == 0 {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	var vmNames []string
+	for _, name := range strings.Split(names[1], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			vmNames = append(vmNames, name)
+		}
+	}
+	return cloneVirtualMachines(cli, names[0], vmNames, opts)
+}
+
+// cloneVirtualMachines clones the source VM or template into a new VM for
+// every name in parallel and collects the outcome per VM
+func cloneVirtualMachines(cli *Vcli, source string, names []string, opts *cloneOptions) (*Result, error) {
+	ctx := cli.ctx
+	c := cli.client.Client
+
+	if strings.Contains(source, ",") {
+		return nil, fmt.Errorf("Only one source VM can be cloned, got '%s'", source)
+	}
+
+	var ips []string
+	if opts.ip != "" {
+		ips = strings.Split(opts.ip, ",")
+		if len(ips) != len(names) {
+			return nil, fmt.Errorf("Got %d IP address(es) for %d VM(s)", len(ips), len(names))
+		}
+	}
+
+	vms, err := findVirtualMachines(cli, source, []string{"summary", "parent", "resourcePool", "snapshot"})
+	if err != nil {
+		return nil, err
+	}
+	if len(vms) != 1 {
+		return nil, fmt.Errorf("Only one source VM can be cloned, '%s' matches %d VMs", source, len(vms))
+	}
+	src := vms[0]
+
+	folder, location, err := getClonePlacement(cli, src, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var baseSpec *types.CustomizationSpec
+	if opts.spec != "" {
+		m := object.NewCustomizationSpecManager(c)
+		item, err := m.GetCustomizationSpec(ctx, opts.spec)
+		if err != nil {
+			return nil, fmt.Errorf("Customization spec '%s': %s", opts.spec, err)
+		}
+		baseSpec = &item.Spec
+	}
+
+	srcRef := object.NewVirtualMachine(c, src.Reference())
+	srcName := src.Summary.Config.Name
+
+	var wg sync.WaitGroup
+	rows := make([][]interface{}, len(names))
+	errs := make([]error, len(names))
+
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			Progress("Cloning '%s' to '%s'...", srcName, name)
+
+			spec := types.VirtualMachineCloneSpec{
+				Location: location,
+				PowerOn:  opts.powerOn,
+				Template: opts.template,
+			}
+			if opts.linked {
+				spec.Snapshot = src.Snapshot.CurrentSnapshot
+			}
+			if opts.customize() {
+				var ip string
+				if ips != nil {
+					ip = strings.TrimSpace(ips[i])
+				}
+				spec.Customization = newCustomizationSpec(baseSpec, opts, name, ip)
+			}
+
+			task, err := srcRef.Clone(ctx, folder, name, spec)
+			if err = waitForTask(ctx, task, err, name); err != nil {
+				rows[i] = []interface{}{name, "Clone", "failed"}
+				errs[i] = fmt.Errorf("Failed to clone '%s' to '%s': %s", srcName, name, err.Error())
+				return
+			}
+			rows[i] = []interface{}{name, "Clone", "completed"}
+		}(i, name)
+	}
+	wg.Wait()
+
+	r := TableResult(NewTable(vmActionColumns...))
+	for i := range names {
+		r.Table.AddRow(rows[i]...)
+		if errs[i] != nil {
+			r.AddError(errs[i])
+		}
+	}
+	return r, nil
+}
+
+// getClonePlacement resolves the folder, resource pool, datastore and host
+// of the new VMs in the datacenter of the source, defaulting to the
+// placement of the source
+func getClonePlacement(cli *Vcli, src mo.VirtualMachine, opts *cloneOptions) (*object.Folder, types.VirtualMachineRelocateSpec, error) {
+	ctx := cli.ctx
+	c := cli.client.Client
+	var location types.VirtualMachineRelocateSpec
+
+	entities, err := mo.Ancestors(ctx, c, c.ServiceContent.PropertyCollector, src.Reference())
+	if err != nil {
+		return nil, location, err
+	}
+
+	finder := find.NewFinder(c, false)
+	for _, e := range entities {
+		if e.Self.Type == "Datacenter" {
+			finder.SetDatacenter(object.NewDatacenter(c, e.Self))
+		}
+	}
+
+	folder := object.NewFolder(c, *src.Parent)
+	if opts.folder != "" {
+		if folder, err = finder.Folder(ctx, opts.folder); err != nil {
+			return nil, location, err
+		}
+	}
+
+	var host *object.HostSystem
+	if opts.host != "" {
+		if host, err = finder.HostSystem(ctx, opts.host); err != nil {
+			return nil, location, err
+		}
+		ref := host.Reference()
+		location.Host = &ref
+	}
+
+	var pool *object.ResourcePool
+	switch {
+	case opts.pool != "":
+		pool, err = finder.ResourcePool(ctx, opts.pool)
+	case host != nil:
+		pool, err = host.ResourcePool(ctx)
+	case src.ResourcePool != nil:
+		pool = object.NewResourcePool(c, *src.ResourcePool)
+	case src.Summary.Runtime.Host != nil:
+		// templates have no resource pool, use the one of their host
+		pool, err = object.NewHostSystem(c, *src.Summary.Runtime.Host).ResourcePool(ctx)
+	default:
+		err = errors.New("Resource pool of the new VM is unknown, use -pool or -host")
+	}
+	if err != nil {
+		return nil, location, err
+	}
+	ref := pool.Reference()
+	location.Pool = &ref
+
+	if opts.datastore != "" {
+		ds, err := finder.Datastore(ctx, opts.datastore)
+		if err != nil {
+			return nil, location, err
+		}
+		ref := ds.Reference()
+		location.Datastore = &ref
+	}
+
+	if opts.linked {
+		if src.Snapshot == nil || src.Snapshot.CurrentSnapshot == nil {
+			return nil, location, fmt.Errorf("'%s' has no snapshot to create a linked clone from", src.Summary.Config.Name)
+		}
+		location.DiskMoveType = string(types.VirtualMachineRelocateDiskMoveOptionsCreateNewChildDiskBacking)
+	}
+	return folder, location, nil
+}
+
+// newCustomizationSpec builds the Linux guest customization of one VM,
+// starting from a saved spec if given. The host name defaults to the VM
+// name and the first NIC gets a static IP, or DHCP without a saved spec.
+func newCustomizationSpec(base *types.CustomizationSpec, opts *cloneOptions, name string, ip string) *types.CustomizationSpec {
+	hostname := name
+	if opts.hostname != "" {
+		hostname = opts.hostname
+	}
+
+	spec := &types.CustomizationSpec{
+		Identity: &types.CustomizationLinuxPrep{},
+		NicSettingMap: []types.CustomizationAdapterMapping{
+			{Adapter: types.CustomizationIPSettings{Ip: &types.CustomizationDhcpIpGenerator{}}},
+		},
+	}
+	if base != nil {
+		// copy the parts that are changed per VM, the saved spec is shared
+		*spec = *base
+		spec.NicSettingMap = append([]types.CustomizationAdapterMapping(nil), base.NicSettingMap...)
+	}
+
+	if prep, ok := spec.Identity.(*types.CustomizationLinuxPrep); ok {
+		linuxPrep := *prep
+		// a fixed name of a saved spec would give every VM the same name
+		if _, fixed := linuxPrep.HostName.(*types.CustomizationFixedName); fixed || linuxPrep.HostName == nil || opts.hostname != "" {
+			linuxPrep.HostName = &types.CustomizationFixedName{Name: hostname}
+		}
+		if opts.domain != "" {
+			linuxPrep.Domain = opts.domain
+		}
+		spec.Identity = &linuxPrep
+	}
+
+	if ip != "" {
+		if len(spec.NicSettingMap) == 0 {
+			spec.NicSettingMap = []types.CustomizationAdapterMapping{{}}
+		}
+		adapter := &spec.NicSettingMap[0].Adapter
+		adapter.Ip = &types.CustomizationFixedIp{IpAddress: ip}
+		if adapter.SubnetMask == "" || opts.netmask != defaultNetmask {
+			adapter.SubnetMask = opts.netmask
+		}
+		if opts.gateway != "" {
+			adapter.Gateway = []string{opts.gateway}
+		}
+	}
+
+	if opts.dns != "" {
+		spec.GlobalIPSettings.DnsServerList = strings.Split(opts.dns, ",")
+	}
+	if opts.domain != "" {
+		spec.GlobalIPSettings.DnsSuffixList = []string{opts.domain}
+	}
+	return spec
+}
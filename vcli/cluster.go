@@ -2,15 +2,16 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
-	"github.com/tatsushid/go-prettytable"
-	"github.com/vmware/govmomi/find"
+	"github.com/go/vcli/filter"
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/property"
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
-	"path"
-	_ "strings"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type CrCommand struct{}
@@ -27,20 +28,37 @@ var clCommands = map[string]Command{
 	CR_INFO: &CrInfoCommand{},
 }
 
-func (c *CrCommand) Execute(v *Vcli, args ...string) (*prettytable.Table, error) {
+// columns shared by 'cr list' and 'hx list'
+var clusterColumns = []Column{
+	{Header: "#", Field: "index"},
+	{Header: "Name", Field: "name", MinWidth: 6},
+	{Header: "Path", Field: "path"},
+	{Header: "Hosts", Field: "hosts", Kind: filter.Number},
+	{Header: "TotalCPU", Field: "total_cpu_mhz", Key: "cpu", Kind: filter.Frequency},
+	{Header: "Cores", Field: "cores", Kind: filter.Number},
+	{Header: "TotalMemory", Field: "total_memory_bytes", Key: "mem", Kind: filter.Size},
+}
+
+// clusterListHelp describes the options of 'cr list' and 'hx list'
+const clusterListHelp = `Options:
+` + listOptionsHelp + `
+
+Columns:
+  name, path, hosts, cpu, cores, mem
+
+` + filterHelp
+
+func (c *CrCommand) Execute(v *Vcli, args ...string) (*Result, error) {
 	if len(args) > 0 {
 		cmd := args[0]
 		options := args[1:]
 		if fn, ok := clCommands[cmd]; ok {
 			t, err := fn.Execute(v, options...)
 			return t, err
-		} else {
-			Error("Unknown subcommand '%s' for vm\n", cmd)
 		}
-		return nil, nil
+		return nil, fmt.Errorf("Unknown subcommand '%s' for cr", cmd)
 	}
-	Usage(c.Usage())
-	return nil, nil
+	return UsageResult(c.Usage()), nil
 }
 
 func (c *CrCommand) Usage() string {
@@ -51,8 +69,28 @@ Commands:
   info    Display cluster summary`
 }
 
-func (cmd *CrListCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table, error) {
-	clusters, err := GetClusterComputeResources(cli)
+func (cmd *CrListCommand) Usage() string {
+	return `Usage: cr list [options]
+
+List all clusters
+
+` + clusterListHelp + `
+
+Examples:
+  cr list -filter 'hosts>=4 && mem>=512GB'
+  cr list -sort mem:desc
+  cr list -cols name,hosts,cores -limit 5
+`
+}
+
+func (cmd *CrListCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	listCmd := flag.NewFlagSet("list", flag.ContinueOnError)
+	opts := newListFlags(listCmd)
+	if _, err := parseFlags(listCmd, args); err != nil {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	clusters, err := cli.inventory.Objects(cli.ctx, cli, INVENTORY_CLUSTER)
 	if err != nil {
 		return nil, err
 	}
@@ -61,107 +99,293 @@ func (cmd *CrListCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table
 		return nil, errors.New("No clusters found")
 	}
 
-	tbl, err := prettytable.NewTable([]prettytable.Column{
-		{Header: "#"},
-		{Header: "Name", MinWidth: 6},
-		{Header: "Path"},
-		{Header: "Hosts"},
-		{Header: "TotalCPU"},
-		{Header: "Cores"},
-		{Header: "TotalMemory"},
-	}...)
+	tbl := NewTable(clusterColumns...)
 
 	for index, cl := range clusters {
-		// hostObjects, _ := cl.ComputeResource.Hosts(ctx)
-		// hostSystems, _ := getHostSystems(cli, hostObjects)
-		cr, _ := GetComputeResource(cli, &cl.ComputeResource)
-		summary := cr.Summary.GetComputeResourceSummary()
-		tbl.AddRow(index+1, cl.Name(), cl.InventoryPath, summary.NumHosts, getCpuInGHz(summary.TotalCpu), summary.NumCpuCores, getMemoryInGB(summary.TotalMemory))
+		tbl.AddRow(index+1, cl.Name, cl.Path, cl.Int("summary.numHosts"), cpuCell(int32(cl.Int("summary.totalCpu"))), cl.Int("summary.numCpuCores"), memoryCell(cl.Int("summary.totalMemory")))
 	}
 
-	return tbl, nil
+	tbl, err = opts.Apply(tbl, nil)
+	if err != nil {
+		return nil, err
+	}
+	return TableResult(tbl), nil
 }
 
-func (c *CrInfoCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table, error) {
-	Errorln("Not implemented")
-	return nil, nil
+func (c *CrInfoCommand) Usage() string {
+	return `Usage: cr info cluster-name OR #
+
+Display DRS, HA and EVC configuration, hosts, resource usage and
+active issues of a cluster
+
+Examples:
+  cr info BLR-EDGE
+  cr info 1
+`
 }
 
-func GetClusterComputeResources(cli *Vcli) ([]*object.ClusterComputeResource, error) {
-	ctx := cli.ctx
-	c := cli.client.Client
-	finder := find.NewFinder(c, false)
-	objects, err := finder.DatacenterList(ctx, "*")
+// ClusterHost is the json/yaml shape of a host listed by 'cr info'
+type ClusterHost struct {
+	Name            string `json:"name" yaml:"name"`
+	ConnectionState string `json:"connection_state" yaml:"connection_state"`
+	PowerState      string `json:"power_state" yaml:"power_state"`
+	Maintenance     bool   `json:"maintenance" yaml:"maintenance"`
+}
+
+// ClusterAlarm is the json/yaml shape of a triggered alarm listed by 'cr info'
+type ClusterAlarm struct {
+	Name   string    `json:"name" yaml:"name"`
+	Status string    `json:"status" yaml:"status"`
+	Time   time.Time `json:"time" yaml:"time"`
+}
+
+func (c *CrInfoCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	if len(args) <= 0 {
+		return UsageResult(c.Usage()), nil
+	}
+
+	clusterName := args[0]
+	clusters, err := GetClusterComputeResources(cli)
 	if err != nil {
 		return nil, err
 	}
 
-	props := []string{
-		"name",
-		"vmFolder",
-		"hostFolder",
-		"datastoreFolder",
-		"networkFolder",
-		"datastore",
-		"network",
+	var target *object.ClusterComputeResource
+	for index, cl := range clusters {
+		if cl.Name() == clusterName || strconv.Itoa(index+1) == clusterName {
+			target = cl
+			break
+		}
 	}
 
-	refs := make([]types.ManagedObjectReference, 0, len(objects))
-	for _, o := range objects {
-		refs = append(refs, o.Reference())
+	if target == nil {
+		return nil, errors.New("Cluster '" + clusterName + "' is not found")
 	}
 
-	var datacenters []mo.Datacenter
-	pc := property.DefaultCollector(c)
-	err = pc.Retrieve(ctx, refs, props, &datacenters)
+	ccr, err := GetClusterComputeResource(cli, target)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(datacenters) <= 0 {
-		return nil, nil
+	r := NewResult()
+	var summary *types.ClusterComputeResourceSummary
+	if s, ok := ccr.Summary.(*types.ClusterComputeResourceSummary); ok {
+		summary = s
+	} else {
+		summary = &types.ClusterComputeResourceSummary{}
+		if ccr.Summary != nil {
+			summary.ComputeResourceSummary = *ccr.Summary.GetComputeResourceSummary()
+		}
 	}
 
-	objs := make(map[types.ManagedObjectReference]mo.Datacenter, len(datacenters))
-
-	for _, o := range datacenters {
-		objs[o.Reference()] = o
+	config, ok := ccr.ConfigurationEx.(*types.ClusterConfigInfoEx)
+	if !ok {
+		config = &types.ClusterConfigInfoEx{}
 	}
 
-	var clusters []*object.ClusterComputeResource
+	drs := config.DrsConfig
+	das := config.DasConfig
+	var isolationResponse string
+	if das.DefaultVmSettings != nil {
+		isolationResponse = das.DefaultVmSettings.IsolationResponse
+	}
 
-	for _, o := range objects {
-		folders, err := o.Folders(ctx)
+	evcMode := summary.CurrentEVCModeKey
+	if evcMode == "" {
+		evcMode = "disabled"
+	}
 
+	var hosts []ClusterHost
+	var hostNames []string
+	var cpuUsage, memUsage int64
+	if len(ccr.Host) > 0 {
+		var hostSystems []mo.HostSystem
+		pc := property.DefaultCollector(cli.client.Client)
+		err = pc.Retrieve(cli.ctx, ccr.Host, []string{"summary"}, &hostSystems)
 		if err != nil {
-			return nil, err
+			r.AddError(err)
 		}
+		for _, h := range hostSystems {
+			rt := h.Summary.Runtime
+			host := ClusterHost{
+				Name:            h.Summary.Config.Name,
+				ConnectionState: string(rt.ConnectionState),
+				PowerState:      string(rt.PowerState),
+				Maintenance:     rt.InMaintenanceMode,
+			}
+			hosts = append(hosts, host)
+
+			state := host.ConnectionState
+			if host.Maintenance {
+				state += ", maintenance"
+			}
+			hostNames = append(hostNames, host.Name+" ("+state+")")
+			cpuUsage += int64(h.Summary.QuickStats.OverallCpuUsage)
+			memUsage += int64(h.Summary.QuickStats.OverallMemoryUsage) * 1024 * 1024
+		}
+	}
+
+	var issues []string
+	for _, e := range ccr.ConfigIssue {
+		issues = append(issues, e.GetEvent().FullFormattedMessage)
+	}
 
-		finder.SetDatacenter(o)
-		ccrs, _ := finder.ClusterComputeResourceList(ctx, path.Join(folders.HostFolder.InventoryPath, "*"))
-		clusters = append(clusters, ccrs...)
+	alarms, err := getTriggeredAlarms(cli, ccr.TriggeredAlarmState)
+	if err != nil {
+		r.AddError(err)
+	}
+	var alarmNames []string
+	for _, a := range alarms {
+		alarmNames = append(alarmNames, a.Name+" ("+a.Status+")")
+	}
+
+	tbl := NewTable([]Column{
+		{Header: "Name", Field: "name"},
+		{Header: "Path", Field: "path"},
+		{Header: "Status", Field: "overall_status"},
+		{Header: "DRS", Field: "drs_enabled"},
+		{Header: "DRS automation", Field: "drs_automation_level"},
+		{Header: "DRS migration threshold", Field: "drs_migration_threshold"},
+		{Header: "HA", Field: "ha_enabled"},
+		{Header: "HA admission control", Field: "ha_admission_control"},
+		{Header: "HA host monitoring", Field: "ha_host_monitoring"},
+		{Header: "HA isolation response", Field: "ha_isolation_response"},
+		{Header: "EVC mode", Field: "evc_mode"},
+		{Header: "CPU usage", Field: "cpu_usage_mhz"},
+		{Header: "CPU capacity", Field: "cpu_capacity_mhz"},
+		{Header: "Memory usage", Field: "memory_usage_bytes"},
+		{Header: "Memory capacity", Field: "memory_capacity_bytes"},
+		{Header: "Hosts", Field: "hosts"},
+		{Header: "Config issues", Field: "config_issues"},
+		{Header: "Alarms", Field: "alarms"},
+	}...)
+
+	tbl.Vertical = true
+	tbl.AddRow(target.Name(),
+		target.InventoryPath,
+		string(ccr.OverallStatus),
+		isEnabled(drs.Enabled),
+		string(drs.DefaultVmBehavior),
+		drsMigrationThreshold(drs.VmotionRate),
+		isEnabled(das.Enabled),
+		getAdmissionControl(&das),
+		das.HostMonitoring,
+		isolationResponse,
+		evcMode,
+		NewCell(getCpuInGHz(int32(cpuUsage)), cpuUsage),
+		cpuCell(summary.TotalCpu),
+		NewCell(getMemoryInGB(memUsage), memUsage),
+		memoryCell(summary.TotalMemory),
+		NewCell(strings.Join(hostNames, ", "), hosts),
+		NewCell(strings.Join(issues, "; "), issues),
+		NewCell(strings.Join(alarmNames, ", "), alarms))
+
+	r.Table = tbl
+	return r, nil
+}
+
+// GetClusterComputeResources returns the clusters of the inventory cache
+// sorted by name, the order of 'cr list'
+func GetClusterComputeResources(cli *Vcli) ([]*object.ClusterComputeResource, error) {
+	objects, err := cli.inventory.Objects(cli.ctx, cli, INVENTORY_CLUSTER)
+	if err != nil {
+		return nil, err
+	}
+
+	var clusters []*object.ClusterComputeResource
+	for _, o := range objects {
+		cl := object.NewClusterComputeResource(cli.client.Client, o.Ref)
+		cl.InventoryPath = o.Path
+		clusters = append(clusters, cl)
 	}
 	return clusters, nil
 }
 
-func GetComputeResource(cli *Vcli, cr *object.ComputeResource) (*mo.ComputeResource, error) {
+// GetClusterComputeResource retrieves configuration, summary, hosts and
+// health properties of a cluster
+func GetClusterComputeResource(cli *Vcli, ccr *object.ClusterComputeResource) (*mo.ClusterComputeResource, error) {
 	ctx := cli.ctx
 	c := cli.client.Client
 	props := []string{
+		"configurationEx",
 		"summary",
+		"host",
+		"overallStatus",
+		"configIssue",
+		"triggeredAlarmState",
 	}
 
-	var mocr mo.ComputeResource
-	refs := make([]types.ManagedObjectReference, 0, 1)
-	refs = append(refs, cr.Reference())
+	var moccr mo.ClusterComputeResource
 	pc := property.DefaultCollector(c)
-	err := pc.Retrieve(ctx, refs, props, &mocr)
+	err := pc.RetrieveOne(ctx, ccr.Reference(), props, &moccr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &moccr, nil
+}
+
+func getTriggeredAlarms(cli *Vcli, states []types.AlarmState) ([]ClusterAlarm, error) {
+	if len(states) == 0 {
+		return nil, nil
+	}
+
+	refs := make([]types.ManagedObjectReference, 0, len(states))
+	for _, s := range states {
+		refs = append(refs, s.Alarm)
+	}
 
+	var alarms []mo.Alarm
+	pc := property.DefaultCollector(cli.client.Client)
+	err := pc.Retrieve(cli.ctx, refs, []string{"info.name"}, &alarms)
 	if err != nil {
 		return nil, err
 	}
 
-	return &mocr, nil
+	names := make(map[types.ManagedObjectReference]string, len(alarms))
+	for _, a := range alarms {
+		names[a.Reference()] = a.Info.Name
+	}
+
+	var triggered []ClusterAlarm
+	for _, s := range states {
+		triggered = append(triggered, ClusterAlarm{
+			Name:   names[s.Alarm],
+			Status: string(s.OverallStatus),
+			Time:   s.Time,
+		})
+	}
+	return triggered, nil
+}
+
+func getAdmissionControl(das *types.ClusterDasConfigInfo) string {
+	if das.AdmissionControlEnabled == nil || !*das.AdmissionControlEnabled {
+		return "disabled"
+	}
+
+	switch p := das.AdmissionControlPolicy.(type) {
+	case *types.ClusterFailoverResourcesAdmissionControlPolicy:
+		return fmt.Sprintf("enabled (reserve %d%% CPU, %d%% memory)", p.CpuFailoverResourcesPercent, p.MemoryFailoverResourcesPercent)
+	case *types.ClusterFailoverLevelAdmissionControlPolicy:
+		return fmt.Sprintf("enabled (tolerate %d host failure(s))", p.FailoverLevel)
+	case *types.ClusterFailoverHostAdmissionControlPolicy:
+		return fmt.Sprintf("enabled (%d dedicated failover host(s))", len(p.FailoverHosts))
+	}
+	return "enabled"
+}
+
+func isEnabled(b *bool) bool {
+	return b != nil && *b
+}
+
+// drsMigrationThreshold returns the migration threshold as the vSphere Client
+// shows it, from 1 (conservative) to 5 (aggressive). The API counts the other
+// way round, and leaves the rate unset for the default level 3.
+func drsMigrationThreshold(rate int32) int32 {
+	if rate < 1 || rate > 5 {
+		return 3
+	}
+	return 6 - rate
 }
 
 func getHostSystems(cli *Vcli, objects []*object.HostSystem) ([]mo.HostSystem, error) {
@@ -206,3 +430,11 @@ func getCpuInGHz(cpu int32) string {
 	c := float64(cpu) / 1000
 	return fmt.Sprintf("%.2fGHz", c)
 }
+
+func memoryCell(memsize int64) Cell {
+	return NewCell(getMemoryInGB(memsize), memsize)
+}
+
+func cpuCell(cpu int32) Cell {
+	return NewCell(getCpuInGHz(cpu), cpu)
+}
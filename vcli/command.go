@@ -1,23 +1,49 @@
 package main
 
 import (
-	"github.com/tatsushid/go-prettytable"
+	"flag"
 )
 
 type Command interface {
-	Execute(v *Vcli, args ...string) (*prettytable.Table, error)
+	Execute(v *Vcli, args ...string) (*Result, error)
 }
 
 // commands available for vcli prompt
 var Commands = map[string]Command{
-	"about":   &AboutCommand{},
-	"cr":      &CrCommand{},
-	"dc":      &DcCommand{},
-	"en":      &EnCommand{},
-	"exit":    &ExitCommand{},
-	"help":    &HelpCommand{},
-	"hx":      &HxCommand{},
-	"version": &VersionCommand{},
-	"vm":      &VmCommand{},
-	"quit":    &ExitCommand{},
+	"about":       &AboutCommand{},
+	"cache":       &CacheCommand{},
+	"connect":     &ConnectCommand{},
+	"connections": &ConnectionsCommand{},
+	"cr":          &CrCommand{},
+	"dc":          &DcCommand{},
+	"en":          &EnCommand{},
+	"exit":        &ExitCommand{},
+	"help":        &HelpCommand{},
+	"history":     &HistoryCommand{},
+	"host":        &HostCommand{},
+	"hx":          &HxCommand{},
+	"logout":      &LogoutCommand{},
+	"version":     &VersionCommand{},
+	"vm":          &VmCommand{},
+	"quit":        &ExitCommand{},
+	"set":         &SetCommand{},
+	"task":        &TaskCommand{},
+	"use":         &UseCommand{},
+}
+
+// parseFlags parses options that may appear before or after the positional
+// arguments and returns the positional arguments
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
 }
@@ -6,17 +6,62 @@ import (
 	"strings"
 )
 
+// word separators of completion, elements of comma separated lists are
+// completed one by one
+const COMPLETION_SEPARATORS = " ,"
+
+// subcommands whose arguments are names of inventory objects
+var inventoryArgs = map[string]map[string]string{
+	"vm": {
+		VM_DESTROY:  INVENTORY_VM,
+		VM_INFO:     INVENTORY_VM,
+		VM_POWEROFF: INVENTORY_VM,
+		VM_POWERON:  INVENTORY_VM,
+		VM_REBOOT:   INVENTORY_VM,
+		VM_RESET:    INVENTORY_VM,
+		VM_SHUTDOWN: INVENTORY_VM,
+		VM_STANDBY:  INVENTORY_VM,
+		VM_SUSPEND:  INVENTORY_VM,
+	},
+	"cr": {
+		CR_INFO: INVENTORY_CLUSTER,
+	},
+	"hx": {
+		HX_DESTROY: INVENTORY_CLUSTER,
+		HX_INFO:    INVENTORY_CLUSTER,
+	},
+	"en": {
+		EN_INFO:       INVENTORY_EXTENSION,
+		EN_UNREGISTER: INVENTORY_EXTENSION,
+	},
+}
+
+var inventoryDescriptions = map[string]string{
+	INVENTORY_VM:        "VM",
+	INVENTORY_CLUSTER:   "Cluster",
+	INVENTORY_EXTENSION: "Extension",
+}
+
 var commands = []prompt.Suggest{
 	{Text: "about", Description: "Display About info for HOST"},
+	{Text: "cache", Description: "Inventory cache commands"},
+	{Text: "connect", Description: "Open another vCenter connection"},
+	{Text: "connections", Description: "List vCenter connections"},
 	{Text: "cr", Description: "Cluster commands"},
 	{Text: "dc", Description: "Datacenter commands"},
 	{Text: "en", Description: "Extension commands"},
 	{Text: "exit", Description: "Exit vcli"},
 	{Text: "help", Description: "Show list of vcli commands"},
+	{Text: "history", Description: "Show command history"},
+	{Text: "host", Description: "ESXi host commands"},
 	{Text: "hx", Description: "HX commands"},
+	{Text: "logout", Description: "End the vCenter session and forget the saved one"},
 	{Text: "version", Description: "Show ESXi or vCenter version"},
 	{Text: "vm", Description: "VM commands"},
 	{Text: "quit", Description: "Exit vcli"},
+	{Text: "set", Description: "Show or change vcli settings"},
+	{Text: "task", Description: "vCenter task commands"},
+	{Text: "use", Description: "Switch the active vCenter connection"},
 }
 
 func commandsCompleter(args []string) []prompt.Suggest {
@@ -31,12 +76,38 @@ func commandsCompleter(args []string) []prompt.Suggest {
 		second := args[1]
 		if len(args) == 2 {
 			subcommands := []prompt.Suggest{
+				{Text: "clone", Description: "Clone VM or template"},
+				{Text: "deploy", Description: "Deploy VMs from template"},
 				{Text: "destroy", Description: "Destroy VM"},
 				{Text: "info", Description: "Show VM info"},
 				{Text: "list", Description: "List all VMs"},
 				{Text: "poweroff", Description: "Poweroff VM"},
 				{Text: "poweron", Description: "Poweron VM"},
+				{Text: "reboot", Description: "Reboot guest OS of VM"},
 				{Text: "reset", Description: "Reset VM"},
+				{Text: "shutdown", Description: "Shut down guest OS of VM"},
+				{Text: "snapshot", Description: "Manage VM snapshots"},
+				{Text: "standby", Description: "Put guest OS of VM in standby"},
+				{Text: "suspend", Description: "Suspend VM"},
+			}
+			return prompt.FilterHasPrefix(subcommands, second, true)
+		}
+		if len(args) == 3 && second == "snapshot" {
+			subcommands := []prompt.Suggest{
+				{Text: "create", Description: "Create a snapshot"},
+				{Text: "list", Description: "Show snapshot tree"},
+				{Text: "remove", Description: "Remove a snapshot"},
+				{Text: "remove-all", Description: "Remove all snapshots"},
+				{Text: "revert", Description: "Revert to a snapshot"},
+			}
+			return prompt.FilterHasPrefix(subcommands, args[2], true)
+		}
+	case "cache":
+		second := args[1]
+		if len(args) == 2 {
+			subcommands := []prompt.Suggest{
+				{Text: "refresh", Description: "Load the inventory cache again"},
+				{Text: "status", Description: "Show the state of the inventory cache"},
 			}
 			return prompt.FilterHasPrefix(subcommands, second, true)
 		}
@@ -56,6 +127,7 @@ func commandsCompleter(args []string) []prompt.Suggest {
 				{Text: "info", Description: "Show details of an extension"},
 				{Text: "register", Description: "Register an extension"},
 				{Text: "unregister", Description: "Unregister extension(s)"},
+				{Text: "update", Description: "Update version or server URL of an extension"},
 			}
 			return prompt.FilterHasPrefix(subcommands, second, true)
 		}
@@ -80,6 +152,66 @@ func commandsCompleter(args []string) []prompt.Suggest {
 			return prompt.FilterHasPrefix(subcommands, second, true)
 		}
 
+	case "host":
+		second := args[1]
+		if len(args) == 2 {
+			subcommands := []prompt.Suggest{
+				{Text: "disconnect", Description: "Disconnect host from vCenter"},
+				{Text: "info", Description: "Show host info"},
+				{Text: "list", Description: "List all hosts"},
+				{Text: "maintenance", Description: "Enter or exit maintenance mode"},
+				{Text: "reboot", Description: "Reboot host"},
+				{Text: "reconnect", Description: "Reconnect host to vCenter"},
+				{Text: "shutdown", Description: "Shut down host"},
+			}
+			return prompt.FilterHasPrefix(subcommands, second, true)
+		}
+		if len(args) == 3 && second == "maintenance" {
+			subcommands := []prompt.Suggest{
+				{Text: "enter", Description: "Enter maintenance mode"},
+				{Text: "exit", Description: "Exit maintenance mode"},
+			}
+			return prompt.FilterHasPrefix(subcommands, args[2], true)
+		}
+	case "set":
+		second := args[1]
+		if len(args) == 2 {
+			subcommands := []prompt.Suggest{
+				{Text: "output", Description: "Set output format"},
+				{Text: "pager", Description: "Page tables longer than the terminal"},
+			}
+			return prompt.FilterHasPrefix(subcommands, second, true)
+		}
+		if len(args) == 3 && second == "output" {
+			formats := []prompt.Suggest{
+				{Text: OUTPUT_TABLE, Description: "Human readable table"},
+				{Text: OUTPUT_JSON, Description: "JSON records"},
+				{Text: OUTPUT_YAML, Description: "YAML records"},
+				{Text: OUTPUT_CSV, Description: "CSV with a header row"},
+			}
+			return prompt.FilterHasPrefix(formats, args[2], true)
+		}
+		if len(args) == 3 && second == "pager" {
+			values := []prompt.Suggest{
+				{Text: "on", Description: "Page long tables"},
+				{Text: "off", Description: "Write tables as they are"},
+			}
+			return prompt.FilterHasPrefix(values, args[2], true)
+		}
+	case "task":
+		second := args[1]
+		if len(args) == 2 {
+			subcommands := []prompt.Suggest{
+				{Text: "cancel", Description: "Cancel running tasks"},
+				{Text: "list", Description: "List recent tasks"},
+				{Text: "watch", Description: "Follow the progress of running tasks"},
+			}
+			return prompt.FilterHasPrefix(subcommands, second, true)
+		}
+	case "use":
+		if len(args) == 2 {
+			return prompt.FilterHasPrefix(connectionSuggestions(false), args[1], true)
+		}
 	case "help":
 		return []prompt.Suggest{}
 	}
@@ -87,17 +219,123 @@ func commandsCompleter(args []string) []prompt.Suggest {
 }
 
 func completer(d prompt.Document) []prompt.Suggest {
+	// the input is the search text of a history search
+	if Search.Update(d.Text) {
+		return []prompt.Suggest{}
+	}
 	if d.TextBeforeCursor() == "" {
 		return []prompt.Suggest{}
 		// return commands
 	}
-	args := strings.Split(d.TextBeforeCursor(), " ")
-	w := d.GetWordBeforeCursor()
+	cl := parseCommandLine(d.TextBeforeCursor())
+	args := cl.args
+	if cl.space {
+		args = append(args, "")
+	}
+	w := args[len(args)-1]
+
+	// complete the connections of -on
+	if len(args) > 1 && args[len(args)-2] == ON_OPTION {
+		return completeArg(connectionSuggestions(true), d, cl, w)
+	}
 
 	// If word before the cursor starts with "-", return options for subcommand
 	if strings.HasPrefix(w, "-") {
 		return optionCompleter(args, strings.HasPrefix(w, "--"))
 	}
 
+	if len(args) > 2 {
+		if kind, ok := inventoryArgs[args[0]][args[1]]; ok {
+			return completeArg(inventorySuggestions(kind, w), d, cl, w)
+		}
+	}
+
 	return commandsCompleter(args)
 }
+
+func connectionSuggestions(all bool) []prompt.Suggest {
+	var s []prompt.Suggest
+	if all {
+		s = append(s, prompt.Suggest{Text: ON_ALL, Description: "All connections"})
+	}
+	for _, v := range Sessions.All() {
+		s = append(s, prompt.Suggest{Text: v.name, Description: v.host})
+	}
+	return s
+}
+
+// inventorySuggestions returns the cached names of a kind, leaving out the
+// names already in the comma separated list being typed
+func inventorySuggestions(kind string, arg string) []prompt.Suggest {
+	v := GetVcli()
+	if v == nil {
+		return []prompt.Suggest{}
+	}
+
+	elements := strings.Split(arg, ",")
+	listed := make(map[string]bool, len(elements))
+	for _, e := range elements[:len(elements)-1] {
+		listed[e] = true
+	}
+
+	var s []prompt.Suggest
+	for _, name := range v.inventory.Names(v, kind) {
+		if !listed[name] {
+			s = append(s, prompt.Suggest{Text: name, Description: inventoryDescriptions[kind]})
+		}
+	}
+	return s
+}
+
+// completeArg suggests the candidates starting with the last element of
+// the argument being typed, ignoring case. go-prompt replaces the word
+// before the cursor with the text of a suggestion, so the text is quoted or
+// escaped to continue what has been typed, and what has been typed is
+// replaced with the name as it is, e.g. ubu becomes Ubuntu-01.
+func completeArg(candidates []prompt.Suggest, d prompt.Document, cl *commandLine, arg string) []prompt.Suggest {
+	word := d.GetWordBeforeCursorUntilSeparator(COMPLETION_SEPARATORS)
+	typed := lastElement(arg)
+
+	// the word continues an open quote or escape, the element is written
+	// in its syntax
+	body := word
+	closing := ""
+	escape := escapeUnquoted
+	switch {
+	case cl.quote == '"':
+		escape = escapeQuoted
+		closing = `"`
+	case cl.quote == '\'':
+		escape = func(s string) string { return s }
+		closing = "'"
+	case cl.escape:
+		body = strings.TrimSuffix(word, `\`)
+	}
+
+	s := []prompt.Suggest{}
+	for _, c := range prompt.FilterHasPrefix(candidates, typed, true) {
+		if len(typed) > len(c.Text) {
+			continue
+		}
+		switch {
+		case cl.quote == 0 && !cl.escape && word == typed:
+			c.Text = quoteArg(c.Text)
+		case strings.HasSuffix(body, escape(typed)):
+			c.Text = strings.TrimSuffix(body, escape(typed)) + escape(c.Text) + closing
+		case strings.HasPrefix(c.Text, typed):
+			// the element started before the word, e.g. after an escaped
+			// space, only the rest of the name can be added
+			c.Text = body + escape(c.Text[len(typed):]) + closing
+		default:
+			// typed before the word in another case, which can't be replaced
+			continue
+		}
+		s = append(s, c)
+	}
+	return s
+}
+
+// lastElement returns the element of a comma separated list being typed
+func lastElement(arg string) string {
+	return arg[strings.LastIndex(arg, ",")+1:]
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	prompt "github.com/c-bata/go-prompt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const CONFIG_FILE = "config.yaml"
+
+// environment variables, they take precedence over the profile and are
+// overridden by command line flags
+const (
+	ENV_PROFILE    = "VCLI_PROFILE"
+	ENV_HOST       = "VCLI_HOST"
+	ENV_USER       = "VCLI_USER"
+	ENV_PASSWORD   = "VCLI_PASSWORD"
+	ENV_INSECURE   = "VCLI_INSECURE"
+	ENV_CA_FILE    = "VCLI_CA_FILE"
+	ENV_THUMBPRINT = "VCLI_THUMBPRINT"
+)
+
+// Config is the content of ~/.config/vcli/config.yaml
+type Config struct {
+	Default  string              `yaml:"default"`
+	Profiles map[string]*Profile `yaml:"profiles"`
+}
+
+// Profile holds the connection settings of one vCenter. The password is
+// never stored in the profile, it is read from a file or the output of a
+// command, e.g. a password manager.
+type Profile struct {
+	Host            string `yaml:"host"`
+	Username        string `yaml:"username"`
+	PasswordFile    string `yaml:"password_file"`
+	PasswordCommand string `yaml:"password_command"`
+	Insecure        bool   `yaml:"insecure"`
+	CAFile          string `yaml:"ca_file"`
+	Thumbprint      string `yaml:"thumbprint"`
+	// Pins are thumbprints of other servers by host, e.g. HX controllers
+	Pins   map[string]string `yaml:"pins"`
+	Output string            `yaml:"output"`
+	Prompt PromptColors      `yaml:"prompt"`
+}
+
+// PromptColors are go-prompt color names, e.g. yellow or darkgray
+type PromptColors struct {
+	Prefix     string `yaml:"prefix"`
+	Input      string `yaml:"input"`
+	Suggestion string `yaml:"suggestion"`
+	Selected   string `yaml:"selected"`
+	Preview    string `yaml:"preview"`
+}
+
+// ActiveProfile is the profile the session was started with, if any
+var ActiveProfile *Profile
+
+var promptColors = map[string]prompt.Color{
+	"default":   prompt.DefaultColor,
+	"black":     prompt.Black,
+	"darkred":   prompt.DarkRed,
+	"darkgreen": prompt.DarkGreen,
+	"brown":     prompt.Brown,
+	"darkblue":  prompt.DarkBlue,
+	"purple":    prompt.Purple,
+	"cyan":      prompt.Cyan,
+	"lightgray": prompt.LightGray,
+	"darkgray":  prompt.DarkGray,
+	"red":       prompt.Red,
+	"green":     prompt.Green,
+	"yellow":    prompt.Yellow,
+	"blue":      prompt.Blue,
+	"fuchsia":   prompt.Fuchsia,
+	"turquoise": prompt.Turquoise,
+	"white":     prompt.White,
+}
+
+func configDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "vcli")
+}
+
+func configPath() string {
+	return filepath.Join(configDir(), CONFIG_FILE)
+}
+
+// loadConfig reads the config file, a missing file is an empty config
+func loadConfig() (*Config, error) {
+	config := &Config{Profiles: make(map[string]*Profile)}
+	path := configPath()
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if config.Profiles == nil {
+		config.Profiles = make(map[string]*Profile)
+	}
+	for name, p := range config.Profiles {
+		if p == nil {
+			return nil, fmt.Errorf("%s: profile '%s' is empty", path, name)
+		}
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("%s: profile '%s': %s", path, name, err)
+		}
+	}
+	return config, nil
+}
+
+// Profile returns the named profile, or the default profile if name is
+// empty. No profile at all is not an error.
+func (c *Config) Profile(name string) (*Profile, error) {
+	if name == "" {
+		name = c.Default
+		if name == "" {
+			return nil, nil
+		}
+	}
+
+	p, ok := c.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(c.Profiles))
+		for n := range c.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("Unknown profile '%s' in %s, expected one of: %s", name, configPath(), strings.Join(names, ", "))
+	}
+	return p, nil
+}
+
+// pinList returns the pins of the profile sorted by host
+func (p *Profile) pinList() pinList {
+	hosts := make([]string, 0, len(p.Pins))
+	for host := range p.Pins {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	pins := make(pinList, 0, len(hosts))
+	for _, host := range hosts {
+		pins = append(pins, hostPin{host, p.Pins[host]})
+	}
+	return pins
+}
+
+func (p *Profile) validate() error {
+	if p.PasswordFile != "" && p.PasswordCommand != "" {
+		return fmt.Errorf("password_file and password_command can't be used together")
+	}
+	if p.Output != "" && !isOutputFormat(p.Output) {
+		return fmt.Errorf("Unknown output format '%s', expected one of %s", p.Output, strings.Join(OutputFormats, ", "))
+	}
+	for host, thumbprint := range p.Pins {
+		if !thumbprintMatch.MatchString(thumbprint) {
+			return fmt.Errorf("Invalid thumbprint '%s' of pin '%s', expected SHA-1 or SHA-256 hex pairs separated by colons", thumbprint, host)
+		}
+	}
+	colors := []string{p.Prompt.Prefix, p.Prompt.Input, p.Prompt.Suggestion, p.Prompt.Selected, p.Prompt.Preview}
+	for _, name := range colors {
+		if _, ok := getPromptColor(name); name != "" && !ok {
+			return fmt.Errorf("Unknown prompt color '%s'", name)
+		}
+	}
+	return nil
+}
+
+// Password resolves the credential reference of the profile
+func (p *Profile) Password() (string, error) {
+	switch {
+	case p.PasswordFile != "":
+		data, err := ioutil.ReadFile(expandHome(p.PasswordFile))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case p.PasswordCommand != "":
+		out, err := exec.Command("sh", "-c", p.PasswordCommand).Output()
+		if err != nil {
+			return "", fmt.Errorf("password_command failed: %s", err)
+		}
+		return strings.TrimRight(string(out), "\r\n"), nil
+	}
+	return "", nil
+}
+
+func getPromptColor(name string) (prompt.Color, bool) {
+	c, ok := promptColors[strings.ToLower(name)]
+	return c, ok
+}
+
+// promptColor returns the color of the given name, or def if not set
+func promptColor(name string, def prompt.Color) prompt.Color {
+	if c, ok := getPromptColor(name); name != "" && ok {
+		return c
+	}
+	return def
+}
+
+// expandHome replaces a leading ~ with the home directory
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const DRY_RUN_MESSAGE = "Dry run, nothing has been changed"
+
+// stdinScript is set while a script is read from stdin, which leaves no
+// terminal to answer a confirmation prompt
+var stdinScript bool
+
+// columns of the objects a destructive command is going to change
+var planColumns = []Column{
+	{Header: "Type", Field: "type"},
+	{Header: "Name", Field: "name"},
+	{Header: "Host", Field: "host"},
+}
+
+func NewPlanTable() *Table {
+	return NewTable(planColumns...)
+}
+
+// DryRunResult returns the plan of a destructive command run with -dry-run
+func DryRunResult(plan *Table) *Result {
+	r := TableResult(plan)
+	r.Message(DRY_RUN_MESSAGE)
+	return r
+}
+
+// confirmValue is what has to be typed to confirm a change of the given
+// objects: the name of a single object or the number of objects
+func confirmValue(names []string) string {
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strconv.Itoa(len(names))
+}
+
+// promptMu serializes the questions asked on the terminal, commands run
+// with -on may ask from one goroutine per connection at the same time
+var promptMu sync.Mutex
+
+// stdinReader reads the answers of all questions, a reader per question
+// would swallow input read ahead for the next one
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// lockPrompt stops the spinner and takes the terminal for a question until
+// the returned function is called
+func lockPrompt() func() {
+	promptMu.Lock()
+	spinning := Spinner.Active()
+	if spinning {
+		Spinner.Stop()
+	}
+	return func() {
+		if spinning {
+			Spinner.Start()
+		}
+		promptMu.Unlock()
+	}
+}
+
+// canPrompt reports whether a confirmation can be read from a terminal
+func canPrompt() bool {
+	return !stdinScript && isatty.IsTerminal(os.Stdin.Fd())
+}
+
+// confirm shows the plan of a destructive command and asks the user to
+// type the expected value. With yes set the plan is accepted as is.
+func confirm(question string, expected string, plan *Table, yes bool) error {
+	if yes {
+		return nil
+	}
+	if !canPrompt() {
+		return fmt.Errorf("%s Refusing to continue without a terminal, use -yes to confirm", question)
+	}
+
+	defer lockPrompt()()
+
+	out, err := plan.Render(OUTPUT_TABLE)
+	if err != nil {
+		return err
+	}
+
+	// the prompt goes to stderr to keep machine-readable output clean
+	os.Stderr.Write(out)
+	warn := color.New(color.FgYellow)
+	warn.Fprintln(os.Stderr, question)
+	warn.Fprintf(os.Stderr, "Type '%s' to confirm: ", expected)
+
+	answer, err := stdinReader.ReadString('\n')
+	if err != nil && answer == "" {
+		return fmt.Errorf("No confirmation, nothing has been changed")
+	}
+	if strings.TrimSpace(answer) != expected {
+		return fmt.Errorf("Confirmation doesn't match '%s', nothing has been changed", expected)
+	}
+	return nil
+}
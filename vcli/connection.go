@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"golang.org/x/crypto/ssh/terminal"
+	"os"
+	"strings"
+	"sync"
+)
+
+type ConnectCommand struct{}
+type ConnectionsCommand struct{}
+type UseCommand struct{}
+
+// option of every remote command to run it against other connections
+const ON_OPTION = "-on"
+const ON_ALL = "all"
+
+// commands that don't talk to a vCenter and can't be used with -on
+var localCommands = map[string]bool{
+	"connect":     true,
+	"connections": true,
+	"exit":        true,
+	"help":        true,
+	"history":     true,
+	"quit":        true,
+	"set":         true,
+	"use":         true,
+}
+
+// Connections are the vCenter sessions of a vcli process. Commands run
+// against the active connection unless -on is given.
+type Connections struct {
+	conns  map[string]*Vcli
+	names  []string
+	active string
+	mu     sync.Mutex
+}
+
+var Sessions = &Connections{conns: make(map[string]*Vcli)}
+
+// Add adds a connection and makes it active
+func (c *Connections) Add(v *Vcli) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.conns[v.name]; ok {
+		return fmt.Errorf("Connection '%s' already exists", v.name)
+	}
+	c.conns[v.name] = v
+	c.names = append(c.names, v.name)
+	c.active = v.name
+	return nil
+}
+
+func (c *Connections) Use(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.conns[name]; !ok {
+		return fmt.Errorf("Unknown connection '%s', expected one of: %s", name, strings.Join(c.names, ", "))
+	}
+	c.active = name
+	return nil
+}
+
+func (c *Connections) Active() *Vcli {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conns[c.active]
+}
+
+// All returns the connections in the order they were opened
+func (c *Connections) All() []*Vcli {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	all := make([]*Vcli, 0, len(c.names))
+	for _, name := range c.names {
+		all = append(all, c.conns[name])
+	}
+	return all
+}
+
+func (c *Connections) Names() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, len(c.names))
+	copy(names, c.names)
+	return names
+}
+
+// Select returns the connections of a comma separated list of names, or
+// all connections for 'all'
+func (c *Connections) Select(spec string) ([]*Vcli, error) {
+	if spec == ON_ALL {
+		return c.All(), nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var selected []*Vcli
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		v, ok := c.conns[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown connection '%s', expected one of: %s", name, strings.Join(c.names, ", "))
+		}
+		seen[name] = true
+		selected = append(selected, v)
+	}
+	if len(selected) == 0 {
+		return nil, errors.New("No connection given for -on")
+	}
+	return selected, nil
+}
+
+// LogoutAll ends the sessions of all connections
+func (c *Connections) LogoutAll() error {
+	var errs []string
+	for _, v := range c.All() {
+		if err := v.Logout(); err != nil {
+			errs = append(errs, v.name+": "+err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (cmd *ConnectCommand) Usage() string {
+	return `Usage: connect [options] name host [user]
+       connect -profile profile-name [name]
+
+Open another connection to an ESXi or vCenter host and make it active.
+The password is asked for unless it is given, comes from the profile or
+a saved session of the profile or user@host is still valid.
+
+Options:
+  -profile=name    Use the host, user and credentials of a profile
+  -p=password      Password of the user, it is not kept in the history
+  -k               Don't verify the server certificate
+
+Examples:
+  connect vc2 vcenter2.example.com administrator@vsphere.local
+  connect -profile lab-sjc
+`
+}
+
+func (cmd *ConnectCommand) Execute(v *Vcli, args ...string) (*Result, error) {
+	connectCmd := flag.NewFlagSet("connect", flag.ContinueOnError)
+	profileName := connectCmd.String("profile", "", "Connection profile")
+	insecure := connectCmd.Bool("k", false, "Don't verify the server certificate")
+	password := connectCmd.String("p", "", "Password")
+	names, err := parseFlags(connectCmd, args)
+	if err != nil {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	profile := &Profile{}
+	if *profileName != "" {
+		config, err := loadConfig()
+		if err != nil {
+			return nil, err
+		}
+		if profile, err = config.Profile(*profileName); err != nil {
+			return nil, err
+		}
+		if len(names) == 0 {
+			names = append(names, *profileName)
+		}
+	}
+
+	if len(names) < 1 || len(names) > 3 || (len(names) == 1 && profile.Host == "") {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	name := names[0]
+	host := profile.Host
+	username := profile.Username
+	if len(names) > 1 {
+		host = names[1]
+	}
+	if len(names) > 2 {
+		username = names[2]
+	}
+	if username == "" {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	u, err := getURL(host, "", "")
+	if err != nil {
+		return nil, err
+	}
+	if *insecure || profile.Insecure {
+		Trust.SkipVerify(u.Host)
+	}
+	if profile.CAFile != "" {
+		if err := Trust.SetHostCAFile(u.Host, expandHome(profile.CAFile)); err != nil {
+			return nil, err
+		}
+	}
+	if profile.Thumbprint != "" {
+		if err := Trust.Pin(u.Host, profile.Thumbprint); err != nil {
+			return nil, err
+		}
+	}
+	for _, pin := range profile.pinList() {
+		if err := Trust.Pin(pin.host, pin.thumbprint); err != nil {
+			return nil, err
+		}
+	}
+
+	if *password == "" {
+		if *password, err = profile.Password(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, n := range Sessions.Names() {
+		if n == name {
+			return nil, fmt.Errorf("Connection '%s' already exists", name)
+		}
+	}
+
+	conn, err := New(name, host, username, *password, *profileName)
+	if err != nil {
+		return nil, err
+	}
+	if err := Sessions.Add(conn); err != nil {
+		return nil, err
+	}
+	conn.inventory.Start(conn)
+
+	a := conn.client.Client.ServiceContent.About
+	return MessageResult("Connected '%s' to %s running %s %s", name, conn.host, a.Name, a.Version), nil
+}
+
+// readPassword asks for a password on the terminal
+func readPassword(prompt string) (string, error) {
+	if !canPrompt() {
+		return "", errors.New("No terminal to read the password from, use a profile with a credential reference")
+	}
+	defer lockPrompt()()
+
+	fmt.Fprint(os.Stderr, prompt)
+	passwd, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return string(passwd), err
+}
+
+func (cmd *ConnectionsCommand) Execute(v *Vcli, args ...string) (*Result, error) {
+	tbl := NewTable([]Column{
+		{Header: "Active", Field: "active"},
+		{Header: "Name", Field: "name"},
+		{Header: "Host", Field: "host"},
+		{Header: "User", Field: "user"},
+		{Header: "Product", Field: "product"},
+		{Header: "Version", Field: "version"},
+	}...)
+
+	active := Sessions.Active()
+	for _, conn := range Sessions.All() {
+		isActive := conn == active
+		marker := ""
+		if isActive {
+			marker = "*"
+		}
+		a := conn.client.Client.ServiceContent.About
+		tbl.AddRow(NewCell(marker, isActive), conn.name, conn.host, conn.auth.username, a.Name, a.Version)
+	}
+	return TableResult(tbl), nil
+}
+
+func (cmd *UseCommand) Usage() string {
+	return `Usage: use name
+
+Make a connection the target of the following commands
+
+Examples:
+  use vc2
+`
+}
+
+func (cmd *UseCommand) Execute(v *Vcli, args ...string) (*Result, error) {
+	if len(args) != 1 {
+		return UsageResult(cmd.Usage()), nil
+	}
+	if err := Sessions.Use(args[0]); err != nil {
+		return nil, err
+	}
+	return MessageResult("Using '%s'", args[0]), nil
+}
+
+// getTargets removes the -on option from the arguments of a command and
+// returns the connections it names, nil without -on
+func getTargets(command string, args []string) ([]*Vcli, []string, error) {
+	var spec string
+	var found bool
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == ON_OPTION:
+			if i+1 >= len(args) {
+				return nil, nil, errors.New("-on needs 'all' or a list of connections")
+			}
+			spec, found = args[i+1], true
+			i++
+		case strings.HasPrefix(arg, ON_OPTION+"="):
+			spec, found = strings.TrimPrefix(arg, ON_OPTION+"="), true
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	if !found {
+		return nil, args, nil
+	}
+	if localCommands[command] {
+		return nil, nil, fmt.Errorf("-on can't be used with '%s'", command)
+	}
+	targets, err := Sessions.Select(spec)
+	return targets, rest, err
+}
+
+// runOnAll runs a command against several connections in parallel and
+// merges the results
+func runOnAll(ctx context.Context, targets []*Vcli, fn Command, args []string) *Result {
+	var wg sync.WaitGroup
+	results := make([]*Result, len(targets))
+	errs := make([]error, len(targets))
+
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target *Vcli) {
+			defer wg.Done()
+			results[i], errs[i] = runOn(ctx, target, fn, args)
+		}(i, target)
+	}
+	wg.Wait()
+
+	return mergeResults(targets, results, errs)
+}
+
+// mergeResults puts the tables of several connections into one with a
+// vCenter column, messages and errors are prefixed with the connection
+func mergeResults(targets []*Vcli, results []*Result, errs []error) *Result {
+	merged := NewResult()
+	for i, r := range results {
+		if r != nil && r.Usage != "" {
+			return r
+		}
+		name := targets[i].name
+		if errs[i] != nil {
+			merged.AddError(fmt.Errorf("%s: %s", name, errs[i]))
+		}
+		if r == nil {
+			continue
+		}
+
+		if r.Table != nil {
+			if merged.Table == nil {
+				cols := append([]Column{{Header: "vCenter", Field: "vcenter"}}, r.Table.Columns...)
+				merged.Table = NewTable(cols...)
+				merged.Table.Vertical = r.Table.Vertical
+			}
+			for _, row := range r.Table.Rows {
+				merged.Table.AddRow(append([]interface{}{name}, row...)...)
+			}
+		}
+		for _, m := range r.Messages {
+			merged.Message("%s: %s", name, m)
+		}
+		for _, e := range r.Errors {
+			merged.AddError(fmt.Errorf("%s: %s", name, e))
+		}
+	}
+	return merged
+}
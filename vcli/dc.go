@@ -3,13 +3,11 @@ package main
 import (
 	_ "context"
 	"errors"
-	"github.com/tatsushid/go-prettytable"
-	"github.com/vmware/govmomi/find"
+	"flag"
+	"fmt"
+	"github.com/go/vcli/filter"
 	_ "github.com/vmware/govmomi/object"
-	"github.com/vmware/govmomi/property"
-	"github.com/vmware/govmomi/vim25/mo"
-	"github.com/vmware/govmomi/vim25/types"
-	"path"
+	"strings"
 )
 
 type DcCommand struct{}
@@ -23,20 +21,17 @@ var dcCommands = map[string]Command{
 	DC_LIST: &DcListCommand{},
 }
 
-func (c *DcCommand) Execute(v *Vcli, args ...string) (*prettytable.Table, error) {
+func (c *DcCommand) Execute(v *Vcli, args ...string) (*Result, error) {
 	if len(args) > 0 {
 		cmd := args[0]
 		options := args[1:]
 		if fn, ok := dcCommands[cmd]; ok {
 			t, err := fn.Execute(v, options...)
 			return t, err
-		} else {
-			Error("Unknown subcommand '%s' for vm\n", cmd)
 		}
-		return nil, nil
+		return nil, fmt.Errorf("Unknown subcommand '%s' for dc", cmd)
 	}
-	Usage(c.Usage())
-	return nil, nil
+	return UsageResult(c.Usage()), nil
 }
 
 func (cmd *DcCommand) Usage() string {
@@ -46,34 +41,33 @@ Commands:
   list    List all datacenters`
 }
 
-func (cmd *DcListCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table, error) {
-	ctx := cli.ctx
-	c := cli.client.Client
+func (cmd *DcListCommand) Usage() string {
+	return `Usage: dc list [options]
 
-	finder := find.NewFinder(c, false)
-	objects, err := finder.DatacenterList(ctx, "*")
-	if err != nil {
-		return nil, err
-	}
+List all datacenters
 
-	props := []string{
-		"name",
-		"vmFolder",
-		"hostFolder",
-		"datastoreFolder",
-		"networkFolder",
-		"datastore",
-		"network",
-	}
+Options:
+` + listOptionsHelp + `
 
-	refs := make([]types.ManagedObjectReference, 0, len(objects))
-	for _, o := range objects {
-		refs = append(refs, o.Reference())
+Columns:
+  name, path, hosts, clusters
+
+` + filterHelp + `
+
+Examples:
+  dc list -filter clusters==0
+  dc list -sort hosts:desc
+`
+}
+
+func (cmd *DcListCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	listCmd := flag.NewFlagSet("list", flag.ContinueOnError)
+	opts := newListFlags(listCmd)
+	if _, err := parseFlags(listCmd, args); err != nil {
+		return UsageResult(cmd.Usage()), nil
 	}
 
-	var datacenters []mo.Datacenter
-	pc := property.DefaultCollector(c)
-	err = pc.Retrieve(ctx, refs, props, &datacenters)
+	datacenters, err := cli.inventory.Objects(cli.ctx, cli, INVENTORY_DATACENTER)
 	if err != nil {
 		return nil, err
 	}
@@ -82,33 +76,41 @@ func (cmd *DcListCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table
 		return nil, errors.New("No datacenters found")
 	}
 
-	objs := make(map[types.ManagedObjectReference]mo.Datacenter, len(datacenters))
-
-	for _, o := range datacenters {
-		objs[o.Reference()] = o
+	hosts, err := cli.inventory.Objects(cli.ctx, cli, INVENTORY_HOST)
+	if err != nil {
+		return nil, err
+	}
+	clusters, err := cli.inventory.Objects(cli.ctx, cli, INVENTORY_CLUSTER)
+	if err != nil {
+		return nil, err
 	}
 
-	tbl, err := prettytable.NewTable([]prettytable.Column{
-		{Header: "#"},
-		{Header: "Name", MinWidth: 6},
-		{Header: "Path"},
-		{Header: "Hosts"},
-		{Header: "Clusters"},
+	tbl := NewTable([]Column{
+		{Header: "#", Field: "index"},
+		{Header: "Name", Field: "name", MinWidth: 6},
+		{Header: "Path", Field: "path"},
+		{Header: "Hosts", Field: "hosts", Kind: filter.Number},
+		{Header: "Clusters", Field: "clusters", Kind: filter.Number},
 	}...)
 
-	for i, o := range objects {
-		dc := objs[o.Reference()]
-		folders, err := o.Folders(ctx)
-
-		if err != nil {
-			return nil, err
-		}
+	for i, dc := range datacenters {
+		tbl.AddRow(i+1, dc.Name, dc.Path, countWithin(hosts, dc.Path), countWithin(clusters, dc.Path))
+	}
 
-		finder.SetDatacenter(o)
-		hosts, _ := finder.HostSystemList(ctx, path.Join(folders.HostFolder.InventoryPath, "*"))
-		clusters, _ := finder.ClusterComputeResourceList(ctx, path.Join(folders.HostFolder.InventoryPath, "*"))
-		tbl.AddRow(i+1, dc.Name, o.InventoryPath, len(hosts), len(clusters))
+	tbl, err = opts.Apply(tbl, nil)
+	if err != nil {
+		return nil, err
 	}
+	return TableResult(tbl), nil
+}
 
-	return tbl, nil
+// countWithin counts the objects below an inventory path
+func countWithin(objects []*InventoryObject, parent string) int {
+	count := 0
+	for _, o := range objects {
+		if strings.HasPrefix(o.Path, parent+"/") {
+			count++
+		}
+	}
+	return count
 }
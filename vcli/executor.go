@@ -1,43 +1,261 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"strings"
 )
 
 const (
 	INVALID_SESSION = "session is not authenticated"
+	SCRIPT_COMMENT  = "#"
 )
 
+// errUsage is returned by execute when a command has been used wrongly and
+// its usage has been shown
+var errUsage = errors.New("Invalid usage")
+
+// errExit is returned by execute for 'exit' and 'quit', the caller ends vcli
+// with the exit code collected so far
+var errExit = errors.New("Exit")
+
+// exitCode returns the exit code of a failed command
+func exitCode(err error) int {
+	if err == errUsage {
+		return EXIT_USAGE
+	}
+	return EXIT_ERROR
+}
+
+// exit codes for non-interactive mode
+const (
+	EXIT_OK    = 0
+	EXIT_ERROR = 1
+	EXIT_USAGE = 2
+	// exit code of a second Ctrl-C while a command runs, like a shell
+	EXIT_INTERRUPTED = 130
+)
+
+// executor is the go-prompt handler, errors are already printed by execute.
+// History references like !42 are expanded and the command is added to
+// the history.
 func executor(command string) {
-	vcli := GetVcli()
-	cmds := strings.Split(strings.Trim(command, " "), " ")
+	command = strings.TrimSpace(Search.Accept(command))
+	if strings.HasPrefix(command, HISTORY_REF) {
+		line, err := CmdHistory.Expand(command)
+		if err != nil {
+			Errorln(err.Error())
+			return
+		}
+		Notice("==> " + line)
+		command = line
+	}
+
+	if err := CmdHistory.Add(command); err != nil {
+		Warnln("Failed to save history: " + err.Error())
+	}
+	if execute(command) == errExit {
+		os.Exit(EXIT_OK)
+	}
+}
+
+// execute runs a single vcli command line and returns the command error, if any
+func execute(command string) error {
+	cmds, err := splitArgs(command)
+	if err != nil {
+		Errorln(err.Error())
+		return err
+	}
 
-	if len(command) > 0 && len(cmds) > 0 {
+	if len(cmds) > 0 {
 		pCmd := cmds[0]
 		options := cmds[1:]
 
 		if fn, ok := Commands[pCmd]; ok {
+			targets, options, err := getTargets(pCmd, options)
+			if err != nil {
+				Errorln(err.Error())
+				return err
+			}
+
+			timing, options := getTiming(options)
+
 			// Start spinner before executing the command
 			Spinner.Start()
-			t, err := fn.Execute(vcli, options...)
+			ctx, stop := commandContext()
+			ctx = withTiming(ctx, timing)
+			var r *Result
+			if targets != nil {
+				r = runOnAll(ctx, targets, fn, options)
+			} else {
+				targets = []*Vcli{GetVcli()}
+				r, err = runOn(ctx, targets[0], fn, options)
+			}
+			cancelled := ctx.Err() == context.Canceled
+			stop()
 			// Stop spinner once command execution is finished
 			Spinner.Stop()
 
-			if err != nil {
-				if strings.Contains(err.Error(), INVALID_SESSION) {
-					Errorln(err.Error() + " Exiting...")
-					os.Exit(1)
+			if timing != nil {
+				defer timing.Print()
+			}
+
+			// the command may have changed the inventory
+			if isMutating(pCmd, options) {
+				for _, target := range targets {
+					target.inventory.Invalidate()
 				}
+			}
+
+			// errors and partial results of a cancelled command are of no use
+			if cancelled {
+				Warnln(errCancelled.Error())
+				return errCancelled
+			}
+			if err != nil {
 				Errorln(err.Error())
-				return
+				return err
 			}
 			// Print command response
-			if t != nil {
-				t.Print()
+			if r != nil {
+				r.Print()
+				if r.exit {
+					return errExit
+				}
+				if r.Usage != "" {
+					return errUsage
+				}
+				return r.Err()
 			}
 		} else {
-			Error("Unknown command: '%s'\n", pCmd)
+			err := fmt.Errorf("Unknown command: '%s'", pCmd)
+			Errorln(err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// commands that change the inventory, given as "command subcommand" and
+// "vm snapshot subcommand". The inventory cache is brought up to date before
+// the next lookup after them, other changes arrive with the inventory watch.
+var mutatingCommands = map[string]bool{
+	"vm " + VM_CLONE:                     true,
+	"vm " + VM_DEPLOY:                    true,
+	"vm " + VM_DESTROY:                   true,
+	"vm " + VM_POWEROFF:                  true,
+	"vm " + VM_POWERON:                   true,
+	"vm " + VM_REBOOT:                    true,
+	"vm " + VM_RESET:                     true,
+	"vm " + VM_SHUTDOWN:                  true,
+	"vm " + VM_STANDBY:                   true,
+	"vm " + VM_SUSPEND:                   true,
+	"vm snapshot " + SNAPSHOT_CREATE:     true,
+	"vm snapshot " + SNAPSHOT_REVERT:     true,
+	"vm snapshot " + SNAPSHOT_REMOVE:     true,
+	"vm snapshot " + SNAPSHOT_REMOVE_ALL: true,
+	"host " + HOST_MAINTENANCE:           true,
+	"host " + HOST_REBOOT:                true,
+	"host " + HOST_SHUTDOWN:              true,
+	"host " + HOST_DISCONNECT:            true,
+	"host " + HOST_RECONNECT:             true,
+	"hx " + HX_DESTROY:                   true,
+	"en " + EN_REGISTER:                  true,
+	"en " + EN_UNREGISTER:                true,
+	"en " + EN_UPDATE:                    true,
+	"task " + TASK_CANCEL:                true,
+}
+
+// isMutating reports whether a command line may change the inventory
+func isMutating(command string, args []string) bool {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			break
+		}
+		command += " " + arg
+		if mutatingCommands[command] {
+			return true
 		}
 	}
+	return false
+}
+
+// runOn runs a command against one connection with the context of the
+// command run. If the session has expired it logs in again and retries
+// the command once.
+func runOn(ctx context.Context, vcli *Vcli, fn Command, options []string) (*Result, error) {
+	base := vcli.ctx
+	vcli.ctx = ctx
+	defer func() { vcli.ctx = base }()
+
+	r, err := fn.Execute(vcli, options...)
+	if isSessionExpired(err) {
+		Progress("Session of '%s' expired, logging in again...", vcli.name)
+		if lerr := vcli.Relogin(); lerr != nil {
+			return nil, fmt.Errorf("%s Failed to log in again: %s", err, lerr)
+		}
+		r, err = fn.Execute(vcli, options...)
+	}
+	return r, err
+}
+
+// runCommand executes a one-shot command given with -c and returns the exit code
+func runCommand(command string) int {
+	if err := execute(command); err != nil && err != errExit {
+		return exitCode(err)
+	}
+	return EXIT_OK
+}
+
+// runScript executes the commands in a script file one after another.
+// Blank lines and lines starting with '#' are skipped. Unless keepGoing
+// is set, execution stops at the first failing command.
+func runScript(file string, keepGoing bool) int {
+	var r io.Reader
+	if file == "-" {
+		r = os.Stdin
+		stdinScript = true
+	} else {
+		f, err := os.Open(file)
+		if err != nil {
+			Errorln(err)
+			return EXIT_USAGE
+		}
+		defer f.Close()
+		r = f
+	}
+
+	code := EXIT_OK
+	lineNo := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, SCRIPT_COMMENT) {
+			continue
+		}
+
+		Notice("==> " + line)
+		err := execute(line)
+		if err == errExit {
+			return code
+		}
+		if err != nil {
+			code = exitCode(err)
+			if !keepGoing {
+				Errorln(fmt.Sprintf("%s:%d: stopped on error", file, lineNo))
+				return code
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		Errorln(err)
+		return EXIT_ERROR
+	}
+	return code
 }
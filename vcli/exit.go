@@ -1,22 +1,14 @@
 package main
 
-import (
-	"github.com/tatsushid/go-prettytable"
-	"os"
-)
-
 type ExitCommand struct{}
 
-func (c *ExitCommand) Execute(v *Vcli, args ...string) (*prettytable.Table, error) {
-	// Exiting the application, stop spinner
-	if Spinner.Active() {
-		Spinner.Stop()
-	}
-	err := v.client.Logout(v.ctx)
-	if err != nil {
-		Errorln(err)
+// 'exit' and 'quit' command handler, the executor exits vcli after
+// printing the result
+func (c *ExitCommand) Execute(v *Vcli, args ...string) (*Result, error) {
+	r := MessageResult("Good Bye!")
+	r.exit = true
+	if err := Sessions.LogoutAll(); err != nil {
+		r.AddError(err)
 	}
-	Message("Good Bye!")
-	os.Exit(0)
-	return nil, nil
+	return r, nil
 }
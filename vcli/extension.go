@@ -1,15 +1,20 @@
 package main
 
 import (
+	"bytes"
 	_ "context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"github.com/tatsushid/go-prettytable"
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/vim25/types"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/url"
+	"regexp"
 	"strings"
+	"time"
 )
 
 type EnCommand struct{}
@@ -17,12 +22,14 @@ type EnListCommand struct{}
 type EnInfoCommand struct{}
 type EnRegisterCommand struct{}
 type EnUnregisterCommand struct{}
+type EnUpdateCommand struct{}
 
 const (
 	EN_LIST             = "list"
 	EN_INFO             = "info"
 	EN_REGISTER         = "register"
 	EN_UNREGISTER       = "unregister"
+	EN_UPDATE           = "update"
 	MAX_DESCRIPTION_LEN = 40
 )
 
@@ -31,22 +38,23 @@ var enCommands = map[string]Command{
 	EN_INFO:       &EnInfoCommand{},
 	EN_REGISTER:   &EnRegisterCommand{},
 	EN_UNREGISTER: &EnUnregisterCommand{},
+	EN_UPDATE:     &EnUpdateCommand{},
 }
 
-func (c *EnCommand) Execute(v *Vcli, args ...string) (*prettytable.Table, error) {
+// thumbprint of a server certificate, SHA-1 or SHA-256 hex pairs separated by colons
+var thumbprintMatch = regexp.MustCompile(`^([0-9A-Fa-f]{2}:){19}[0-9A-Fa-f]{2}$|^([0-9A-Fa-f]{2}:){31}[0-9A-Fa-f]{2}$`)
+
+func (c *EnCommand) Execute(v *Vcli, args ...string) (*Result, error) {
 	if len(args) > 0 {
 		cmd := args[0]
 		options := args[1:]
 		if fn, ok := enCommands[cmd]; ok {
 			t, err := fn.Execute(v, options...)
 			return t, err
-		} else {
-			Error("Unknown subcommand '%s' for en\n", cmd)
 		}
-		return nil, nil
+		return nil, fmt.Errorf("Unknown subcommand '%s' for en", cmd)
 	}
-	Usage(c.Usage())
-	return nil, nil
+	return UsageResult(c.Usage()), nil
 }
 
 func (c *EnCommand) Usage() string {
@@ -57,10 +65,40 @@ Commands:
   info         Show details of an extension
   register     Register extension
   unregister   Unregister extension
+  update       Update version or server URL of an extension
 `
 }
 
-func (cmd *EnListCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table, error) {
+func (cmd *EnListCommand) Usage() string {
+	return `Usage: en list [options]
+
+List all extensions
+
+Options:
+  -grep=pattern     Show only the extensions whose key contains pattern
+` + listOptionsHelp + `
+
+Columns:
+  name, version, description, company
+
+` + filterHelp + `
+
+Examples:
+  en list -grep vmware
+  en list -filter 'company=~"(?i)cisco" || name==com.cisco.*'
+  en list -sort company,name -cols name,company
+`
+}
+
+func (cmd *EnListCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	listCmd := flag.NewFlagSet("list", flag.ContinueOnError)
+	listGrep := listCmd.String("grep", "", "Search pattern")
+	opts := newListFlags(listCmd)
+	if _, err := parseFlags(listCmd, args); err != nil {
+		return UsageResult(cmd.Usage()), nil
+	}
+	filter := *listGrep
+
 	ctx := cli.ctx
 	c := cli.client.Client
 
@@ -79,25 +117,17 @@ func (cmd *EnListCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table
 		exts[e.Key] = e
 	}
 
-	var filter string
-	listCmd := flag.NewFlagSet("list", flag.ContinueOnError)
-	listGrep := listCmd.String("grep", "", "Search pattern")
-	listCmd.Parse(args)
-
-	if listGrep != nil {
-		filter = *listGrep
-	}
-
-	tbl, err := prettytable.NewTable([]prettytable.Column{
-		{Header: "#"},
-		{Header: "Name"},
-		{Header: "Version"},
-		{Header: "Description"},
-		{Header: "Company"},
+	tbl := NewTable([]Column{
+		{Header: "#", Field: "index"},
+		{Header: "Name", Field: "key", Key: "name"},
+		{Header: "Version", Field: "version"},
+		{Header: "Description", Field: "description"},
+		{Header: "Company", Field: "company"},
 	}...)
 
 	for index, e := range list {
-		desc := e.Description.GetDescription().Summary
+		summary := e.Description.GetDescription().Summary
+		desc := summary
 		if len(desc) > MAX_DESCRIPTION_LEN {
 			desc = desc[:MAX_DESCRIPTION_LEN] + "..."
 		}
@@ -110,14 +140,18 @@ func (cmd *EnListCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table
 				}
 			}
 		*/
-		if listGrep != nil && !strings.Contains(e.Key, filter) {
+		if !strings.Contains(e.Key, filter) {
 			continue
 		}
 		//tbl.AddRow(index+1, e.Key, e.Version, e.Description.GetDescription().Summary, e.Type, e.Company)
-		tbl.AddRow(index+1, e.Key, e.Version, desc, e.Company)
+		tbl.AddRow(index+1, e.Key, e.Version, NewCell(desc, summary), e.Company)
 	}
 
-	return tbl, nil
+	tbl, err = opts.Apply(tbl, nil)
+	if err != nil {
+		return nil, err
+	}
+	return TableResult(tbl), nil
 }
 
 func (c *EnInfoCommand) Usage() string {
@@ -128,10 +162,9 @@ Examples:
 `
 }
 
-func (cmd *EnInfoCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table, error) {
+func (cmd *EnInfoCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
 	if len(args) <= 0 {
-		Usage(cmd.Usage())
-		return nil, nil
+		return UsageResult(cmd.Usage()), nil
 	}
 
 	key := args[0]
@@ -150,38 +183,423 @@ func (cmd *EnInfoCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table
 
 	for _, e := range list {
 		if e.Key == key {
-			data, err := json.MarshalIndent(&e, "", "  ")
-			if err != nil {
-				return nil, err
-			}
-			Spinner.Stop()
-			fmt.Printf("%s\n", data)
-			return nil, nil
+			tbl := NewTable(extensionColumns...)
+			tbl.Vertical = true
+			tbl.AddRow(extensionRow(&e)...)
+			return TableResult(tbl), nil
 		}
 	}
 
 	return nil, errors.New("Extension '" + key + "' is not found")
 }
 
-func (cmd *EnRegisterCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table, error) {
-	return nil, nil
+// ExtensionServer is the json/yaml shape of an extension's server info
+type ExtensionServer struct {
+	Url         string   `json:"url" yaml:"url"`
+	Type        string   `json:"type" yaml:"type"`
+	Company     string   `json:"company" yaml:"company"`
+	Description string   `json:"description" yaml:"description"`
+	Thumbprint  string   `json:"thumbprint" yaml:"thumbprint"`
+	AdminEmail  []string `json:"admin_email" yaml:"admin_email"`
+}
+
+// ExtensionClient is the json/yaml shape of an extension's client info
+type ExtensionClient struct {
+	Url         string `json:"url" yaml:"url"`
+	Type        string `json:"type" yaml:"type"`
+	Company     string `json:"company" yaml:"company"`
+	Description string `json:"description" yaml:"description"`
+	Version     string `json:"version" yaml:"version"`
+}
+
+var extensionColumns = []Column{
+	{Header: "Key", Field: "key"},
+	{Header: "Label", Field: "label"},
+	{Header: "Description", Field: "description"},
+	{Header: "Version", Field: "version"},
+	{Header: "Company", Field: "company"},
+	{Header: "Type", Field: "type"},
+	{Header: "Subject name", Field: "subject_name"},
+	{Header: "Last heartbeat", Field: "last_heartbeat_time"},
+	{Header: "Servers", Field: "server"},
+	{Header: "Clients", Field: "client"},
+}
+
+func extensionRow(e *types.Extension) []interface{} {
+	var label, summary string
+	if e.Description != nil {
+		label = e.Description.GetDescription().Label
+		summary = e.Description.GetDescription().Summary
+	}
+
+	servers := make([]ExtensionServer, 0, len(e.Server))
+	urls := make([]string, 0, len(e.Server))
+	for _, s := range e.Server {
+		servers = append(servers, ExtensionServer{
+			Url:         s.Url,
+			Type:        s.Type,
+			Company:     s.Company,
+			Description: descriptionLabel(s.Description),
+			Thumbprint:  s.ServerThumbprint,
+			AdminEmail:  s.AdminEmail,
+		})
+		urls = append(urls, s.Url)
+	}
+
+	clients := make([]ExtensionClient, 0, len(e.Client))
+	clientUrls := make([]string, 0, len(e.Client))
+	for _, c := range e.Client {
+		clients = append(clients, ExtensionClient{
+			Url:         c.Url,
+			Type:        c.Type,
+			Company:     c.Company,
+			Description: descriptionLabel(c.Description),
+			Version:     c.Version,
+		})
+		clientUrls = append(clientUrls, c.Url)
+	}
+
+	return []interface{}{
+		e.Key,
+		label,
+		summary,
+		e.Version,
+		e.Company,
+		e.Type,
+		e.SubjectName,
+		NewCell(e.LastHeartbeatTime.String(), e.LastHeartbeatTime),
+		NewCell(strings.Join(urls, ", "), servers),
+		NewCell(strings.Join(clientUrls, ", "), clients),
+	}
+}
+
+func descriptionLabel(d types.BaseDescription) string {
+	if d == nil {
+		return ""
+	}
+	return d.GetDescription().Label
+}
+
+func (cmd *EnRegisterCommand) Usage() string {
+	return `Usage: en register -f descriptor-file
+
+Register extension(s) from a JSON or YAML descriptor. The descriptor has
+the same shape as the output of 'en info -o json', a single extension
+or a list of extensions.
+
+Examples:
+  en register -f ext.json
+  en register -f ext.yaml
+`
+}
+
+func (cmd *EnRegisterCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	registerCmd := flag.NewFlagSet("register", flag.ContinueOnError)
+	file := registerCmd.String("f", "", "Extension descriptor file")
+	names, err := parseFlags(registerCmd, args)
+	if err != nil || *file == "" || len(names) > 0 {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	descriptors, err := readExtensionDescriptors(*file)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := object.GetExtensionManager(cli.client.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	r := NewResult()
+	for _, d := range descriptors {
+		if err := m.Register(cli.ctx, d.Extension()); err != nil {
+			r.AddError(fmt.Errorf("Failed to register '%s': %s", d.Key, err))
+			continue
+		}
+		r.Message("'%s' version %s has been successfully registered", d.Key, d.Version)
+	}
+	return r, nil
+}
+
+func (cmd *EnUpdateCommand) Usage() string {
+	return `Usage: en update <extension-key> [options]
+       en update -f descriptor-file
+
+Update the version or server URL of a registered extension, or replace
+its registration with the contents of a descriptor file
+
+Options:
+  -version=version       New extension version
+  -url=url               New server URL
+  -thumbprint=value      New server certificate thumbprint
+  -server-type=type      Server to update when the extension has more than one
+
+Examples:
+  en update com.cisco.hx -version 4.5.1
+  en update com.cisco.hx -url https://10.1.1.5/plugin.zip -thumbprint AA:BB:...
+  en update -f ext.json
+`
+}
+
+func (cmd *EnUpdateCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	updateCmd := flag.NewFlagSet("update", flag.ContinueOnError)
+	file := updateCmd.String("f", "", "Extension descriptor file")
+	version := updateCmd.String("version", "", "Extension version")
+	serverUrl := updateCmd.String("url", "", "Server URL")
+	thumbprint := updateCmd.String("thumbprint", "", "Server certificate thumbprint")
+	serverType := updateCmd.String("server-type", "", "Server type")
+
+	names, err := parseFlags(updateCmd, args)
+	if err != nil || len(names) > 1 || (*file != "" && len(names) > 0) {
+		return UsageResult(cmd.Usage()), nil
+	}
+	var key string
+	if len(names) == 1 {
+		key = names[0]
+	}
+
+	m, err := object.GetExtensionManager(cli.client.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	if *file != "" {
+		descriptors, err := readExtensionDescriptors(*file)
+		if err != nil {
+			return nil, err
+		}
+
+		r := NewResult()
+		for _, d := range descriptors {
+			if err := m.Update(cli.ctx, d.Extension()); err != nil {
+				r.AddError(fmt.Errorf("Failed to update '%s': %s", d.Key, err))
+				continue
+			}
+			r.Message("'%s' has been successfully updated to version %s", d.Key, d.Version)
+		}
+		return r, nil
+	}
+
+	if key == "" || (*version == "" && *serverUrl == "" && *thumbprint == "") {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	e, err := m.Find(cli.ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, errors.New("Extension '" + key + "' is not found")
+	}
+
+	if *version != "" {
+		e.Version = *version
+	}
+
+	if *serverUrl != "" || *thumbprint != "" {
+		var server *types.ExtensionServerInfo
+		for i := range e.Server {
+			if *serverType == "" || e.Server[i].Type == *serverType {
+				if server != nil {
+					return nil, errors.New("Extension '" + key + "' has more than one server, use -server-type")
+				}
+				server = &e.Server[i]
+			}
+		}
+		if server == nil {
+			return nil, errors.New("Extension '" + key + "' has no matching server")
+		}
+		if *serverUrl != "" {
+			server.Url = *serverUrl
+		}
+		if *thumbprint != "" {
+			server.ServerThumbprint = *thumbprint
+		}
+		d := ExtensionServer{Url: server.Url, Type: server.Type, Thumbprint: server.ServerThumbprint}
+		if err := d.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.Update(cli.ctx, *e); err != nil {
+		return nil, err
+	}
+	return MessageResult("'%s' has been successfully updated", key), nil
+}
+
+// ExtensionDescriptor is the json/yaml shape of an extension, as printed
+// by 'en info' and read by 'en register' and 'en update'
+type ExtensionDescriptor struct {
+	Key         string            `json:"key" yaml:"key"`
+	Label       string            `json:"label" yaml:"label"`
+	Description string            `json:"description" yaml:"description"`
+	Version     string            `json:"version" yaml:"version"`
+	Company     string            `json:"company" yaml:"company"`
+	Type        string            `json:"type" yaml:"type"`
+	SubjectName string            `json:"subject_name" yaml:"subject_name"`
+	Server      []ExtensionServer `json:"server" yaml:"server"`
+	Client      []ExtensionClient `json:"client" yaml:"client"`
+}
+
+// readExtensionDescriptors reads a single descriptor or a list of
+// descriptors from a JSON or YAML file and validates them
+func readExtensionDescriptors(file string) ([]ExtensionDescriptor, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var descriptors []ExtensionDescriptor
+	content := bytes.TrimSpace(data)
+	if bytes.HasPrefix(content, []byte("[")) || bytes.HasPrefix(content, []byte("-")) {
+		err = unmarshalDescriptor(content, &descriptors)
+	} else {
+		var d ExtensionDescriptor
+		err = unmarshalDescriptor(content, &d)
+		descriptors = append(descriptors, d)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	if len(descriptors) == 0 {
+		return nil, fmt.Errorf("%s: no extensions found", file)
+	}
+
+	for i := range descriptors {
+		if err := descriptors[i].validate(); err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+	}
+	return descriptors, nil
+}
+
+func unmarshalDescriptor(data []byte, v interface{}) error {
+	if bytes.HasPrefix(data, []byte("{")) || bytes.HasPrefix(data, []byte("[")) {
+		return json.Unmarshal(data, v)
+	}
+	return yaml.Unmarshal(data, v)
+}
+
+func (d *ExtensionDescriptor) validate() error {
+	var problems []string
+	if d.Key == "" {
+		problems = append(problems, "key is required")
+	}
+	if d.Version == "" {
+		problems = append(problems, "version is required")
+	}
+	for i, s := range d.Server {
+		if err := s.validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("server[%d]: %s", i, err))
+		}
+	}
+	for i, c := range d.Client {
+		if c.Url == "" {
+			problems = append(problems, fmt.Sprintf("client[%d]: url is required", i))
+		}
+		if c.Type == "" {
+			problems = append(problems, fmt.Sprintf("client[%d]: type is required", i))
+		}
+	}
+
+	if len(problems) > 0 {
+		name := d.Key
+		if name == "" {
+			name = "extension"
+		}
+		return fmt.Errorf("invalid '%s': %s", name, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (s *ExtensionServer) validate() error {
+	u, err := url.Parse(s.Url)
+	if s.Url == "" || err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid url '%s'", s.Url)
+	}
+	if s.Type == "" {
+		return errors.New("type is required")
+	}
+	if u.Scheme == "https" && s.Thumbprint == "" {
+		return errors.New("thumbprint is required for https urls")
+	}
+	if s.Thumbprint != "" && !thumbprintMatch.MatchString(s.Thumbprint) {
+		return fmt.Errorf("invalid thumbprint '%s'", s.Thumbprint)
+	}
+	return nil
+}
+
+// Extension converts the descriptor into the vSphere extension type
+func (d *ExtensionDescriptor) Extension() types.Extension {
+	label := d.Label
+	if label == "" {
+		label = d.Key
+	}
+
+	e := types.Extension{
+		Key:               d.Key,
+		Version:           d.Version,
+		Company:           d.Company,
+		Type:              d.Type,
+		SubjectName:       d.SubjectName,
+		Description:       &types.Description{Label: label, Summary: d.Description},
+		LastHeartbeatTime: time.Now(),
+	}
+
+	for _, s := range d.Server {
+		adminEmail := s.AdminEmail
+		if adminEmail == nil {
+			adminEmail = []string{}
+		}
+		e.Server = append(e.Server, types.ExtensionServerInfo{
+			Url:              s.Url,
+			Description:      &types.Description{Label: s.Description, Summary: s.Description},
+			Company:          s.Company,
+			Type:             s.Type,
+			AdminEmail:       adminEmail,
+			ServerThumbprint: s.Thumbprint,
+		})
+	}
+
+	for _, c := range d.Client {
+		e.Client = append(e.Client, types.ExtensionClientInfo{
+			Version:     c.Version,
+			Description: &types.Description{Label: c.Description, Summary: c.Description},
+			Company:     c.Company,
+			Type:        c.Type,
+			Url:         c.Url,
+		})
+	}
+	return e
 }
 
 func (cmd *EnUnregisterCommand) Usage() string {
-	return `Usage: en unregister <extension-key>
+	return `Usage: en unregister [options] <extension-key>
+
+Unregister an extension. The extension key has to be typed to confirm.
+
+Options:
+  -yes        Don't ask for confirmation
+  -dry-run    Only show the extension that would be unregistered
 
 Examples:
   en unregister com.vmware.ovf
+  en unregister -yes com.vmware.ovf
 `
 }
 
-func (cmd *EnUnregisterCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table, error) {
-	if len(args) <= 0 {
-		Usage(cmd.Usage())
-		return nil, nil
+func (cmd *EnUnregisterCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	unregisterCmd := flag.NewFlagSet(EN_UNREGISTER, flag.ContinueOnError)
+	yes := unregisterCmd.Bool("yes", false, "Don't ask for confirmation")
+	dryRun := unregisterCmd.Bool("dry-run", false, "Only show the extension that would be unregistered")
+	keys, err := parseFlags(unregisterCmd, args)
+	if err != nil || len(keys) != 1 {
+		return UsageResult(cmd.Usage()), nil
 	}
 
-	key := args[0]
+	key := keys[0]
 	ctx := cli.ctx
 	c := cli.client.Client
 
@@ -190,10 +608,28 @@ func (cmd *EnUnregisterCommand) Execute(cli *Vcli, args ...string) (*prettytable
 		return nil, err
 	}
 
+	e, err := m.Find(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, fmt.Errorf("Extension '%s' is not found", key)
+	}
+
+	plan := NewPlanTable()
+	plan.AddRow("Extension", key, "")
+	if *dryRun {
+		return DryRunResult(plan), nil
+	}
+
+	question := fmt.Sprintf("Extension '%s' will be unregistered.", key)
+	if err := confirm(question, key, plan, *yes); err != nil {
+		return nil, err
+	}
+
 	if err = m.Unregister(ctx, key); err != nil {
 		return nil, err
 	}
 
-	fmt.Printf("'%s' has been successfully unregistered\n", key)
-	return nil, nil
+	return MessageResult("'%s' has been successfully unregistered", key), nil
 }
@@ -0,0 +1,597 @@
+// Package filter implements the filter expressions of the vcli list
+// commands, e.g.
+//
+//	state==poweredOn && mem>=8GB && name=~"^hx"
+//
+// A condition compares a field of a row with a value:
+//
+//	==  !=         equal, not equal; text ignores case, * and ? are globs
+//	<  <=  >  >=   numbers, sizes, frequencies and durations by value,
+//	               text in alphabetical order
+//	=~  !~         regular expression matches, does not match
+//
+// A field on its own is true if it is set, e.g. 'ip'. Conditions are
+// combined with && and ||, negated with ! and grouped with parentheses.
+// Values with spaces or operator characters are quoted with " or '.
+// Numbers may have a unit: B, KB, MB, GB, TB for sizes, MHz, GHz for
+// frequencies and s, m, h, d, w for durations. A field of a list with
+// several values, like the datastores of a VM, matches if one of its
+// values does.
+package filter
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Kind is the type of a field, it decides which values and units a
+// condition on the field accepts
+type Kind int
+
+const (
+	// Auto fields are compared as numbers if both sides are numbers,
+	// as text otherwise
+	Auto Kind = iota
+	Text
+	Number
+	Bool
+	// Size fields are in bytes
+	Size
+	// Frequency fields are in MHz
+	Frequency
+	// Duration fields are in seconds
+	Duration
+)
+
+var kindNames = map[Kind]string{
+	Auto:      "field",
+	Text:      "text",
+	Number:    "number",
+	Bool:      "boolean",
+	Size:      "size",
+	Frequency: "frequency",
+	Duration:  "duration",
+}
+
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+// units of each kind in the unit of the fields of that kind
+var units = map[Kind]map[string]float64{
+	Size: {
+		"b":  1,
+		"kb": 1 << 10,
+		"mb": 1 << 20,
+		"gb": 1 << 30,
+		"tb": 1 << 40,
+	},
+	Frequency: {
+		"mhz": 1,
+		"ghz": 1000,
+	},
+	Duration: {
+		"s": 1,
+		"m": 60,
+		"h": 60 * 60,
+		"d": 24 * 60 * 60,
+		"w": 7 * 24 * 60 * 60,
+	},
+}
+
+var unitNames = map[Kind]string{
+	Size:      "B, KB, MB, GB or TB",
+	Frequency: "MHz or GHz",
+	Duration:  "s, m, h, d or w",
+}
+
+var numberMatch = regexp.MustCompile(`^([-+]?[0-9]*\.?[0-9]+)([a-zA-Z]*)$`)
+
+// Field is a field of the rows a filter is applied to
+type Field struct {
+	Name string
+	Kind Kind
+}
+
+// Filter is a parsed filter expression
+type Filter struct {
+	expr string
+	root node
+}
+
+// Error is an error in a filter expression, Pos is the byte offset of
+// the token it was found at
+type Error struct {
+	Expr string
+	Pos  int
+	Msg  string
+}
+
+func newError(expr string, pos int, format string, a ...interface{}) *Error {
+	return &Error{Expr: expr, Pos: pos, Msg: fmt.Sprintf(format, a...)}
+}
+
+// Error shows the message, the expression and a marker below the position
+func (e *Error) Error() string {
+	col := utf8.RuneCountInString(e.Expr[:e.Pos])
+	return fmt.Sprintf("Invalid filter at position %d: %s\n  %s\n  %s^", col+1, e.Msg, e.Expr, strings.Repeat(" ", col))
+}
+
+// Parse parses a filter expression on the given fields
+func Parse(expr string, fields []Field) (*Filter, error) {
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 1 {
+		return nil, newError(expr, 0, "Empty filter")
+	}
+
+	p := &parser{expr: expr, tokens: tokens, fields: make(map[string]Field, len(fields))}
+	for _, f := range fields {
+		p.fields[strings.ToLower(f.Name)] = f
+		p.names = append(p.names, f.Name)
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != tokEOF {
+		if t.typ == tokRParen {
+			return nil, newError(expr, t.pos, "Unexpected ')' without '('")
+		}
+		return nil, newError(expr, t.pos, "Expected && or || before %s", t)
+	}
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// Match reports whether a row matches the filter, value returns the value
+// of a field of the row
+func (f *Filter) Match(value func(field string) interface{}) bool {
+	return f.root.eval(value)
+}
+
+func (f *Filter) String() string {
+	return f.expr
+}
+
+type parser struct {
+	expr   string
+	tokens []token
+	pos    int
+	fields map[string]Field
+	names  []string
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().typ == tokOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().typ == tokAnd {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	t := p.next()
+	switch t.typ {
+	case tokNot:
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{n}, nil
+	case tokLParen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.next(); c.typ != tokRParen {
+			return nil, newError(p.expr, c.pos, "Expected ')' to close '(', found %s", c)
+		}
+		return n, nil
+	case tokWord:
+		return p.parseCondition(t)
+	case tokEOF:
+		return nil, newError(p.expr, t.pos, "Expected a condition")
+	}
+	return nil, newError(p.expr, t.pos, "Expected a field name, found %s", t)
+}
+
+func (p *parser) parseCondition(name token) (node, error) {
+	field, ok := p.fields[strings.ToLower(name.text)]
+	if !ok {
+		msg := fmt.Sprintf("Unknown field '%s'", name.text)
+		if s := closest(name.text, p.names); s != "" {
+			msg += fmt.Sprintf(", did you mean '%s'?", s)
+		} else {
+			msg += ", expected one of " + strings.Join(p.names, ", ")
+		}
+		return nil, newError(p.expr, name.pos, "%s", msg)
+	}
+
+	op := p.peek()
+	if op.typ != tokOp {
+		return &setNode{field: field.Name}, nil
+	}
+	p.next()
+
+	v := p.next()
+	if v.typ != tokWord && v.typ != tokString {
+		return nil, newError(p.expr, v.pos, "Expected a value after '%s', found %s", op.text, v)
+	}
+
+	c := &compareNode{field: field.Name, kind: field.Kind, op: op.text, text: v.text}
+	switch op.text {
+	case "=~", "!~":
+		if isNumeric(field.Kind) || field.Kind == Bool {
+			return nil, newError(p.expr, op.pos, "'%s' needs a text field, %s is a %s", op.text, field.Name, field.Kind)
+		}
+		re, err := regexp.Compile(v.text)
+		if err != nil {
+			return nil, newError(p.expr, v.pos, "Invalid regular expression %s: %s", v, strings.TrimPrefix(err.Error(), "error parsing regexp: "))
+		}
+		c.re = re
+		return c, nil
+	}
+
+	switch {
+	case field.Kind == Bool:
+		b, err := strconv.ParseBool(v.text)
+		if err != nil {
+			return nil, newError(p.expr, v.pos, "Expected true or false for %s, found %s", field.Name, v)
+		}
+		if op.text != "==" && op.text != "!=" {
+			return nil, newError(p.expr, op.pos, "'%s' can't compare the boolean %s, use == or !=", op.text, field.Name)
+		}
+		c.text = strconv.FormatBool(b)
+	case isNumeric(field.Kind):
+		num, err := parseNumber(v.text, field.Kind)
+		if err != nil {
+			return nil, newError(p.expr, v.pos, "Invalid %s %s for %s, %s", field.Kind, v, field.Name, err)
+		}
+		c.num, c.isNum = num, true
+	case field.Kind == Auto && v.typ == tokWord:
+		if num, err := parseNumber(v.text, Auto); err == nil {
+			c.num, c.isNum = num, true
+		}
+	}
+	c.glob = (op.text == "==" || op.text == "!=") && !c.isNum && strings.ContainsAny(v.text, "*?[")
+	if c.glob {
+		if _, err := path.Match(v.text, ""); err != nil {
+			return nil, newError(p.expr, v.pos, "Invalid glob %s", v)
+		}
+	}
+	return c, nil
+}
+
+func isNumeric(k Kind) bool {
+	return k == Number || k == Size || k == Frequency || k == Duration
+}
+
+// parseNumber parses a number with an optional unit of a kind and returns
+// it in the unit of the fields of that kind. Auto accepts the units of
+// every kind.
+func parseNumber(s string, kind Kind) (float64, error) {
+	m := numberMatch.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("expected a number")
+	}
+	num, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("expected a number")
+	}
+	unit := strings.ToLower(m[2])
+	if unit == "" {
+		return num, nil
+	}
+
+	switch kind {
+	case Auto:
+		for _, u := range units {
+			if f, ok := u[unit]; ok {
+				return num * f, nil
+			}
+		}
+		return 0, fmt.Errorf("unknown unit '%s'", m[2])
+	case Number:
+		return 0, fmt.Errorf("expected a number without unit")
+	}
+	if f, ok := units[kind][unit]; ok {
+		return num * f, nil
+	}
+	return 0, fmt.Errorf("expected a unit of %s", unitNames[kind])
+}
+
+// closest returns the name nearest to a misspelled one, "" if none is
+// close enough
+func closest(s string, names []string) string {
+	best, bestDist := "", 3
+	for _, n := range names {
+		if d := distance(strings.ToLower(s), strings.ToLower(n)); d < bestDist {
+			best, bestDist = n, d
+		}
+	}
+	return best
+}
+
+// distance is the edit distance of two strings, a swap of two adjacent
+// characters counts as one edit
+func distance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func minInt(a int, b ...int) int {
+	for _, v := range b {
+		if v < a {
+			a = v
+		}
+	}
+	return a
+}
+
+type node interface {
+	eval(value func(string) interface{}) bool
+}
+
+type andNode struct{ left, right node }
+type orNode struct{ left, right node }
+type notNode struct{ n node }
+
+func (n *andNode) eval(value func(string) interface{}) bool {
+	return n.left.eval(value) && n.right.eval(value)
+}
+
+func (n *orNode) eval(value func(string) interface{}) bool {
+	return n.left.eval(value) || n.right.eval(value)
+}
+
+func (n *notNode) eval(value func(string) interface{}) bool {
+	return !n.n.eval(value)
+}
+
+// setNode is a field on its own, true if the field is set
+type setNode struct {
+	field string
+}
+
+func (n *setNode) eval(value func(string) interface{}) bool {
+	for _, v := range values(value(n.field)) {
+		if isSet(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSet(v interface{}) bool {
+	switch vv := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return vv
+	case string:
+		return vv != ""
+	case time.Time:
+		return !vv.IsZero()
+	}
+	if f, ok := ToFloat(v); ok {
+		return f != 0
+	}
+	return true
+}
+
+// compareNode compares a field with a value
+type compareNode struct {
+	field string
+	kind  Kind
+	op    string
+	text  string
+	num   float64
+	isNum bool
+	re    *regexp.Regexp
+	glob  bool
+}
+
+func (n *compareNode) eval(value func(string) interface{}) bool {
+	// a field with several values matches if one of them does, the
+	// negated operators if none does
+	matched := false
+	for _, v := range values(value(n.field)) {
+		if n.match(v) {
+			matched = true
+			break
+		}
+	}
+	return matched != (n.op == "!=" || n.op == "!~")
+}
+
+// match evaluates the condition on a single value, the negated operators
+// are evaluated as their positive counterpart
+func (n *compareNode) match(v interface{}) bool {
+	if n.re != nil {
+		return v != nil && n.re.MatchString(text(v))
+	}
+
+	if n.isNum {
+		f, ok := ToFloat(v)
+		if !ok && n.kind == Auto {
+			// text cells of numbers, e.g. "12", compare as numbers
+			f, ok = parseText(v)
+		}
+		if ok {
+			return compare(f-n.num, n.op)
+		}
+		if isNumeric(n.kind) {
+			// an unset number is neither equal, less nor greater
+			return false
+		}
+	}
+
+	s := text(v)
+	switch n.op {
+	case "==", "!=":
+		if n.glob {
+			ok, _ := path.Match(strings.ToLower(n.text), strings.ToLower(s))
+			return ok
+		}
+		return strings.EqualFold(s, n.text)
+	}
+	return compare(float64(strings.Compare(strings.ToLower(s), strings.ToLower(n.text))), n.op)
+}
+
+// compare applies an operator to the difference of two values
+func compare(diff float64, op string) bool {
+	switch op {
+	case "==", "!=":
+		return diff == 0
+	case "<":
+		return diff < 0
+	case "<=":
+		return diff <= 0
+	case ">":
+		return diff > 0
+	case ">=":
+		return diff >= 0
+	}
+	return false
+}
+
+// values returns the values of a field with several values, or the value
+// of the field as the only one
+func values(v interface{}) []interface{} {
+	switch vv := v.(type) {
+	case []string:
+		list := make([]interface{}, 0, len(vv))
+		for _, s := range vv {
+			list = append(list, s)
+		}
+		if len(list) == 0 {
+			return []interface{}{nil}
+		}
+		return list
+	case []interface{}:
+		if len(vv) == 0 {
+			return []interface{}{nil}
+		}
+		return vv
+	}
+	return []interface{}{v}
+}
+
+func text(v interface{}) string {
+	switch vv := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return vv
+	case time.Time:
+		return vv.Format(time.RFC3339)
+	case fmt.Stringer:
+		return vv.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// parseText parses a text value that is a plain number
+func parseText(v interface{}) (float64, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return f, err == nil
+}
+
+// ToFloat returns the value of a number of any numeric type, so that
+// filters and the sorting of list commands compare numbers alike
+func ToFloat(v interface{}) (float64, bool) {
+	switch vv := v.(type) {
+	case int:
+		return float64(vv), true
+	case int8:
+		return float64(vv), true
+	case int16:
+		return float64(vv), true
+	case int32:
+		return float64(vv), true
+	case int64:
+		return float64(vv), true
+	case uint:
+		return float64(vv), true
+	case uint8:
+		return float64(vv), true
+	case uint16:
+		return float64(vv), true
+	case uint32:
+		return float64(vv), true
+	case uint64:
+		return float64(vv), true
+	case float32:
+		return float64(vv), true
+	case float64:
+		return vv, true
+	}
+	return 0, false
+}
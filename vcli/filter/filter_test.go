@@ -0,0 +1,327 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+)
+
+var testFields = []Field{
+	{Name: "name"},
+	{Name: "state", Kind: Text},
+	{Name: "ip"},
+	{Name: "cpu", Kind: Number},
+	{Name: "mem", Kind: Size},
+	{Name: "freq", Kind: Frequency},
+	{Name: "uptime", Kind: Duration},
+	{Name: "maintenance", Kind: Bool},
+	{Name: "datastore"},
+	{Name: "hosts"},
+}
+
+// testRow is a row of the fields above
+var testRow = map[string]interface{}{
+	"name":        "web-01",
+	"state":       "poweredOn",
+	"ip":          "10.64.55.17",
+	"cpu":         int32(4),
+	"mem":         int64(8 << 30),
+	"freq":        int32(2600),
+	"uptime":      int64(3 * 24 * 60 * 60),
+	"maintenance": false,
+	"datastore":   []string{"nfs-01", "local-esx1"},
+	"hosts":       "12",
+}
+
+func match(t *testing.T, expr string, row map[string]interface{}) bool {
+	f, err := Parse(expr, testFields)
+	if err != nil {
+		t.Fatalf("Parse(%q): unexpected error %v", expr, err)
+	}
+	return f.Match(func(field string) interface{} { return row[field] })
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		// text ignores case, == and != take globs
+		{"name==web-01", true},
+		{"name==WEB-01", true},
+		{"name==web-*", true},
+		{"name==web-0?", true},
+		{"name==db-*", false},
+		{"name!=db-*", true},
+		{"state=='poweredOn'", true},
+		{"name<x", true},
+		{"name>=web-02", false},
+
+		// regular expressions
+		{`name=~"^web-\d+$"`, true},
+		{`ip=~"^10\.64\."`, true},
+		{"name!~^db", true},
+		{"name=~^DB", false},
+
+		// numbers and units
+		{"cpu==4", true},
+		{"cpu>4", false},
+		{"cpu>=4", true},
+		{"mem>=8GB", true},
+		{"mem>8GB", false},
+		{"mem==8192MB", true},
+		{"mem<0.5TB", true},
+		{"freq>2.5GHz", true},
+		{"freq==2600mhz", true},
+		{"uptime>=3d", true},
+		{"uptime<1w", true},
+		{"uptime>72h", false},
+		{"uptime==4320m", true},
+
+		// auto fields compare numbers as numbers, not as text
+		{"hosts>9", true},
+		{"hosts<100", true},
+
+		// booleans
+		{"maintenance==false", true},
+		{"maintenance!=true", true},
+		{"maintenance", false},
+
+		// a field on its own is true if it is set
+		{"ip", true},
+		{"!ip", false},
+		{"missing", false},
+
+		// lists match if one value does, negated operators if none does
+		{"datastore==nfs-*", true},
+		{"datastore==local-*", true},
+		{"datastore==san-*", false},
+		{"datastore!=nfs-*", false},
+		{"datastore!~^san", true},
+	}
+
+	fields := append(testFields, Field{Name: "missing"})
+	for _, tt := range tests {
+		f, err := Parse(tt.expr, fields)
+		if err != nil {
+			t.Errorf("Parse(%q): unexpected error %v", tt.expr, err)
+			continue
+		}
+		if got := f.Match(func(field string) interface{} { return testRow[field] }); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		// && binds tighter than ||
+		{"cpu==1 && cpu==2 || cpu==4", true},
+		{"cpu==4 || cpu==1 && cpu==2", true},
+		{"(cpu==4 || cpu==1) && cpu==2", false},
+		{"cpu==1 && (cpu==2 || cpu==4)", false},
+		// ! binds tighter than && and ||
+		{"!cpu==1 && cpu==4", true},
+		{"!(cpu==4 && mem>=8GB)", false},
+		{"!!ip", true},
+		{"!cpu==4 || ip", true},
+		// left to right
+		{"cpu==4 && ip && mem>1GB && name==web-*", true},
+		{"cpu==1 || cpu==2 || cpu==3 || cpu==4", true},
+		{"((((ip))))", true},
+	}
+
+	for _, tt := range tests {
+		if got := match(t, tt.expr, testRow); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestUnsetValues(t *testing.T) {
+	row := map[string]interface{}{"name": "new-vm"}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		// an unset number is neither equal, less nor greater
+		{"cpu==0", false},
+		{"cpu<1", false},
+		{"cpu>1", false},
+		{"cpu!=1", true},
+		{"mem<1GB", false},
+		{"ip==''", true},
+		{"ip=~.", false},
+		{"datastore==*", true},
+		{"!datastore", true},
+	}
+
+	for _, tt := range tests {
+		if got := match(t, tt.expr, row); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		s    string
+		kind Kind
+		want float64
+		err  string
+	}{
+		{"42", Number, 42, ""},
+		{"-1.5", Number, -1.5, ""},
+		{".5", Number, 0.5, ""},
+		{"1KB", Size, 1 << 10, ""},
+		{"1.5gb", Size, 1.5 * (1 << 30), ""},
+		{"2TB", Size, 2 << 40, ""},
+		{"512B", Size, 512, ""},
+		{"2GHz", Frequency, 2000, ""},
+		{"800MHz", Frequency, 800, ""},
+		{"90s", Duration, 90, ""},
+		{"2h", Duration, 7200, ""},
+		{"1w", Duration, 604800, ""},
+		{"8GB", Auto, 8 << 30, ""},
+		{"3d", Auto, 3 * 86400, ""},
+		{"4GB", Number, 0, "expected a number without unit"},
+		{"4GHz", Size, 0, "expected a unit of B, KB, MB, GB or TB"},
+		{"4KB", Duration, 0, "expected a unit of s, m, h, d or w"},
+		{"4xy", Auto, 0, "unknown unit 'xy'"},
+		{"abc", Number, 0, "expected a number"},
+		{"1.2.3", Size, 0, "expected a number"},
+	}
+
+	for _, tt := range tests {
+		got, err := parseNumber(tt.s, tt.kind)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("parseNumber(%q, %s): got error %v, want %q", tt.s, tt.kind, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseNumber(%q, %s) = %v, %v, want %v", tt.s, tt.kind, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		pos  int
+		msg  string
+	}{
+		{"", 0, "Empty filter"},
+		{"   ", 0, "Empty filter"},
+		{"nmae==x", 0, "Unknown field 'nmae', did you mean 'name'?"},
+		{"cpu==1 && bogus", 10, "Unknown field 'bogus', expected one of name, state, ip, cpu, mem, freq, uptime, maintenance, datastore, hosts"},
+		{"name==", 6, "Expected a value after '==', found end of filter"},
+		{"name== &&", 7, "Expected a value after '==', found '&&'"},
+		{"cpu==1 &&", 9, "Expected a condition"},
+		{"&& cpu==1", 0, "Expected a field name, found '&&'"},
+		{"cpu==1 ip", 7, "Expected && or || before 'ip'"},
+		{"(cpu==1", 7, "Expected ')' to close '(', found end of filter"},
+		{"cpu==1)", 6, "Unexpected ')' without '('"},
+		{"()", 1, "Expected a field name, found ')'"},
+		{"cpu=~4", 3, "'=~' needs a text field, cpu is a number"},
+		{"maintenance!~x", 11, "'!~' needs a text field, maintenance is a boolean"},
+		{"maintenance==yes", 13, "Expected true or false for maintenance, found 'yes'"},
+		{"maintenance<true", 11, "'<' can't compare the boolean maintenance, use == or !="},
+		{"mem>8GHz", 4, "Invalid size '8GHz' for mem, expected a unit of B, KB, MB, GB or TB"},
+		{"cpu>four", 4, "Invalid number 'four' for cpu, expected a number"},
+		{"name=~'(web'", 6, "Invalid regular expression \"(web\": missing closing ): `(web`"},
+		{"name==[web", 6, "Invalid glob '[web'"},
+		{"a & b", 2, "Unexpected '&', did you mean '&&'?"},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.expr, testFields)
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("Parse(%q): got error %v, want *Error", tt.expr, err)
+			continue
+		}
+		if e.Pos != tt.pos || e.Msg != tt.msg {
+			t.Errorf("Parse(%q): got %q at %d, want %q at %d", tt.expr, e.Msg, e.Pos, tt.msg, tt.pos)
+		}
+	}
+}
+
+func TestErrorMarker(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"cpu==1 && nmae", "Invalid filter at position 11: Unknown field 'nmae', did you mean 'name'?\n  cpu==1 && nmae\n            ^"},
+		// positions count characters, not bytes
+		{"name==\"é\" ip", "Invalid filter at position 11: Expected && or || before 'ip'\n  name==\"é\" ip\n            ^"},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.expr, testFields)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("Parse(%q): got error\n%v\nwant\n%s", tt.expr, err, tt.want)
+		}
+	}
+}
+
+func TestClosest(t *testing.T) {
+	names := []string{"name", "state", "uptime", "datastore"}
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"nmae", "name"},
+		{"mme", "name"},
+		{"STATE", "state"},
+		{"uptim", "uptime"},
+		{"datastores", "datastore"},
+		{"xyz", ""},
+	}
+
+	for _, tt := range tests {
+		if got := closest(tt.s, names); got != tt.want {
+			t.Errorf("closest(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestFieldNamesIgnoreCase(t *testing.T) {
+	if !match(t, "NAME==web-01 && Cpu==4", testRow) {
+		t.Error("field names should ignore case")
+	}
+	if _, err := Parse("name==x", nil); err == nil || !strings.Contains(err.Error(), "Unknown field 'name'") {
+		t.Errorf("Parse without fields: got %v", err)
+	}
+}
+
+func TestToFloat(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want float64
+		ok   bool
+	}{
+		{int(-3), -3, true},
+		{int16(4), 4, true},
+		{int32(2600), 2600, true},
+		{int64(8 << 30), 8 << 30, true},
+		{uint8(7), 7, true},
+		{uint32(9), 9, true},
+		{uint64(1 << 40), 1 << 40, true},
+		{float32(1.5), 1.5, true},
+		{2.25, 2.25, true},
+		{"12", 0, false},
+		{true, 0, false},
+		{nil, 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := ToFloat(tt.v)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ToFloat(%#v) = %v, %v, want %v, %v", tt.v, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package filter
+
+import (
+	"strings"
+)
+
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokWord
+	tokString
+	tokOp
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	typ  tokenType
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.typ {
+	case tokEOF:
+		return "end of filter"
+	case tokString:
+		return `"` + t.text + `"`
+	}
+	return "'" + t.text + "'"
+}
+
+// comparison operators, longest first
+var operators = []string{"==", "!=", "<=", ">=", "=~", "!~", "<", ">"}
+
+// characters that end a word
+const delimiters = "=!<>&|()\"'~"
+
+// lex splits a filter expression into tokens
+func lex(expr string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(expr) {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case strings.HasPrefix(expr[i:], "&&"):
+			tokens = append(tokens, token{tokAnd, "&&", i})
+			i += 2
+		case strings.HasPrefix(expr[i:], "||"):
+			tokens = append(tokens, token{tokOr, "||", i})
+			i += 2
+		case c == '&' || c == '|':
+			return nil, newError(expr, i, "Unexpected '%c', did you mean '%c%c'?", c, c, c)
+		case c == '"' || c == '\'':
+			text, n, err := lexString(expr, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{tokString, text, i})
+			i += n
+		default:
+			if op := lexOperator(expr[i:]); op != "" {
+				tokens = append(tokens, token{tokOp, op, i})
+				i += len(op)
+				continue
+			}
+			if c == '!' {
+				tokens = append(tokens, token{tokNot, "!", i})
+				i++
+				continue
+			}
+			if c == '=' {
+				return nil, newError(expr, i, "Unexpected '=', did you mean '=='?")
+			}
+			if c == '~' {
+				return nil, newError(expr, i, "Unexpected '~', did you mean '=~'?")
+			}
+			start := i
+			for i < len(expr) && !strings.ContainsRune(delimiters+" \t\n\r", rune(expr[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokWord, expr[start:i], start})
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(expr)}), nil
+}
+
+func lexOperator(s string) string {
+	for _, op := range operators {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// lexString reads a string quoted with " or ', a backslash escapes the
+// next character. It returns the text and the length of the quoted string.
+func lexString(expr string, start int) (string, int, error) {
+	quote := expr[start]
+	var b strings.Builder
+	for i := start + 1; i < len(expr); i++ {
+		switch expr[i] {
+		case '\\':
+			if i+1 < len(expr) {
+				i++
+				// keep the escapes of regular expressions, only quotes and
+				// backslashes are unescaped
+				if expr[i] != quote && expr[i] != '\\' {
+					b.WriteByte('\\')
+				}
+				b.WriteByte(expr[i])
+			}
+		case quote:
+			return b.String(), i - start + 1, nil
+		default:
+			b.WriteByte(expr[i])
+		}
+	}
+	return "", 0, newError(expr, start, "Missing closing %c", quote)
+}
@@ -0,0 +1,81 @@
+package filter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLex(t *testing.T) {
+	tests := []struct {
+		expr   string
+		tokens []token
+	}{
+		{"", []token{{tokEOF, "", 0}}},
+		{"ip", []token{{tokWord, "ip", 0}, {tokEOF, "", 2}}},
+		{"mem>=8GB", []token{{tokWord, "mem", 0}, {tokOp, ">=", 3}, {tokWord, "8GB", 5}, {tokEOF, "", 8}}},
+		{"a==1 && !(b!=2 || c)", []token{
+			{tokWord, "a", 0}, {tokOp, "==", 1}, {tokWord, "1", 3},
+			{tokAnd, "&&", 5}, {tokNot, "!", 8}, {tokLParen, "(", 9},
+			{tokWord, "b", 10}, {tokOp, "!=", 11}, {tokWord, "2", 13},
+			{tokOr, "||", 15}, {tokWord, "c", 18}, {tokRParen, ")", 19},
+			{tokEOF, "", 20},
+		}},
+		{"name=~web-* os!~'^win'", []token{
+			{tokWord, "name", 0}, {tokOp, "=~", 4}, {tokWord, "web-*", 6},
+			{tokWord, "os", 12}, {tokOp, "!~", 14}, {tokString, "^win", 16},
+			{tokEOF, "", 22},
+		}},
+		{"a<1\tb>2", []token{
+			{tokWord, "a", 0}, {tokOp, "<", 1}, {tokWord, "1", 2},
+			{tokWord, "b", 4}, {tokOp, ">", 5}, {tokWord, "2", 6},
+			{tokEOF, "", 7},
+		}},
+		// only quotes and backslashes are unescaped, regex escapes are kept
+		{`n=="a \"b\" \\ \."`, []token{
+			{tokWord, "n", 0}, {tokOp, "==", 1}, {tokString, `a "b" \ \.`, 3},
+			{tokEOF, "", 18},
+		}},
+		{`n=='it\'s'`, []token{
+			{tokWord, "n", 0}, {tokOp, "==", 1}, {tokString, "it's", 3},
+			{tokEOF, "", 10},
+		}},
+	}
+
+	for _, tt := range tests {
+		tokens, err := lex(tt.expr)
+		if err != nil {
+			t.Errorf("lex(%q): unexpected error %v", tt.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(tokens, tt.tokens) {
+			t.Errorf("lex(%q) = %v, want %v", tt.expr, tokens, tt.tokens)
+		}
+	}
+}
+
+func TestLexErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		pos  int
+		msg  string
+	}{
+		{"a & b", 2, "Unexpected '&', did you mean '&&'?"},
+		{"a | b", 2, "Unexpected '|', did you mean '||'?"},
+		{"a = b", 2, "Unexpected '=', did you mean '=='?"},
+		{"a ~ b", 2, "Unexpected '~', did you mean '=~'?"},
+		{`a == "b`, 5, "Missing closing \""},
+		{"a == 'b", 5, "Missing closing '"},
+	}
+
+	for _, tt := range tests {
+		_, err := lex(tt.expr)
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("lex(%q): got error %v, want *Error", tt.expr, err)
+			continue
+		}
+		if e.Pos != tt.pos || e.Msg != tt.msg {
+			t.Errorf("lex(%q): got error %q at %d, want %q at %d", tt.expr, e.Msg, e.Pos, tt.msg, tt.pos)
+		}
+	}
+}
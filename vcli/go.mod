@@ -7,10 +7,12 @@ require (
 	github.com/c-bata/go-prompt v0.2.3
 	github.com/fatih/color v1.7.0
 	github.com/mattn/go-colorable v0.1.4
+	github.com/mattn/go-isatty v0.0.10
 	github.com/mattn/go-runewidth v0.0.7 // indirect
 	github.com/mattn/go-tty v0.0.3 // indirect
 	github.com/pkg/term v0.0.0-20190109203006-aa71e9d9e942 // indirect
 	github.com/tatsushid/go-prettytable v0.0.0-20141013043238-ed2d14c29939
 	github.com/vmware/govmomi v0.24.0
 	golang.org/x/crypto v0.0.0-20191202143827-86a70503ff7e
+	gopkg.in/yaml.v2 v2.2.8
 )
@@ -1,36 +1,49 @@
 package main
 
-import (
-	"github.com/tatsushid/go-prettytable"
-)
-
 type HelpCommand struct{}
 
 // 'help' command handler
-func (c *HelpCommand) Execute(v *Vcli, args ...string) (*prettytable.Table, error) {
-	if Spinner.Active() {
-		Spinner.Stop()
-	}
-
-	tbl, err := prettytable.NewTable([]prettytable.Column{
-		{Header: "", MinWidth: 20},
-		{Header: "", MinWidth: 35},
-		{Header: "", MinWidth: 25},
+func (c *HelpCommand) Execute(v *Vcli, args ...string) (*Result, error) {
+	tbl := NewTable([]Column{
+		{Header: "Command", Field: "command", MinWidth: 30},
+		{Header: "Description", Field: "description", MinWidth: 35},
+		{Header: "Example(s)", Field: "example", MinWidth: 25},
 	}...)
 
-	if err != nil {
-		return nil, err
-	}
-
-	tbl.NoHeader = true
-	tbl.AddRow("Command", "Description", "Example(s)")
-	tbl.AddRow("------------------------------",
-		"---------------------------------------------------------------------", "-----------------------")
 	tbl.AddRow("about", "About info of ESXi or vCenter host", "about")
+	tbl.AddRow("cache status", "Show the state of the inventory cache", "cache status")
+	tbl.AddRow("cache refresh", "Load the inventory cache again from vCenter", "cache refresh")
+	tbl.AddRow("connect NAME HOST USER [-p PASSWORD]", "Open another vCenter connection and make it active", "connect vc2 10.64.55.10 root")
+	tbl.AddRow("", "Use -profile to connect with a profile of config.yaml", "connect -profile lab-sjc")
+	tbl.AddRow("connections", "Shows list of vCenter connections", "connections")
+	tbl.AddRow("use NAME", "Run the following commands against a connection", "use vc2")
+	tbl.AddRow("COMMAND -on all|NAME1[,NAME2, ...]", "Run a command against several connections", "vm list -on vc1,vc2")
+	tbl.AddRow("", "Results are merged with a vCenter column", "hx info all -on all")
+	tbl.AddRow("COMMAND -timing", "Print round trips and elapsed time of a command", "vm list -timing")
+	tbl.AddRow("LIST [-cols a,b] [-sort col[:desc]] [-limit N]", "Choose columns, sort and limit vm, cr, dc, en, host and hx lists", "vm list -sort mem:desc -limit 10")
+	tbl.AddRow("", "# stays the number of the VM for vm info whatever the order", "vm list -cols name,cpu,mem,host,uptime")
+	tbl.AddRow("LIST -filter EXPR", "Show the rows matching a filter expression, also for hx info", "vm list -filter 'state==poweredOn && mem>=8GB'")
+	tbl.AddRow("", "Compare with == != < <= > >= =~ !~, combine with && || ! ( )", "cr list -filter 'hosts>=4 || name=~\"^hx\"'")
 	tbl.AddRow("cr list", "Shows list of clusters", "cr list")
+	tbl.AddRow("cr info NAME", "Display DRS, HA, EVC, hosts and health of a cluster", "cr info BLR-EDGE")
 	tbl.AddRow("dc list", "Shows list of datacenters", "dc list")
 	tbl.AddRow("en list [-grep string]", "List all extensions", "en list")
 	tbl.AddRow("", "Use -grep option to filter extensions by key", "en list -grep vmware")
+	tbl.AddRow("en info KEY", "Show details of an extension", "en info com.vmware.ovf")
+	tbl.AddRow("en register -f FILE", "Register extension(s) from a JSON or YAML descriptor", "en register -f ext.json")
+	tbl.AddRow("en update KEY [options]", "Update version or server URL of an extension", "en update com.cisco.hx -version 4.5")
+	tbl.AddRow("en unregister KEY", "Unregister an extension [-yes] [-dry-run]", "en unregister com.cisco.hx")
+	tbl.AddRow("history [-c] [COUNT]", "Shows the command history, -c clears it", "history 20")
+	tbl.AddRow("!NUMBER | !! | !TEXT", "Run a command of the history again, Ctrl-R searches it", "!42")
+	tbl.AddRow("host list [-grep string]", "Shows list of ESXi hosts", "host list")
+	tbl.AddRow("", "Use -grep option to filter hosts by name and cluster", "host list -grep BLR")
+	tbl.AddRow("host info NAME", "Display summary info of a host", "host info esx-01")
+	tbl.AddRow("host maintenance enter|exit NAME1[,NAME2, ...]", "Enter or exit maintenance mode [-evacuate] [-timeout secs]", "host maintenance enter esx-01")
+	tbl.AddRow("host reboot NAME1[,NAME2, ...]", "Reboot hosts [-force]", "host reboot esx-01")
+	tbl.AddRow("host shutdown NAME1[,NAME2, ...]", "Shut down hosts [-force]", "host shutdown esx-01")
+	tbl.AddRow("host disconnect NAME1[,NAME2, ...]", "Disconnect hosts from vCenter", "host disconnect esx-01")
+	tbl.AddRow("host reconnect NAME1[,NAME2, ...]", "Reconnect hosts to vCenter", "host reconnect esx-01")
+	tbl.AddRow("logout", "End the vCenter session and remove the saved session", "logout -on all")
 	tbl.AddRow("hx list", "Shows list of HX clusters", "hx list")
 	tbl.AddRow("hx info [-grep string] NAME", "Display about info of HX clusters", "hx info all")
 	tbl.AddRow("", "NAME can be 'all' OR cluster names or numbers separated by comma", "hx info BLR-EDGE")
@@ -39,17 +52,44 @@ func (c *HelpCommand) Execute(v *Vcli, args ...string) (*prettytable.Table, erro
 	tbl.AddRow("", "", "hx info -grep 4.0(1a) all")
 	tbl.AddRow("", "", "hx info -grep UCSB-B200-M5 all")
 	tbl.AddRow("", "", "hx info -grep FCH2206V1NG all")
-	tbl.AddRow("hx destroy NAME", "Destroy a given HX cluster", "hx destroy BLR-EDGE")
+	tbl.AddRow("hx destroy NAME", "Destroy a given HX cluster [-yes] [-dry-run]", "hx destroy BLR-EDGE")
+	tbl.AddRow("", "Use -dry-run to list the VMs, NICs, portgroups, switches and datastores to remove", "hx destroy -dry-run BLR-EDGE")
 	tbl.AddRow("version", "Shows ESXi or vCenter version", "version")
 	tbl.AddRow("vm list [-grep string]", "Shows list of all virtual machines", "vm list")
+	tbl.AddRow("", "Columns: name, ip, state, folder, cpu, mem, host, cluster, datastore, os, uptime", "vm list -cols name,os -sort os")
 	tbl.AddRow("", "Use -grep option to filter vm list by VM name, IP Address and Folder", "vm list -grep 10.64.55.177")
 	tbl.AddRow("", "", "vm list -grep install-upgrade-ui")
-	tbl.AddRow("vm info NAME", "Display about info of given virtual machine", "vm info ubuntu-vm1")
-	tbl.AddRow("vm destroy NAME1[,NAME2, ...]", "Destroy virtual machines", "vm destroy Win2K16")
+	tbl.AddRow("vm info NAME1[,NAME2, ...]", "Display about info of virtual machines", "vm info ubuntu-vm1")
+	tbl.AddRow("", "VMs may be names, globs, inventory paths or managed object IDs", "vm poweroff 'web-*'")
+	tbl.AddRow("", "Select VMs with [-re regex] [-where attr=value,...]", "vm info -re '^hx-.*-0[1-4]$'")
+	tbl.AddRow("", "Use -dry-run to only show the VMs a power action selects", "vm poweron -where state=poweredOff,folder=QA")
+	tbl.AddRow("", "", "vm reset -dry-run /DC1/vm/Team/*")
+	tbl.AddRow("vm clone SOURCE NAME", "Clone a virtual machine or template", "vm clone Ubuntu-01 Ubuntu-02")
+	tbl.AddRow("", "[-folder] [-pool] [-datastore] [-host] [-linked] [-poweron] [-template]", "vm clone -linked Ubuntu-01 Ubuntu-02")
+	tbl.AddRow("vm deploy TEMPLATE NAME1[,NAME2, ...]", "Deploy virtual machines from a template in parallel", "vm deploy Ubuntu-tmpl tb-01,tb-02")
+	tbl.AddRow("", "Use -ip, -gateway, -dns, -domain or -spec to customize the guests", "vm deploy -ip 10.1.1.5,10.1.1.6 Ubuntu-tmpl tb-01,tb-02")
+	tbl.AddRow("vm destroy NAME1[,NAME2, ...]", "Destroy virtual machines [-yes] [-dry-run]", "vm destroy Win2K16")
 	tbl.AddRow("vm poweroff NAME1[,NAME2, ...]", "Power off virtual machines", "vm poweroff Win2K16")
 	tbl.AddRow("vm poweron NAME1[,NAME2, ...]", "Power on virtual machines", "vm poweron LinuxVM")
 	tbl.AddRow("vm reset NAME1[,NAME2, ...]", "Reset virtual machines", "vm reset Ubuntu18.04")
+	tbl.AddRow("vm shutdown NAME1[,NAME2, ...]", "Shut down guest OS [-timeout secs] [-force]", "vm shutdown -force Ubuntu18.04")
+	tbl.AddRow("vm reboot NAME1[,NAME2, ...]", "Reboot guest OS of virtual machines", "vm reboot Ubuntu18.04")
+	tbl.AddRow("vm suspend NAME1[,NAME2, ...]", "Suspend virtual machines", "vm suspend Ubuntu18.04")
+	tbl.AddRow("vm standby NAME1[,NAME2, ...]", "Put guest OS of virtual machines in standby", "vm standby Win2K16")
+	tbl.AddRow("set [output FORMAT]", "Show or change vcli settings", "set")
+	tbl.AddRow("", "FORMAT is one of table, json, yaml or csv", "set output json")
+	tbl.AddRow("set pager on|off", "Page tables longer than the terminal", "set pager off")
+	tbl.AddRow("vm snapshot list NAME1[,NAME2, ...]", "Show snapshot tree of virtual machines", "vm snapshot list hx-01")
+	tbl.AddRow("vm snapshot create NAME1[,NAME2, ...] SNAP", "Create snapshot [-memory] [-quiesce] [-desc text]", "vm snapshot create hx-01 pre-upgrade")
+	tbl.AddRow("vm snapshot revert NAME1[,NAME2, ...] [SNAP]", "Revert to a snapshot, or the current snapshot [-yes] [-dry-run]", "vm snapshot revert hx-01 pre-upgrade")
+	tbl.AddRow("vm snapshot remove NAME1[,NAME2, ...] SNAP", "Remove a snapshot [-children] [-yes] [-dry-run]", "vm snapshot remove hx-01 pre-upgrade")
+	tbl.AddRow("vm snapshot remove-all NAME1[,NAME2, ...]", "Remove all snapshots [-yes] [-dry-run]", "vm snapshot remove-all hx-01")
+	tbl.AddRow("task list [-running] [-entity NAME] [-user]", "List the recent tasks of vCenter, the newest first", "task list -running")
+	tbl.AddRow("", "Also takes -filter, -cols, -sort and -limit", "task list -entity BLR-EDGE -limit 20")
+	tbl.AddRow("task watch [-entity NAME] [-user] [ID1,ID2, ...]", "Follow the progress of tasks, the running ones without IDs", "task watch task-1234")
+	tbl.AddRow("", "Power operations, destroy and snapshots print the IDs of their tasks", "task watch -entity BLR-EDGE")
+	tbl.AddRow("task cancel ID1[,ID2, ...]", "Cancel running tasks", "task cancel task-1234")
 	tbl.AddRow("quit", "Quit vcli", "quit")
 
-	return tbl, nil
+	return TableResult(tbl), nil
 }
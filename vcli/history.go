@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	prompt "github.com/c-bata/go-prompt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type HistoryCommand struct{}
+
+const (
+	HISTORY_DIR = "history"
+	// number of commands kept in the history file
+	HISTORY_SIZE = 1000
+	// prefix of history references, e.g. !42 or !!
+	HISTORY_REF = "!"
+	// replacement of passwords in the saved history
+	SCRUBBED_PASSWORD = "********"
+)
+
+// values of password options, e.g. -p secret or --password=secret
+var passwordOption = regexp.MustCompile(`(^|\s)(--?(?:p|passwd|password|pwd))(=|\s+)("[^"]*"|'[^']*'|\S+)`)
+
+// History is the list of commands run at the prompt, saved to a file per
+// profile so it is kept between vcli runs
+type History struct {
+	entries []string
+	path    string
+	size    int
+}
+
+var CmdHistory = &History{size: HISTORY_SIZE}
+
+func stateDir() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			home = os.TempDir()
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "vcli")
+}
+
+// historyPath returns the history file of a profile, or of user@host if
+// vcli runs without a profile
+func historyPath(profile string, u *url.URL) string {
+	return filepath.Join(stateDir(), HISTORY_DIR, connectionFileName(profile, u))
+}
+
+// Load reads the history file, a missing file is an empty history
+func (h *History) Load(path string) error {
+	h.path = path
+	h.entries = nil
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			h.entries = append(h.entries, line)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	if len(h.entries) > h.size {
+		h.entries = h.entries[len(h.entries)-h.size:]
+		return h.save()
+	}
+	return nil
+}
+
+// Entries returns the commands from the oldest to the newest
+func (h *History) Entries() []string {
+	entries := make([]string, len(h.entries))
+	copy(entries, h.entries)
+	return entries
+}
+
+// Add appends a command to the history and the history file. Passwords
+// are scrubbed and a repeat of the last command is not added again.
+func (h *History) Add(line string) error {
+	line = scrubPasswords(strings.TrimSpace(line))
+	if line == "" || (len(h.entries) > 0 && h.entries[len(h.entries)-1] == line) {
+		return nil
+	}
+
+	h.entries = append(h.entries, line)
+	if len(h.entries) > h.size {
+		h.entries = h.entries[len(h.entries)-h.size:]
+		return h.save()
+	}
+	return h.append(line)
+}
+
+// Clear removes all commands from the history and the history file
+func (h *History) Clear() error {
+	h.entries = nil
+	return h.save()
+}
+
+// Expand replaces a history reference at the start of a line with the
+// command it refers to: !! is the last command, !N the command number N,
+// !-N the Nth last command and !text the last command starting with text
+func (h *History) Expand(line string) (string, error) {
+	ref, rest := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		ref, rest = line[:i], line[i:]
+	}
+
+	event := strings.TrimPrefix(ref, HISTORY_REF)
+	index := -1
+	switch n, err := strconv.Atoi(event); {
+	case event == HISTORY_REF:
+		index = len(h.entries) - 1
+	case err == nil && n > 0:
+		index = n - 1
+	case err == nil && n < 0:
+		index = len(h.entries) + n
+	case err != nil && event != "":
+		for i := len(h.entries) - 1; i >= 0; i-- {
+			if strings.HasPrefix(h.entries[i], event) {
+				index = i
+				break
+			}
+		}
+	}
+
+	if index < 0 || index >= len(h.entries) {
+		return "", fmt.Errorf("Event '%s' not found in history", ref)
+	}
+	return h.entries[index] + rest, nil
+}
+
+func (h *History) append(line string) error {
+	if h.path == "" {
+		return nil
+	}
+	f, err := openHistoryFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, line)
+	return err
+}
+
+func (h *History) save() error {
+	if h.path == "" {
+		return nil
+	}
+	f, err := openHistoryFile(h.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, line := range h.entries {
+		fmt.Fprintln(w, line)
+	}
+	return w.Flush()
+}
+
+// openHistoryFile opens the history file readable only by the user
+func openHistoryFile(path string, flag int) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, flag, 0600)
+}
+
+// scrubPasswords hides the values of password options and the passwords
+// of the open connections
+func scrubPasswords(line string) string {
+	line = passwordOption.ReplaceAllString(line, "${1}${2}${3}"+SCRUBBED_PASSWORD)
+	for _, v := range Sessions.All() {
+		if v.auth != nil && v.auth.password != "" {
+			line = strings.Replace(line, v.auth.password, SCRUBBED_PASSWORD, -1)
+		}
+	}
+	return line
+}
+
+func (cmd *HistoryCommand) Usage() string {
+	return `Usage: history [options] [count]
+
+List the commands run at the prompt, the last count commands if given.
+A command is run again with !number, !! runs the last command, !-N the Nth
+last command and !text the last command starting with text. Ctrl-R
+searches the history backwards.
+
+Options:
+  -c    Clear the history
+
+Examples:
+  history 20
+  !42
+  !vm list -o json
+`
+}
+
+func (cmd *HistoryCommand) Execute(v *Vcli, args ...string) (*Result, error) {
+	historyCmd := flag.NewFlagSet("history", flag.ContinueOnError)
+	clear := historyCmd.Bool("c", false, "Clear the history")
+	names, err := parseFlags(historyCmd, args)
+	if err != nil || len(names) > 1 {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	if *clear {
+		if err := CmdHistory.Clear(); err != nil {
+			return nil, err
+		}
+		return MessageResult("History cleared"), nil
+	}
+
+	entries := CmdHistory.Entries()
+	first := 0
+	if len(names) == 1 {
+		count, err := strconv.Atoi(names[0])
+		if err != nil || count < 0 {
+			return UsageResult(cmd.Usage()), nil
+		}
+		if count < len(entries) {
+			first = len(entries) - count
+		}
+	}
+
+	tbl := NewTable([]Column{
+		{Header: "#", Field: "number"},
+		{Header: "Command", Field: "command"},
+	}...)
+	for i := first; i < len(entries); i++ {
+		tbl.AddRow(i+1, entries[i])
+	}
+	return TableResult(tbl), nil
+}
+
+// HistorySearch is the state of the Ctrl-R reverse incremental search.
+// While searching, the input is the search text and the prompt shows the
+// newest command containing it.
+type HistorySearch struct {
+	active bool
+	query  string
+	index  int
+}
+
+var Search = &HistorySearch{}
+
+// historyKeyBinds are the go-prompt key bindings of the reverse search
+var historyKeyBinds = []prompt.KeyBind{
+	{Key: prompt.ControlR, Fn: func(buf *prompt.Buffer) { Search.Older(buf.Text()) }},
+	{Key: prompt.ControlG, Fn: func(buf *prompt.Buffer) { Search.Cancel() }},
+	{Key: prompt.ControlC, Fn: func(buf *prompt.Buffer) { Search.Cancel() }},
+	{Key: prompt.Escape, Fn: func(buf *prompt.Buffer) { Search.Cancel() }},
+	// moving the cursor edits the found command
+	{Key: prompt.Left, Fn: editMatch},
+	{Key: prompt.Right, Fn: editMatch},
+	{Key: prompt.Home, Fn: editMatch},
+	{Key: prompt.End, Fn: editMatch},
+	{Key: prompt.ControlA, Fn: editMatch},
+	{Key: prompt.ControlE, Fn: editMatch},
+}
+
+// Older starts a search for query, or continues a running search with
+// the next older match
+func (s *HistorySearch) Older(query string) {
+	if !s.active || s.query != query {
+		s.active = true
+		s.query = query
+		s.index = s.find(len(CmdHistory.entries) - 1)
+		return
+	}
+	// keep the current match if there is no older one
+	if i := s.find(s.index - 1); i >= 0 {
+		s.index = i
+	}
+}
+
+// Update searches again from the newest command when the search text
+// changes, it reports whether a search is running. go-prompt resets the
+// completion with an empty text on every key, so an empty text is not
+// taken as a change.
+func (s *HistorySearch) Update(query string) bool {
+	if s.active && query != "" && s.query != query {
+		s.query = query
+		s.index = s.find(len(CmdHistory.entries) - 1)
+	}
+	return s.active
+}
+
+// find returns the newest command at or before from containing the
+// search text, -1 if there is none
+func (s *HistorySearch) find(from int) int {
+	entries := CmdHistory.entries
+	for i := from; i >= 0; i-- {
+		if strings.Contains(entries[i], s.query) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Match returns the command found by the search
+func (s *HistorySearch) Match() (string, bool) {
+	entries := CmdHistory.entries
+	if !s.active || s.index < 0 || s.index >= len(entries) {
+		return "", false
+	}
+	return entries[s.index], true
+}
+
+func (s *HistorySearch) Cancel() {
+	s.active = false
+	s.query = ""
+	s.index = -1
+}
+
+// Accept ends the search, returning the found command in place of the
+// search text
+func (s *HistorySearch) Accept(input string) string {
+	if !s.active {
+		return input
+	}
+	match, ok := s.Match()
+	if input == "" {
+		ok = false
+	}
+	s.Cancel()
+	if !ok {
+		return input
+	}
+	return match
+}
+
+// Prefix is the prompt prefix while searching
+func (s *HistorySearch) Prefix() string {
+	if match, ok := s.Match(); ok {
+		return fmt.Sprintf("(reverse-i-search)'%s': ", match)
+	}
+	return "(failed reverse-i-search): "
+}
+
+// editMatch replaces the search text with the found command to edit it
+func editMatch(buf *prompt.Buffer) {
+	if !Search.active {
+		return
+	}
+	match := Search.Accept(buf.Text())
+	buf.DeleteBeforeCursor(len([]rune(buf.Document().TextBeforeCursor())))
+	buf.Delete(len([]rune(buf.Text())))
+	buf.InsertText(match, false, true)
+}
@@ -0,0 +1,608 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/go/vcli/filter"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type HostCommand struct{}
+type HostListCommand struct{}
+type HostInfoCommand struct{}
+type HostMaintenanceCommand struct{}
+type HostRebootCommand struct{}
+type HostShutdownCommand struct{}
+type HostDisconnectCommand struct{}
+type HostReconnectCommand struct{}
+
+const (
+	HOST_LIST        = "list"
+	HOST_INFO        = "info"
+	HOST_MAINTENANCE = "maintenance"
+	HOST_REBOOT      = "reboot"
+	HOST_SHUTDOWN    = "shutdown"
+	HOST_DISCONNECT  = "disconnect"
+	HOST_RECONNECT   = "reconnect"
+
+	HOST_ENTER_MAINTENANCE = "enter"
+	HOST_EXIT_MAINTENANCE  = "exit"
+)
+
+var hostCommands = map[string]Command{
+	HOST_LIST:        &HostListCommand{},
+	HOST_INFO:        &HostInfoCommand{},
+	HOST_MAINTENANCE: &HostMaintenanceCommand{},
+	HOST_REBOOT:      &HostRebootCommand{},
+	HOST_SHUTDOWN:    &HostShutdownCommand{},
+	HOST_DISCONNECT:  &HostDisconnectCommand{},
+	HOST_RECONNECT:   &HostReconnectCommand{},
+}
+
+type hostActionFunc func(context.Context, *object.HostSystem) (*object.Task, error)
+
+type hostAction struct {
+	action             string
+	startActionMessage string
+}
+
+var hostActions = map[string]hostAction{
+	HOST_ENTER_MAINTENANCE: hostAction{"EnterMaintenanceMode", "Entering maintenance mode on"},
+	HOST_EXIT_MAINTENANCE:  hostAction{"ExitMaintenanceMode", "Exiting maintenance mode on"},
+	HOST_REBOOT:            hostAction{"Reboot", "Rebooting"},
+	HOST_SHUTDOWN:          hostAction{"Shutdown", "Shutting down"},
+	HOST_DISCONNECT:        hostAction{"Disconnect", "Disconnecting"},
+	HOST_RECONNECT:         hostAction{"Reconnect", "Reconnecting"},
+}
+
+func (c *HostCommand) Execute(v *Vcli, args ...string) (*Result, error) {
+	if len(args) > 0 {
+		cmd := args[0]
+		options := args[1:]
+		if fn, ok := hostCommands[cmd]; ok {
+			t, err := fn.Execute(v, options...)
+			return t, err
+		}
+		return nil, fmt.Errorf("Unknown subcommand '%s' for host", cmd)
+	}
+	return UsageResult(c.Usage()), nil
+}
+
+func (c *HostCommand) Usage() string {
+	return `Usage: host [command]
+
+Commands:
+  list           List all ESXi hosts
+  info           Display summary info of a host
+  maintenance    Enter or exit maintenance mode
+  reboot         Reboot host(s)
+  shutdown       Shut down host(s)
+  disconnect     Disconnect host(s) from vCenter
+  reconnect      Reconnect host(s) to vCenter
+`
+}
+
+func (cmd *HostListCommand) Usage() string {
+	return `Usage: host list [options]
+
+List all ESXi hosts
+
+Options:
+  -grep=pattern     Show only the hosts whose name or cluster contains pattern
+` + listOptionsHelp + `
+
+Columns:
+  name, cluster, connection, power, maintenance, cpu, mem, version, build,
+  uptime
+
+` + filterHelp + `
+
+Examples:
+  host list -grep BLR
+  host list -filter 'maintenance==true || connection!=connected'
+  host list -sort mem:desc -cols name,cluster,cpu,mem
+`
+}
+
+func (cmd *HostListCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	listCmd := flag.NewFlagSet("list", flag.ContinueOnError)
+	listGrep := listCmd.String("grep", "", "Search pattern")
+	opts := newListFlags(listCmd)
+	if _, err := parseFlags(listCmd, args); err != nil {
+		return UsageResult(cmd.Usage()), nil
+	}
+	grep := *listGrep
+
+	hosts, err := getAllHostSystems(cli, []string{"summary", "parent"})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(hosts) == 0 {
+		return nil, errors.New("No hosts found")
+	}
+
+	clusters, err := getHostClusterNames(cli, hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	tbl := NewTable([]Column{
+		{Header: "#", Field: "index"},
+		{Header: "Name", Field: "name"},
+		{Header: "Cluster", Field: "cluster"},
+		{Header: "Connection", Field: "connection_state", Key: "connection"},
+		{Header: "Power", Field: "power_state", Key: "power"},
+		{Header: "Maintenance", Field: "maintenance", Kind: filter.Bool},
+		{Header: "CPU", Field: "cpu_usage_mhz", Key: "cpu", Kind: filter.Frequency},
+		{Header: "Memory", Field: "memory_usage_bytes", Key: "mem", Kind: filter.Size},
+		{Header: "Version", Field: "version"},
+		{Header: "Build", Field: "build"},
+		{Header: "Uptime", Field: "uptime_secs", Key: "uptime", Kind: filter.Duration},
+	}...)
+
+	for index, h := range hosts {
+		s := h.Summary
+		name := s.Config.Name
+		cluster := clusters[h.Reference()]
+		if grep != "" && !strings.Contains(name, grep) && !strings.Contains(cluster, grep) {
+			continue
+		}
+
+		var version, build string
+		if s.Config.Product != nil {
+			version = s.Config.Product.Version
+			build = s.Config.Product.Build
+		}
+
+		memUsage := int64(s.QuickStats.OverallMemoryUsage) * 1024 * 1024
+		tbl.AddRow(index+1,
+			name,
+			cluster,
+			string(s.Runtime.ConnectionState),
+			string(s.Runtime.PowerState),
+			s.Runtime.InMaintenanceMode,
+			cpuCell(s.QuickStats.OverallCpuUsage),
+			memoryCell(memUsage),
+			version,
+			build,
+			NewCell(getUptimeString(int64(s.QuickStats.Uptime)), s.QuickStats.Uptime))
+	}
+
+	tbl, err = opts.Apply(tbl, nil)
+	if err != nil {
+		return nil, err
+	}
+	return TableResult(tbl), nil
+}
+
+func (cmd *HostInfoCommand) Usage() string {
+	return `Usage: host info host-name OR #
+
+Examples:
+  host info esx-01.example.com
+  host info 1
+`
+}
+
+func (cmd *HostInfoCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	if len(args) <= 0 {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	hosts, err := getAllHostSystems(cli, []string{"summary", "parent", "vm", "datastore", "network", "overallStatus"})
+	if err != nil {
+		return nil, err
+	}
+
+	targets, err := findHostSystems(hosts, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	h := targets[0]
+	clusters, err := getHostClusterNames(cli, targets)
+	if err != nil {
+		return nil, err
+	}
+
+	tbl := NewTable([]Column{
+		{Header: "Name", Field: "name"},
+		{Header: "Cluster", Field: "cluster"},
+		{Header: "Status", Field: "overall_status"},
+		{Header: "Connection", Field: "connection_state"},
+		{Header: "Power", Field: "power_state"},
+		{Header: "Maintenance", Field: "maintenance"},
+		{Header: "Vendor", Field: "vendor"},
+		{Header: "Model", Field: "model"},
+		{Header: "CPU model", Field: "cpu_model"},
+		{Header: "CPU", Field: "cpu_cores"},
+		{Header: "CPU usage", Field: "cpu_usage_mhz"},
+		{Header: "CPU capacity", Field: "cpu_capacity_mhz"},
+		{Header: "Memory usage", Field: "memory_usage_bytes"},
+		{Header: "Memory capacity", Field: "memory_capacity_bytes"},
+		{Header: "Product", Field: "product"},
+		{Header: "Version", Field: "version"},
+		{Header: "Build", Field: "build"},
+		{Header: "Boot time", Field: "boot_time"},
+		{Header: "Uptime", Field: "uptime_secs"},
+		{Header: "VMs", Field: "vms"},
+		{Header: "Datastores", Field: "datastores"},
+		{Header: "Networks", Field: "networks"},
+	}...)
+
+	s := h.Summary
+	var vendor, model, cpuModel string
+	var cores int16
+	var cpuCapacity int32
+	var memCapacity int64
+	if s.Hardware != nil {
+		vendor = s.Hardware.Vendor
+		model = s.Hardware.Model
+		cpuModel = s.Hardware.CpuModel
+		cores = s.Hardware.NumCpuCores
+		cpuCapacity = s.Hardware.CpuMhz * int32(s.Hardware.NumCpuCores)
+		memCapacity = s.Hardware.MemorySize
+	}
+
+	var product, version, build string
+	if s.Config.Product != nil {
+		product = s.Config.Product.FullName
+		version = s.Config.Product.Version
+		build = s.Config.Product.Build
+	}
+
+	var bootTime interface{}
+	if s.Runtime.BootTime != nil {
+		bootTime = NewCell(s.Runtime.BootTime.String(), *s.Runtime.BootTime)
+	}
+
+	memUsage := int64(s.QuickStats.OverallMemoryUsage) * 1024 * 1024
+	tbl.Vertical = true
+	tbl.AddRow(s.Config.Name,
+		clusters[h.Reference()],
+		string(h.OverallStatus),
+		string(s.Runtime.ConnectionState),
+		string(s.Runtime.PowerState),
+		s.Runtime.InMaintenanceMode,
+		vendor,
+		model,
+		cpuModel,
+		NewCell(strconv.Itoa(int(cores))+" core(s)", cores),
+		cpuCell(s.QuickStats.OverallCpuUsage),
+		cpuCell(cpuCapacity),
+		memoryCell(memUsage),
+		memoryCell(memCapacity),
+		product,
+		version,
+		build,
+		bootTime,
+		NewCell(getUptimeString(int64(s.QuickStats.Uptime)), s.QuickStats.Uptime),
+		len(h.Vm),
+		len(h.Datastore),
+		len(h.Network))
+
+	return TableResult(tbl), nil
+}
+
+func (cmd *HostMaintenanceCommand) Usage() string {
+	return `Usage: host maintenance enter|exit [options] host-name1 [,host-name2, ...]
+
+Put host(s) into or take them out of maintenance mode
+
+Options:
+  -evacuate         Evacuate powered off VMs when entering maintenance mode
+  -timeout=secs     Fail if the operation doesn't complete in time (default 0, no timeout)
+
+Examples:
+  host maintenance enter esx-01
+  host maintenance enter -evacuate -timeout 600 esx-01,esx-02
+  host maintenance exit esx-01
+`
+}
+
+func (cmd *HostMaintenanceCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	if len(args) < 2 || (args[0] != HOST_ENTER_MAINTENANCE && args[0] != HOST_EXIT_MAINTENANCE) {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	action := args[0]
+	maintenanceCmd := flag.NewFlagSet(HOST_MAINTENANCE, flag.ContinueOnError)
+	evacuate := maintenanceCmd.Bool("evacuate", false, "Evacuate powered off VMs")
+	timeout := maintenanceCmd.Int("timeout", 0, "Timeout in seconds")
+	names, err := parseFlags(maintenanceCmd, args[1:])
+	if err != nil || len(names) == 0 {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	if action == HOST_ENTER_MAINTENANCE {
+		return executeHostCommand(action, cli, strings.Join(names, ","), func(ctx context.Context, h *object.HostSystem) (*object.Task, error) {
+			return h.EnterMaintenanceMode(ctx, int32(*timeout), *evacuate, nil)
+		})
+	}
+	return executeHostCommand(action, cli, strings.Join(names, ","), func(ctx context.Context, h *object.HostSystem) (*object.Task, error) {
+		return h.ExitMaintenanceMode(ctx, int32(*timeout))
+	})
+}
+
+func (cmd *HostRebootCommand) Usage() string {
+	return `Usage: host reboot [-force] host-name1 [,host-name2, ...]
+
+Reboot host(s). Without -force the host must be in maintenance mode.
+
+Examples:
+  host reboot esx-01
+  host reboot -force esx-01,esx-02
+`
+}
+
+func (cmd *HostRebootCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	rebootCmd := flag.NewFlagSet(HOST_REBOOT, flag.ContinueOnError)
+	force := rebootCmd.Bool("force", false, "Reboot even if the host is not in maintenance mode")
+	names, err := parseFlags(rebootCmd, args)
+	if err != nil || len(names) == 0 {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	return executeHostCommand(HOST_REBOOT, cli, strings.Join(names, ","), func(ctx context.Context, h *object.HostSystem) (*object.Task, error) {
+		req := types.RebootHost_Task{This: h.Reference(), Force: *force}
+		res, err := methods.RebootHost_Task(ctx, h.Client(), &req)
+		if err != nil {
+			return nil, err
+		}
+		return object.NewTask(h.Client(), res.Returnval), nil
+	})
+}
+
+func (cmd *HostShutdownCommand) Usage() string {
+	return `Usage: host shutdown [-force] host-name1 [,host-name2, ...]
+
+Shut down host(s). Without -force the host must be in maintenance mode.
+
+Examples:
+  host shutdown esx-01
+  host shutdown -force esx-01,esx-02
+`
+}
+
+func (cmd *HostShutdownCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	shutdownCmd := flag.NewFlagSet(HOST_SHUTDOWN, flag.ContinueOnError)
+	force := shutdownCmd.Bool("force", false, "Shut down even if the host is not in maintenance mode")
+	names, err := parseFlags(shutdownCmd, args)
+	if err != nil || len(names) == 0 {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	return executeHostCommand(HOST_SHUTDOWN, cli, strings.Join(names, ","), func(ctx context.Context, h *object.HostSystem) (*object.Task, error) {
+		req := types.ShutdownHost_Task{This: h.Reference(), Force: *force}
+		res, err := methods.ShutdownHost_Task(ctx, h.Client(), &req)
+		if err != nil {
+			return nil, err
+		}
+		return object.NewTask(h.Client(), res.Returnval), nil
+	})
+}
+
+func (cmd *HostDisconnectCommand) Usage() string {
+	return `Usage: host disconnect host-name1 [,host-name2, ...]
+
+Disconnect host(s) from vCenter
+
+Examples:
+  host disconnect esx-01
+  host disconnect esx-01,esx-02
+`
+}
+
+func (cmd *HostDisconnectCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	disconnectCmd := flag.NewFlagSet(HOST_DISCONNECT, flag.ContinueOnError)
+	names, err := parseFlags(disconnectCmd, args)
+	if err != nil || len(names) == 0 {
+		return UsageResult(cmd.Usage()), nil
+	}
+	return executeHostCommand(HOST_DISCONNECT, cli, strings.Join(names, ","), func(ctx context.Context, h *object.HostSystem) (*object.Task, error) {
+		return h.Disconnect(ctx)
+	})
+}
+
+func (cmd *HostReconnectCommand) Usage() string {
+	return `Usage: host reconnect host-name1 [,host-name2, ...]
+
+Reconnect host(s) to vCenter
+
+Examples:
+  host reconnect esx-01
+  host reconnect esx-01,esx-02
+`
+}
+
+func (cmd *HostReconnectCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	reconnectCmd := flag.NewFlagSet(HOST_RECONNECT, flag.ContinueOnError)
+	names, err := parseFlags(reconnectCmd, args)
+	if err != nil || len(names) == 0 {
+		return UsageResult(cmd.Usage()), nil
+	}
+	return executeHostCommand(HOST_RECONNECT, cli, strings.Join(names, ","), func(ctx context.Context, h *object.HostSystem) (*object.Task, error) {
+		return h.Reconnect(ctx, nil, nil)
+	})
+}
+
+// executeHostCommand runs a host task on every host given as a comma separated
+// list of names or numbers, in parallel
+func executeHostCommand(action string, cli *Vcli, names string, fn hostActionFunc) (*Result, error) {
+	ctx := cli.ctx
+	c := cli.client.Client
+
+	hosts, err := getAllHostSystems(cli, []string{"summary"})
+	if err != nil {
+		return nil, err
+	}
+
+	targets, err := findHostSystems(hosts, names)
+	if err != nil {
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	rows := make([][]interface{}, len(targets))
+	errs := make([]error, len(targets))
+
+	for i, host := range targets {
+		wg.Add(1)
+		go func(i int, host mo.HostSystem) {
+			defer wg.Done()
+			hostRef := object.NewHostSystem(c, host.Reference())
+			hostName := host.Summary.Config.Name
+			Progress("%s '%s'...", hostActions[action].startActionMessage, hostName)
+
+			task, err := fn(ctx, hostRef)
+			if err = waitForTask(ctx, task, err, hostName); err != nil {
+				rows[i] = []interface{}{hostName, hostActions[action].action, "failed"}
+				errs[i] = fmt.Errorf("Failed to %s host '%s': %s", hostActions[action].action, hostName, err.Error())
+				return
+			}
+			rows[i] = []interface{}{hostName, hostActions[action].action, "completed"}
+		}(i, host)
+	}
+	wg.Wait()
+
+	r := TableResult(NewTable(vmActionColumns...))
+	for i := range targets {
+		r.Table.AddRow(rows[i]...)
+		if errs[i] != nil {
+			r.AddError(errs[i])
+		}
+	}
+	return r, nil
+}
+
+// getAllHostSystems retrieves the given properties, which must include
+// 'summary', of every host in the inventory cache, sorted by name like the
+// numbers of 'host list'
+func getAllHostSystems(cli *Vcli, props []string) ([]mo.HostSystem, error) {
+	objects, err := cli.inventory.Objects(cli.ctx, cli, INVENTORY_HOST)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, nil
+	}
+
+	refs := make([]types.ManagedObjectReference, 0, len(objects))
+	for _, o := range objects {
+		refs = append(refs, o.Ref)
+	}
+
+	var hosts []mo.HostSystem
+	pc := property.DefaultCollector(cli.client.Client)
+	if err := pc.Retrieve(cli.ctx, refs, props, &hosts); err != nil {
+		return nil, err
+	}
+
+	byRef := make(map[types.ManagedObjectReference]mo.HostSystem, len(hosts))
+	for _, h := range hosts {
+		byRef[h.Self] = h
+	}
+	ordered := make([]mo.HostSystem, 0, len(hosts))
+	for _, ref := range refs {
+		if h, ok := byRef[ref]; ok {
+			ordered = append(ordered, h)
+		}
+	}
+	return ordered, nil
+}
+
+// findHostSystems matches a comma separated list of host names or numbers,
+// every element of the list has to match a host
+func findHostSystems(hosts []mo.HostSystem, names string) ([]mo.HostSystem, error) {
+	var targets []mo.HostSystem
+	var missing []string
+	seen := make(map[types.ManagedObjectReference]bool)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		h, ok := matchHostName(hosts, name)
+		if !ok {
+			missing = append(missing, "'"+name+"'")
+			continue
+		}
+		if !seen[h.Self] {
+			seen[h.Self] = true
+			targets = append(targets, h)
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("Host(s) %s not found", strings.Join(missing, ", "))
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("No host is given")
+	}
+	return targets, nil
+}
+
+// matchHostName returns the host named name, or else the host with that
+// number in 'host list'
+func matchHostName(hosts []mo.HostSystem, name string) (mo.HostSystem, bool) {
+	for _, h := range hosts {
+		if h.Summary.Config.Name == name {
+			return h, true
+		}
+	}
+	for index, h := range hosts {
+		if strconv.Itoa(index+1) == name {
+			return h, true
+		}
+	}
+	return mo.HostSystem{}, false
+}
+
+// getHostClusterNames maps each host to the name of its cluster, standalone
+// hosts are left out. Hosts must have the 'parent' property.
+func getHostClusterNames(cli *Vcli, hosts []mo.HostSystem) (map[types.ManagedObjectReference]string, error) {
+	names := make(map[types.ManagedObjectReference]string, len(hosts))
+	seen := make(map[types.ManagedObjectReference]bool)
+	var refs []types.ManagedObjectReference
+	for _, h := range hosts {
+		if h.Parent != nil && h.Parent.Type == "ClusterComputeResource" && !seen[*h.Parent] {
+			seen[*h.Parent] = true
+			refs = append(refs, *h.Parent)
+		}
+	}
+
+	if len(refs) == 0 {
+		return names, nil
+	}
+
+	var clusters []mo.ClusterComputeResource
+	pc := property.DefaultCollector(cli.client.Client)
+	err := pc.Retrieve(cli.ctx, refs, []string{"name"}, &clusters)
+	if err != nil {
+		return nil, err
+	}
+
+	clusterNames := make(map[types.ManagedObjectReference]string, len(clusters))
+	for _, cl := range clusters {
+		clusterNames[cl.Reference()] = cl.Name
+	}
+
+	for _, h := range hosts {
+		if h.Parent != nil {
+			if name, ok := clusterNames[*h.Parent]; ok {
+				names[h.Reference()] = name
+			}
+		}
+	}
+	return names, nil
+}
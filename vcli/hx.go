@@ -3,19 +3,17 @@ package main
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"github.com/tatsushid/go-prettytable"
+	"github.com/go/vcli/filter"
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/property"
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
 	"io/ioutil"
 	"net/http"
-	_ "regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -124,6 +122,9 @@ type ClusterSummary struct {
 		Health ClusterHealth
 		Time   ClusterTime
 	}
+
+	// responses that couldn't be decoded, the summary is partial
+	parseErrors []error
 }
 
 type NetworkAddress struct {
@@ -137,20 +138,17 @@ type ClusterNetwork struct {
 	WitnessNode          NetworkAddress `json:"witnessNode"`
 }
 
-func (c *HxCommand) Execute(v *Vcli, args ...string) (*prettytable.Table, error) {
+func (c *HxCommand) Execute(v *Vcli, args ...string) (*Result, error) {
 	if len(args) > 0 {
 		cmd := args[0]
 		options := args[1:]
 		if fn, ok := hxCommands[cmd]; ok {
 			t, err := fn.Execute(v, options...)
 			return t, err
-		} else {
-			Error("Unknown subcommand '%s' for hx\n", cmd)
 		}
-		return nil, nil
+		return nil, fmt.Errorf("Unknown subcommand '%s' for hx", cmd)
 	}
-	Usage(c.Usage())
-	return nil, nil
+	return UsageResult(c.Usage()), nil
 }
 
 func (c *HxCommand) Usage() string {
@@ -164,12 +162,28 @@ Commands:
 `
 }
 
-func (cmd *HxListCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table, error) {
-	ctx := cli.ctx
-	c := cli.client.Client
-	pc := property.DefaultCollector(c)
+func (cmd *HxListCommand) Usage() string {
+	return `Usage: hx list [options]
 
-	clusters, err := GetClusterComputeResources(cli)
+List all HX clusters registered in this VC
+
+` + clusterListHelp + `
+
+Examples:
+  hx list -filter 'name=~"^BLR" && cpu>=100GHz'
+  hx list -sort hosts:desc
+  hx list -cols name,cpu,mem
+`
+}
+
+func (cmd *HxListCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	listCmd := flag.NewFlagSet("list", flag.ContinueOnError)
+	opts := newListFlags(listCmd)
+	if _, err := parseFlags(listCmd, args); err != nil {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	clusters, err := cli.inventory.Objects(cli.ctx, cli, INVENTORY_CLUSTER)
 	if err != nil {
 		return nil, err
 	}
@@ -178,79 +192,65 @@ func (cmd *HxListCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table
 		return nil, errors.New("No clusters found")
 	}
 
-	var hxClusters []*object.ClusterComputeResource
+	hxClusters, err := getHxClusterRefs(cli)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, clr := range clusters {
-		hostObjects, _ := clr.ComputeResource.Hosts(ctx)
-		refs := make([]types.ManagedObjectReference, 0, len(hostObjects))
-		for _, o := range hostObjects {
-			refs = append(refs, o.Reference())
-		}
+	tbl := NewTable(clusterColumns...)
 
-		var hostSystems []mo.HostSystem
-		err = pc.Retrieve(ctx, refs, []string{"summary", "network"}, &hostSystems)
-		if err != nil {
+	index := 0
+	for _, cl := range clusters {
+		if !hxClusters[cl.Ref] {
 			continue
 		}
-
-		ctlvmFound := false
-
-		for _, host := range hostSystems {
-			var networks []mo.Network
-			err := pc.Retrieve(ctx, host.Network, []string{"vm"}, &networks)
-			if err != nil {
-				continue
-			}
-			for _, nw := range networks {
-				var vms []mo.VirtualMachine
-				err := pc.Retrieve(ctx, nw.Vm, []string{"name"}, &vms)
-				if err != nil {
-					continue
-				}
-				for _, vm := range vms {
-					//fmt.Println(vm.Name)
-					if strings.HasPrefix(vm.Name, "stCtlVM") {
-						ctlvmFound = true
-						break
-					}
-				}
-
-				if ctlvmFound {
-					break
-				}
-			}
-
-			if ctlvmFound {
-				break
-			}
-		}
-
-		if ctlvmFound {
-			hxClusters = append(hxClusters, clr)
-		}
+		index++
+		tbl.AddRow(index, cl.Name, cl.Path, cl.Int("summary.numHosts"), cpuCell(int32(cl.Int("summary.totalCpu"))), cl.Int("summary.numCpuCores"), memoryCell(cl.Int("summary.totalMemory")))
 	}
 
-	if len(hxClusters) <= 0 {
+	if index == 0 {
 		return nil, errors.New("No HX clusters found")
 	}
 
-	tbl, err := prettytable.NewTable([]prettytable.Column{
-		{Header: "#"},
-		{Header: "Name", MinWidth: 6},
-		{Header: "Path"},
-		{Header: "Hosts"},
-		{Header: "TotalCPU"},
-		{Header: "Cores"},
-		{Header: "TotalMemory"},
-	}...)
+	tbl, err = opts.Apply(tbl, nil)
+	if err != nil {
+		return nil, err
+	}
+	return TableResult(tbl), nil
+}
 
-	for index, cl := range hxClusters {
-		cr, _ := GetComputeResource(cli, &cl.ComputeResource)
-		summary := cr.Summary.GetComputeResourceSummary()
-		tbl.AddRow(index+1, cl.Name(), cl.InventoryPath, summary.NumHosts, getCpuInGHz(summary.TotalCpu), summary.NumCpuCores, getMemoryInGB(summary.TotalMemory))
+// getHxClusterRefs returns the clusters with a storage controller VM on
+// one of their hosts. The VMs, their hosts and the clusters of the hosts
+// come from the inventory cache in one pass.
+func getHxClusterRefs(cli *Vcli) (map[types.ManagedObjectReference]bool, error) {
+	hosts, err := cli.inventory.Objects(cli.ctx, cli, INVENTORY_HOST)
+	if err != nil {
+		return nil, err
+	}
+	vms, err := cli.inventory.Objects(cli.ctx, cli, INVENTORY_VM)
+	if err != nil {
+		return nil, err
 	}
 
-	return tbl, nil
+	clusterOf := make(map[types.ManagedObjectReference]types.ManagedObjectReference, len(hosts))
+	for _, host := range hosts {
+		if parent := host.Reference("parent"); parent != nil && parent.Type == INVENTORY_CLUSTER {
+			clusterOf[host.Ref] = *parent
+		}
+	}
+
+	hxClusters := make(map[types.ManagedObjectReference]bool)
+	for _, vm := range vms {
+		if !strings.HasPrefix(vm.Name, "stCtlVM") {
+			continue
+		}
+		if host := vm.Reference("runtime.host"); host != nil {
+			if cluster, ok := clusterOf[*host]; ok {
+				hxClusters[cluster] = true
+			}
+		}
+	}
+	return hxClusters, nil
 }
 
 func (cmd *HxInfoCommand) Usage() string {
@@ -261,6 +261,11 @@ Display summary info of HX cluster(s) registered in this VC
 Options:
   -grep=pattern   Filter cluster summary info based on given search pattern
                   Available filter fields are Name, Version, Build, SerialNumber, ModelNumber and CIP
+  -filter=expr    Show only the clusters matching a filter expression on the fields
+                  name, version, build, cip, state, uuid, all_flash, serial, model,
+                  policy, rf, uptime, capacity and free
+
+` + filterHelp + `
 
 Examples:
   hx info all
@@ -269,20 +274,44 @@ Examples:
   hx info -grep 3.5.2g all
   hx info -grep=240C all
   hx info -grep=UCSB-B200-M5 all
+  hx info -filter 'version=~"^4\.0" && free<2TB' all
 	`
 }
 
-func (cmd *HxInfoCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table, error) {
+// columns of 'hx info', the keys are given to -filter
+var hxInfoColumns = []Column{
+	{Header: "Name", Field: "name"},
+	{Header: "Version", Field: "version"},
+	{Header: "Build", Field: "build"},
+	{Header: "CIP", Field: "cip"},
+	{Header: "State", Field: "state"},
+	{Header: "UUID", Field: "uuid"},
+	{Header: "AllFlash", Field: "all_flash", Kind: filter.Bool},
+	{Header: "SerialNumber", Field: "serial_number", Key: "serial"},
+	{Header: "ModelNumber", Field: "model_number", Key: "model"},
+	{Header: "AccessPolicy", Field: "access_policy", Key: "policy"},
+	{Header: "ReplicationFactor", Field: "replication_factor", Key: "rf"},
+	{Header: "Uptime", Field: "uptime_secs", Key: "uptime", Kind: filter.Duration},
+	{Header: "Total Capacity", Field: "total_capacity_bytes", Key: "capacity", Kind: filter.Size},
+	{Header: "Available Capacity", Field: "available_capacity_bytes", Key: "free", Kind: filter.Size},
+}
+
+func (cmd *HxInfoCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
 	infoCmd := flag.NewFlagSet("info", flag.ContinueOnError)
 	infoGrep := infoCmd.String("grep", "", "Search pattern")
-	infoCmd.Parse(args)
+	infoFilter := infoCmd.String("filter", "", "Filter expression")
+	names, err := parseFlags(infoCmd, args)
+	if err != nil || len(names) == 0 {
+		return UsageResult(cmd.Usage()), nil
+	}
 
-	if len(infoCmd.Args()) == 0 {
-		Usage(cmd.Usage())
-		return nil, nil
+	// a mistake in the filter is reported before the HX controllers are asked
+	rowFilter, err := compileFilter(hxInfoColumns, *infoFilter)
+	if err != nil {
+		return nil, err
 	}
 
-	clusterName := strings.Join(infoCmd.Args(), "")
+	clusterName := strings.Join(names, "")
 	clusters, err := GetClusterComputeResources(cli)
 	if err != nil {
 		return nil, err
@@ -292,6 +321,7 @@ func (cmd *HxInfoCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table
 		return nil, errors.New("No clusters found")
 	}
 
+	r := NewResult()
 	var targetClusters []*object.ClusterComputeResource
 	var hsl []*ClusterSummary
 	if clusterName == "all" {
@@ -309,7 +339,7 @@ func (cmd *HxInfoCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table
 				}
 			}
 			if !found {
-				Errorln("cluster '" + cname + "' doesn't exist")
+				r.AddError(errors.New("cluster '" + cname + "' doesn't exist"))
 			}
 		}
 	}
@@ -319,16 +349,22 @@ func (cmd *HxInfoCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table
 	}
 
 	var wg sync.WaitGroup
+	var mu sync.Mutex
 	for _, clr := range targetClusters {
 		wg.Add(1)
 		go func(clr interface{}) {
 			c := clr.(*object.ClusterComputeResource)
 			defer wg.Done()
 			hs, err := getClusterInfo(cli, c)
+			mu.Lock()
+			defer mu.Unlock()
 			if err == nil && hs != nil {
 				hsl = append(hsl, hs)
-			} else if err.Error() != ERR_NO_SC_MGMT_NETWORK {
-				Errorln("["+c.Name()+"]:", err)
+				for _, e := range hs.parseErrors {
+					r.AddError(fmt.Errorf("[%s]: %s", c.Name(), e))
+				}
+			} else if err != nil && err.Error() != ERR_NO_SC_MGMT_NETWORK {
+				r.AddError(fmt.Errorf("[%s]: %s", c.Name(), err))
 			}
 		}(clr)
 	}
@@ -349,39 +385,31 @@ func (cmd *HxInfoCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table
 		filteredSummaryList = hsl
 	}
 
-	tbl, err := prettytable.NewTable([]prettytable.Column{
-		{Header: "Key", MinWidth: 12},
-		{Header: "Value"},
-	}...)
+	tbl := NewTable(hxInfoColumns...)
 
-	if err != nil {
-		return nil, err
-	}
-
-	tbl.NoHeader = true
-	for index, hx := range filteredSummaryList {
+	tbl.Vertical = true
+	for _, hx := range filteredSummaryList {
 		if hx.Overview.Detail.Name == "" {
 			continue
 		}
-		tbl.AddRow("Name:", hx.Overview.Detail.Name)
-		tbl.AddRow("Version:", hx.Overview.About.DisplayVersion)
-		tbl.AddRow("Build:", hx.Overview.About.Build)
-		tbl.AddRow("CIP:", hx.Overview.Config.MgmtIp.Addr)
-		tbl.AddRow("State:", hx.Overview.Health.State)
-		tbl.AddRow("UUID:", hx.Overview.About.Uuid)
-		tbl.AddRow("AllFlash:", hx.Overview.Detail.AllFlash)
-		tbl.AddRow("SerialNumber:", hx.Overview.About.SerialNumber)
-		tbl.AddRow("ModelNumber:", hx.Overview.About.ModelNumber)
-		tbl.AddRow("AccessPolicy:", hx.Overview.Detail.ClusterAccessPolicy)
-		tbl.AddRow("ReplicationFactor:", hx.Overview.Detail.DataReplicationFactor)
-		tbl.AddRow("Uptime:", getUptimeString(hx.Overview.Time.UptimeInSecs))
-		tbl.AddRow("Total Capacity:", getStorageCapacityInTB(hx.Overview.Stats.TotalCapacityInBytes))
-		tbl.AddRow("Available Capacity:", getStorageCapacityInTB(hx.Overview.Stats.FreeCapacityInBytes))
-		if len(filteredSummaryList) > 1 && (index+1) != len(filteredSummaryList) {
-			tbl.AddRow("-------------------", "-------------------------------------")
-		}
-	}
-	return tbl, nil
+		tbl.AddRow(hx.Overview.Detail.Name,
+			hx.Overview.About.DisplayVersion,
+			hx.Overview.About.Build,
+			hx.Overview.Config.MgmtIp.Addr,
+			hx.Overview.Health.State,
+			hx.Overview.About.Uuid,
+			hx.Overview.Detail.AllFlash,
+			hx.Overview.About.SerialNumber,
+			hx.Overview.About.ModelNumber,
+			hx.Overview.Detail.ClusterAccessPolicy,
+			hx.Overview.Detail.DataReplicationFactor,
+			NewCell(getUptimeString(hx.Overview.Time.UptimeInSecs), hx.Overview.Time.UptimeInSecs),
+			NewCell(getStorageCapacityInTB(hx.Overview.Stats.TotalCapacityInBytes), hx.Overview.Stats.TotalCapacityInBytes),
+			NewCell(getStorageCapacityInTB(hx.Overview.Stats.FreeCapacityInBytes), hx.Overview.Stats.FreeCapacityInBytes))
+	}
+	tbl.Rows = filterRows(tbl, rowFilter)
+	r.Table = tbl
+	return r, nil
 }
 
 func getControllerIp(cli *Vcli, hxCluster *object.ClusterComputeResource) (string, error) {
@@ -444,7 +472,12 @@ func getClusterInfo(cli *Vcli, hxCluster *object.ClusterComputeResource) (*Clust
 		return nil, err
 	}
 
-	hs, err := getHxSummary(cli.auth, ctrlIp)
+	auth, err := hxCredentials(cli)
+	if err != nil {
+		return nil, err
+	}
+
+	hs, err := getHxSummary(cli.ctx, auth, ctrlIp)
 	if err != nil {
 		return nil, err
 	}
@@ -452,26 +485,92 @@ func getClusterInfo(cli *Vcli, hxCluster *object.ClusterComputeResource) (*Clust
 	return hs, nil
 }
 
+// hxCredentials returns the vCenter credentials to log in to HX controllers
+// with, the password is looked up if the session was restored without it
+func hxCredentials(cli *Vcli) (*Credentials, error) {
+	password, err := cli.Password()
+	if err != nil {
+		return nil, fmt.Errorf("Password required for HX: %s", err)
+	}
+	return &Credentials{username: cli.auth.username, password: password}, nil
+}
+
 func (cmd *HxDestroyCommand) Usage() string {
-	return `Usage: hx destroy cluster-name
+	return `Usage: hx destroy [options] cluster-name
+
+Destroys a HX cluster: powers off and unregisters the controller VMs,
+removes the HX vNICs, portgroups, vSwitches and SpringpathDS datastores
+of every host and removes the cluster. The cluster name has to be typed
+to confirm. Nothing is removed if any object of the plan can't be looked
+up.
 
-Destroys a HX cluster
+Options:
+  -yes        Don't ask for confirmation
+  -dry-run    Only show what would be removed
 
 Examples:
   hx destroy 3Node-cluster
+  hx destroy -dry-run 3Node-cluster
 `
 }
 
-func (cmd *HxDestroyCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table, error) {
-	if len(args) == 0 {
-		Usage(cmd.Usage())
-		return nil, nil
+// hxHostPlan is what hx destroy removes from one host of the cluster
+type hxHostPlan struct {
+	name       string
+	host       *object.HostSystem
+	hns        *object.HostNetworkSystem
+	vnics      []string
+	portGroups []string
+	vswitches  []string
+	datastores []mo.Datastore
+}
+
+// hxDestroyPlan lists everything hx destroy touches
+type hxDestroyPlan struct {
+	cluster *object.ClusterComputeResource
+	ctrlVms []mo.VirtualMachine
+	vmHosts map[types.ManagedObjectReference]string
+	hosts   []hxHostPlan
+}
+
+func (p *hxDestroyPlan) Table() *Table {
+	tbl := NewPlanTable()
+	for _, vm := range p.ctrlVms {
+		var host string
+		if vm.Runtime.Host != nil {
+			host = p.vmHosts[*vm.Runtime.Host]
+		}
+		tbl.AddRow("VM", vm.Name, host)
+	}
+	for _, h := range p.hosts {
+		for _, nic := range h.vnics {
+			tbl.AddRow("vNIC", nic, h.name)
+		}
+		for _, pg := range h.portGroups {
+			tbl.AddRow("Portgroup", pg, h.name)
+		}
+		for _, s := range h.vswitches {
+			tbl.AddRow("vSwitch", s, h.name)
+		}
+		for _, ds := range h.datastores {
+			tbl.AddRow("Datastore", ds.Name, h.name)
+		}
+	}
+	tbl.AddRow("Cluster", p.cluster.Name(), "")
+	return tbl
+}
+
+func (cmd *HxDestroyCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	destroyCmd := flag.NewFlagSet(HX_DESTROY, flag.ContinueOnError)
+	yes := destroyCmd.Bool("yes", false, "Don't ask for confirmation")
+	dryRun := destroyCmd.Bool("dry-run", false, "Only show what would be removed")
+	names, err := parseFlags(destroyCmd, args)
+	if err != nil || len(names) != 1 {
+		return UsageResult(cmd.Usage()), nil
 	}
 
-	clusterName := args[0]
+	clusterName := names[0]
 	ctx := cli.ctx
-	c := cli.client.Client
-	pc := property.DefaultCollector(c)
 
 	clusters, err := GetClusterComputeResources(cli)
 	if err != nil {
@@ -495,19 +594,84 @@ func (cmd *HxDestroyCommand) Execute(cli *Vcli, args ...string) (*prettytable.Ta
 		return nil, errors.New("'" + clusterName + "' doesn't exist")
 	}
 
-	hostObjects, err := hxCluster.ComputeResource.Hosts(ctx)
+	r := NewResult()
+	plan, err := getHxDestroyPlan(cli, hxCluster, r)
 	if err != nil {
 		return nil, err
 	}
 
-	dsObjects, err := hxCluster.ComputeResource.Datastores(ctx)
-	if err != nil {
+	// a cluster is never torn down from a plan that misses objects
+	if r.Err() != nil {
+		r.Table = plan.Table()
+		r.Message("The plan is incomplete, nothing has been changed")
+		return r, nil
+	}
+
+	if *dryRun {
+		r.Table = plan.Table()
+		r.Message(DRY_RUN_MESSAGE)
+		return r, nil
+	}
+
+	question := fmt.Sprintf("HX cluster '%s' will be destroyed.", clusterName)
+	if err := confirm(question, clusterName, plan.Table(), *yes); err != nil {
 		return nil, err
 	}
 
-	dsMap := make(map[types.ManagedObjectReference]*object.Datastore, len(hostObjects))
-	for _, o := range dsObjects {
-		dsMap[o.Reference()] = o
+	// Remove Controller VMs from each host
+	removeControllerVms(cli, plan.ctrlVms, r)
+
+	for _, h := range plan.hosts {
+		// Remove Virtual Nics
+		removeVirtualNics(ctx, h.hns, h.vnics, r)
+
+		// Remove PortGroups
+		removePortGroups(ctx, h.hns, h.portGroups, r)
+
+		// Remove Virtual Switches
+		removeVirtualSwitches(ctx, h.hns, h.vswitches, r)
+
+		// Remove springpath datastore from host
+		if len(h.datastores) > 0 {
+			hds, err := h.host.ConfigManager().DatastoreSystem(ctx)
+			if err != nil {
+				r.AddError(errors.New("Failed to find datastore system: " + err.Error()))
+				continue
+			}
+			for _, ds := range h.datastores {
+				err = hds.Remove(ctx, object.NewDatastore(cli.client.Client, ds.Reference()))
+				if err != nil {
+					r.AddError(errors.New("Failed to delete datastore '" + ds.Name + "' :" + err.Error()))
+				}
+			}
+		}
+	}
+
+	// Don't remove datacenter, multiple clusters can come under
+	// a datacenter
+	//err = removeDatacenter(cli, hxCluster)
+
+	// Remove cluster
+	task, err := hxCluster.Destroy(ctx)
+	if err = waitForTask(ctx, task, err, hxCluster.Name()); err != nil {
+		r.AddError(errors.New("Failed to destroy cluster '" + hxCluster.Name() + "' : " + err.Error()))
+	} else {
+		r.Message("Cluster '%s' has been destroyed", hxCluster.Name())
+	}
+	return r, nil
+}
+
+// getHxDestroyPlan looks up the controller VMs, HX networking and
+// SpringpathDS datastores of a cluster. Hosts that can't be inspected are
+// reported in r and left out of the plan.
+func getHxDestroyPlan(cli *Vcli, hxCluster *object.ClusterComputeResource, r *Result) (*hxDestroyPlan, error) {
+	ctx := cli.ctx
+	c := cli.client.Client
+	pc := property.DefaultCollector(c)
+
+	hostObjects, err := hxCluster.ComputeResource.Hosts(ctx)
+	if err != nil {
+		return nil, err
 	}
 
 	refs := make([]types.ManagedObjectReference, 0, len(hostObjects))
@@ -518,84 +682,66 @@ func (cmd *HxDestroyCommand) Execute(cli *Vcli, args ...string) (*prettytable.Ta
 		refs = append(refs, ho.Reference())
 	}
 
+	plan := &hxDestroyPlan{
+		cluster: hxCluster,
+		vmHosts: make(map[types.ManagedObjectReference]string),
+	}
+
 	var hostSystems []mo.HostSystem
-	err = pc.Retrieve(ctx, refs, []string{"summary", "datastore", "network", "vm"}, &hostSystems)
-	if err != nil {
-		return nil, err
+	if len(refs) > 0 {
+		err = pc.Retrieve(ctx, refs, []string{"summary", "datastore", "network", "vm"}, &hostSystems)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// Remove Controller VMs from each host
 	if len(hostSystems) > 0 {
-		removeControllerVms(cli, &hostSystems[0])
+		plan.ctrlVms = findControllerVms(cli, &hostSystems[0], r)
 	}
 
 	for _, host := range hostSystems {
 		hostName := host.Summary.Config.Name
-		hns, err := hsMap[host.Reference()].ConfigManager().NetworkSystem(ctx)
+		plan.vmHosts[host.Reference()] = hostName
 
+		hns, err := hsMap[host.Reference()].ConfigManager().NetworkSystem(ctx)
 		if err != nil {
-			Errorln(err)
+			r.AddError(err)
 			continue
 		}
 
 		var mns mo.HostNetworkSystem
 		err = pc.RetrieveOne(ctx, hns.Reference(), []string{"networkInfo.vnic", "networkInfo.vswitch", "networkInfo.portgroup"}, &mns)
 		if err != nil {
-			Errorln(err)
+			r.AddError(err)
 			continue
 		}
 
-		Spinner.Prefix = ""
-		// Remove Virtual Nics
-		removeVirtualNics(ctx, hns, mns.NetworkInfo)
-
-		// Remove PortGroups
-		removePortGroups(ctx, hns, mns.NetworkInfo)
-
-		// Remove Virtual Switches
-		removeVirtualSwitches(ctx, hns, mns.NetworkInfo)
+		hp := hxHostPlan{
+			name:       hostName,
+			host:       hsMap[host.Reference()],
+			hns:        hns,
+			vnics:      hxVirtualNics(mns.NetworkInfo),
+			portGroups: hxPortGroups(mns.NetworkInfo),
+			vswitches:  hxVirtualSwitches(mns.NetworkInfo),
+		}
 
-		// Remove springpath datastore from host
 		if host.Datastore != nil {
 			var datastores []mo.Datastore
 			err = pc.Retrieve(ctx, host.Datastore, []string{"name"}, &datastores)
 			if err != nil {
-				Errorln("Failed to find datastores for host '" + hostName + "' : " + err.Error())
+				r.AddError(errors.New("Failed to find datastores for host '" + hostName + "' : " + err.Error()))
 			} else {
 				for _, ds := range datastores {
 					if strings.HasPrefix(ds.Name, "SpringpathDS") {
-						hostObj := hsMap[host.Reference()]
-						hds, err := hostObj.ConfigManager().DatastoreSystem(ctx)
-						if err != nil {
-							Errorln("Failed to find datastore system: ", err.Error())
-						} else {
-							err = hds.Remove(ctx, dsMap[ds.Reference()])
-							if err != nil {
-								Errorln("Failed to delete datastore '" + ds.Name + "' :" + err.Error())
-							}
-						}
+						hp.datastores = append(hp.datastores, ds)
 						break
 					}
 				}
 			}
 		}
+		plan.hosts = append(plan.hosts, hp)
 	}
-
-	// Don't remove datacenter, multiple clusters can come under
-	// a datacenter
-	//err = removeDatacenter(cli, hxCluster)
-
-	// Remove cluster
-	task, err := hxCluster.Destroy(ctx)
-	if err != nil {
-		Errorln("Failed to destroy cluster '" + hxCluster.Name() + "' : " + err.Error())
-	} else {
-		_, err := task.WaitForResult(ctx, nil)
-		if err != nil {
-			Errorln("Failed to destroy cluster '" + hxCluster.Name() + "' : " + err.Error())
-		}
-	}
-	return nil, err
+	return plan, nil
 }
 
 func removeDatacenter(cli *Vcli, cr *object.ClusterComputeResource) error {
@@ -623,11 +769,12 @@ func removeDatacenter(cli *Vcli, cr *object.ClusterComputeResource) error {
 
 	dcObj := *object.NewDatacenter(c, mdc.Reference())
 	task, err := dcObj.Destroy(ctx)
-	_, err = task.WaitForResult(ctx, nil)
-	return err
+	return waitForTask(ctx, task, err, mdc.Name)
 }
 
-func removeControllerVms(cli *Vcli, host *mo.HostSystem) {
+// findControllerVms returns the storage controller VMs attached to the
+// management network of the host
+func findControllerVms(cli *Vcli, host *mo.HostSystem, r *Result) []mo.VirtualMachine {
 	ctx := cli.ctx
 	c := cli.client.Client
 	pc := property.DefaultCollector(c)
@@ -636,28 +783,34 @@ func removeControllerVms(cli *Vcli, host *mo.HostSystem) {
 
 	err := pc.Retrieve(ctx, host.Network, []string{"name", "vm"}, &networks)
 	if err != nil {
-		Errorln("Failed to find networks: " + err.Error())
-	} else {
-		for _, nw := range networks {
-			if nw.Name == "Storage Controller Management Network" {
-				var vms []mo.VirtualMachine
-				err := pc.Retrieve(ctx, nw.Vm, []string{"name"}, &vms)
-				if err != nil {
-					continue
-				}
-				for _, vm := range vms {
-					if strings.HasPrefix(vm.Name, "stCtlVM") {
-						ctrlVms = append(ctrlVms, vm)
-					}
-				}
+		r.AddError(errors.New("Failed to find networks: " + err.Error()))
+		return nil
+	}
 
-				if len(ctrlVms) > 0 {
-					break
+	for _, nw := range networks {
+		if nw.Name == SC_MGMT_NETWORK {
+			var vms []mo.VirtualMachine
+			err := pc.Retrieve(ctx, nw.Vm, []string{"name", "runtime.host"}, &vms)
+			if err != nil {
+				continue
+			}
+			for _, vm := range vms {
+				if strings.HasPrefix(vm.Name, "stCtlVM") {
+					ctrlVms = append(ctrlVms, vm)
 				}
 			}
+
+			if len(ctrlVms) > 0 {
+				break
+			}
 		}
 	}
+	return ctrlVms
+}
 
+func removeControllerVms(cli *Vcli, ctrlVms []mo.VirtualMachine, r *Result) {
+	ctx := cli.ctx
+	c := cli.client.Client
 	var wg sync.WaitGroup
 
 	for _, vm := range ctrlVms {
@@ -666,13 +819,14 @@ func removeControllerVms(cli *Vcli, host *mo.HostSystem) {
 			var machine mo.VirtualMachine = vm.(mo.VirtualMachine)
 			defer wg.Done()
 			vmRef := object.NewVirtualMachine(c, machine.Reference())
+			vmRef.InventoryPath = machine.Name
 			err := doVmAction(vmRef, VM_POWEROFF, ctx)
 			if err != nil {
-				Errorln("Failed to poweroff vm '" + machine.Name + "' : " + err.Error())
+				r.AddError(errors.New("Failed to poweroff vm '" + machine.Name + "' : " + err.Error()))
 			} else {
 				err := vmRef.Unregister(ctx)
 				if err != nil {
-					Errorln("Failed to unregister vm '" + machine.Name + "' : " + err.Error())
+					r.AddError(errors.New("Failed to unregister vm '" + machine.Name + "' : " + err.Error()))
 				}
 			}
 		}(vm)
@@ -680,53 +834,77 @@ func removeControllerVms(cli *Vcli, host *mo.HostSystem) {
 	wg.Wait()
 }
 
-func removeVirtualNics(ctx context.Context, hns *object.HostNetworkSystem, ni *types.HostNetworkInfo) {
-	if ctx == nil || hns == nil || ni == nil {
-		return
+func hxVirtualNics(ni *types.HostNetworkInfo) []string {
+	var nics []string
+	if ni == nil {
+		return nics
 	}
 	for _, nic := range ni.Vnic {
 		if nic.Portgroup == "Storage Hypervisor Data Network" {
-			err := hns.RemoveVirtualNic(ctx, nic.Device)
-			if err != nil {
-				Errorln("Failed to remove: '" + nic.Device + "' vNic: " + err.Error())
-			}
+			nics = append(nics, nic.Device)
 		}
 	}
-
+	return nics
 }
 
-func removePortGroups(ctx context.Context, hns *object.HostNetworkSystem, ni *types.HostNetworkInfo) {
-	if ctx == nil || hns == nil || ni == nil {
-		return
+func hxPortGroups(ni *types.HostNetworkInfo) []string {
+	var pgs []string
+	if ni == nil {
+		return pgs
 	}
 	for _, pg := range ni.Portgroup {
 		if pg.Spec.Name == "Storage Controller Data Network" ||
 			pg.Spec.Name == "Storage Controller Replication Network" ||
-			pg.Spec.Name == "Storage Controller Management Network" ||
+			pg.Spec.Name == SC_MGMT_NETWORK ||
 			pg.Spec.Name == "Storage Hypervisor Data Network" {
-			err := hns.RemovePortGroup(ctx, pg.Spec.Name)
-			if err != nil {
-				Errorln("Failed to remove: '" + pg.Spec.Name + "' portgroup: " + err.Error())
-			}
+			pgs = append(pgs, pg.Spec.Name)
 		}
 	}
+	return pgs
 }
 
-func removeVirtualSwitches(ctx context.Context, hns *object.HostNetworkSystem, ni *types.HostNetworkInfo) {
-	if ctx == nil || hns == nil || ni == nil {
-		return
+func hxVirtualSwitches(ni *types.HostNetworkInfo) []string {
+	var switches []string
+	if ni == nil {
+		return switches
 	}
 	for _, s := range ni.Vswitch {
 		if s.Name == "vmotion" || s.Name == "vswitch-hx-vm-network" || s.Name == "vswitch-hx-storage-data" {
-			err := hns.RemoveVirtualSwitch(ctx, s.Name)
-			if err != nil {
-				Errorln("Failed to remove: '" + s.Name + "' vswitch: " + err.Error())
-			}
+			switches = append(switches, s.Name)
+		}
+	}
+	return switches
+}
+
+func removeVirtualNics(ctx context.Context, hns *object.HostNetworkSystem, nics []string, r *Result) {
+	for _, nic := range nics {
+		err := hns.RemoveVirtualNic(ctx, nic)
+		if err != nil {
+			r.AddError(errors.New("Failed to remove: '" + nic + "' vNic: " + err.Error()))
+		}
+	}
+}
+
+func removePortGroups(ctx context.Context, hns *object.HostNetworkSystem, pgs []string, r *Result) {
+	for _, pg := range pgs {
+		err := hns.RemovePortGroup(ctx, pg)
+		if err != nil {
+			r.AddError(errors.New("Failed to remove: '" + pg + "' portgroup: " + err.Error()))
+		}
+	}
+}
+
+func removeVirtualSwitches(ctx context.Context, hns *object.HostNetworkSystem, switches []string, r *Result) {
+	for _, s := range switches {
+		err := hns.RemoveVirtualSwitch(ctx, s)
+		if err != nil {
+			r.AddError(errors.New("Failed to remove: '" + s + "' vswitch: " + err.Error()))
 		}
 	}
 }
 
 type HxRequest struct {
+	ctx    context.Context
 	host   string
 	auth   *AuthResponse
 	client *http.Client
@@ -780,7 +958,7 @@ func (r *HxRequest) Post(api string, reqBody *bytes.Buffer) ([]byte, error) {
 func (r *HxRequest) request(method string, api string, reqBody *bytes.Buffer) ([]byte, error) {
 	protocol := "https://"
 	url := protocol + r.host + api
-	req, err := http.NewRequest(method, url, reqBody)
+	req, err := http.NewRequestWithContext(r.ctx, method, url, reqBody)
 	if err != nil {
 		return nil, err
 	}
@@ -793,6 +971,7 @@ func (r *HxRequest) request(method string, api string, reqBody *bytes.Buffer) ([
 		req.Header.Set("Authorization", r.auth.TokenType+" "+r.auth.AccessToken)
 	}
 
+	countHxRequest(r.ctx)
 	resp, err := r.client.Do(req)
 	if err != nil {
 		return nil, err
@@ -807,10 +986,10 @@ func (r *HxRequest) request(method string, api string, reqBody *bytes.Buffer) ([
 	return respBody, nil
 }
 
-func getHxSummary(auth *Credentials, host string) (*ClusterSummary, error) {
+func getHxSummary(ctx context.Context, auth *Credentials, host string) (*ClusterSummary, error) {
 	timeout := time.Duration(CLIENT_TIMEOUT * time.Second)
 	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig: Trust.TLSConfig(host),
 	}
 
 	client := &http.Client{
@@ -819,6 +998,7 @@ func getHxSummary(auth *Credentials, host string) (*ClusterSummary, error) {
 	}
 
 	hxReq := &HxRequest{
+		ctx:    ctx,
 		host:   host,
 		client: client,
 	}
@@ -864,14 +1044,14 @@ func getHxSummary(auth *Credentials, host string) (*ClusterSummary, error) {
 	summary := ClusterSummary{}
 	clusterAbout := ClusterAbout{}
 	if err = json.Unmarshal(aboutResponse, &clusterAbout); err != nil {
-		Errorln(err.Error())
+		summary.parseErrors = append(summary.parseErrors, err)
 	} else {
 		summary.Overview.About = clusterAbout
 	}
 
 	clusterDetail := ClusterDetail{}
 	if err = json.Unmarshal(detailResponse, &clusterDetail); err != nil {
-		Errorln(err.Error())
+		summary.parseErrors = append(summary.parseErrors, err)
 	} else {
 		summary.Overview.Config.Name = clusterDetail.Name
 		summary.Overview.Detail = clusterDetail
@@ -880,7 +1060,7 @@ func getHxSummary(auth *Credentials, host string) (*ClusterSummary, error) {
 	clusterNetwork := ClusterNetwork{}
 	//fmt.Println(string(networkResponse))
 	if err := json.Unmarshal(networkResponse, &clusterNetwork); err != nil {
-		Errorln(err.Error())
+		summary.parseErrors = append(summary.parseErrors, err)
 	} else {
 		if clusterNetwork.ClusterMgmtIpAddress.Fqdn != "" {
 			summary.Overview.Config.MgmtIp.Addr = clusterNetwork.ClusterMgmtIpAddress.Fqdn
@@ -891,21 +1071,21 @@ func getHxSummary(auth *Credentials, host string) (*ClusterSummary, error) {
 
 	clusterHealth := ClusterHealth{}
 	if err = json.Unmarshal(healthResponse, &clusterHealth); err != nil {
-		Errorln(err.Error())
+		summary.parseErrors = append(summary.parseErrors, err)
 	} else {
 		summary.Overview.Health = clusterHealth
 	}
 
 	clusterStats := ClusterStats{}
 	if err = json.Unmarshal(statsResponse, &clusterStats); err != nil {
-		Errorln(err.Error())
+		summary.parseErrors = append(summary.parseErrors, err)
 	} else {
 		summary.Overview.Stats = clusterStats
 	}
 
 	clusterTime := ClusterTime{}
 	if err = json.Unmarshal(timeResponse, &clusterTime); err != nil {
-		Errorln(err.Error())
+		summary.parseErrors = append(summary.parseErrors, err)
 	} else {
 		summary.Overview.Time = clusterTime
 	}
@@ -0,0 +1,594 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// kinds of objects kept in the inventory cache
+const (
+	INVENTORY_VM             = "VirtualMachine"
+	INVENTORY_CLUSTER        = "ClusterComputeResource"
+	INVENTORY_COMPUTE        = "ComputeResource"
+	INVENTORY_HOST           = "HostSystem"
+	INVENTORY_DATACENTER     = "Datacenter"
+	INVENTORY_FOLDER         = "Folder"
+	INVENTORY_DATASTORE      = "Datastore"
+	INVENTORY_EXTENSION      = "Extension"
+	INVENTORY_EXTENSION_LIST = "ExtensionManager"
+)
+
+const (
+	// longest time a WaitForUpdatesEx call waits for changes on vCenter
+	INVENTORY_WAIT = 60
+	// interval of CancelWaitForUpdates calls until a sync is served
+	INVENTORY_SYNC_RETRY = 250 * time.Millisecond
+	// a watch that failed is started again by a lookup after this time
+	INVENTORY_RETRY = 30 * time.Second
+)
+
+// properties of every kind kept in the cache
+var inventoryProps = map[string][]string{
+	INVENTORY_VM: {"name", "parent", "runtime.powerState", "runtime.host", "summary.runtime.bootTime", "guest.ipAddress", "datastore",
+		"summary.config.guestFullName", "summary.config.numCpu", "summary.config.memorySizeMB"},
+	INVENTORY_CLUSTER:        {"name", "parent", "summary.numHosts", "summary.totalCpu", "summary.numCpuCores", "summary.totalMemory"},
+	INVENTORY_COMPUTE:        {"name", "parent"},
+	INVENTORY_HOST:           {"name", "parent"},
+	INVENTORY_DATACENTER:     {"name", "parent"},
+	INVENTORY_FOLDER:         {"name", "parent"},
+	INVENTORY_DATASTORE:      {"name", "parent"},
+	INVENTORY_EXTENSION_LIST: {"extensionList"},
+}
+
+// InventoryObject is a cached inventory object. Its properties are
+// replaced, never changed, by updates so it can be read without locking.
+type InventoryObject struct {
+	Ref   types.ManagedObjectReference
+	Name  string
+	Path  string
+	props map[string]types.AnyType
+}
+
+// String returns a string or enum property, "" if it is not set
+func (o *InventoryObject) String(prop string) string {
+	switch v := o.props[prop].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Int returns an integer property, 0 if it is not set
+func (o *InventoryObject) Int(prop string) int64 {
+	switch v := o.props[prop].(type) {
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	}
+	return 0
+}
+
+// Time returns a date property, the zero time if it is not set
+func (o *InventoryObject) Time(prop string) time.Time {
+	switch v := o.props[prop].(type) {
+	case time.Time:
+		return v
+	case *time.Time:
+		if v != nil {
+			return *v
+		}
+	}
+	return time.Time{}
+}
+
+// Reference returns a managed object reference property, nil if it is not set
+func (o *InventoryObject) Reference(prop string) *types.ManagedObjectReference {
+	if ref, ok := o.props[prop].(types.ManagedObjectReference); ok {
+		return &ref
+	}
+	return nil
+}
+
+// References returns a list of managed object references property
+func (o *InventoryObject) References(prop string) []types.ManagedObjectReference {
+	if refs, ok := o.props[prop].(types.ArrayOfManagedObjectReference); ok {
+		return refs.ManagedObjectReference
+	}
+	return nil
+}
+
+// Inventory caches the inventory of a connection for the session. A watch
+// retrieves all VMs, clusters, hosts, datacenters, folders, datastores and
+// extensions with a single WaitForUpdatesEx call and then applies the
+// changes vCenter reports, so lookups don't have to ask vCenter again.
+type Inventory struct {
+	objects map[types.ManagedObjectReference]*InventoryObject
+	watch   *inventoryWatch
+	// changed is set after commands that may have changed the inventory
+	changed bool
+	mu      sync.Mutex
+}
+
+// inventorySync is a request to collect the pending changes
+type inventorySync struct {
+	done   chan struct{}
+	timing *Timing
+}
+
+// inventoryWatch is one run of the WaitForUpdatesEx loop
+type inventoryWatch struct {
+	cancel context.CancelFunc
+	// loaded is closed once the first complete update set is in, or the
+	// watch failed before
+	loaded  chan struct{}
+	objects map[types.ManagedObjectReference]*InventoryObject
+	started time.Time
+	// time it took to load the first update set
+	loadTime time.Duration
+	updated  time.Time
+	version  string
+	updates  int
+	done     bool
+	err      error
+	// loadErr is the error of a watch that failed before it loaded
+	loadErr error
+	// collector waiting for updates, nil until it is created
+	collector *property.Collector
+	// syncs are served once the changes pending at their request are in
+	syncs []*inventorySync
+	// timing counts the calls that load the inventory for a command
+	// run with -timing
+	timing *Timing
+	// waiting is set while a WaitForUpdatesEx call waits for changes
+	waiting bool
+}
+
+func NewInventory() *Inventory {
+	return &Inventory{objects: make(map[types.ManagedObjectReference]*InventoryObject)}
+}
+
+// Start starts watching the inventory in the background unless it is
+// already watched
+func (inv *Inventory) Start(cli *Vcli) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if inv.watch == nil || inv.watch.done {
+		inv.start(cli, nil)
+	}
+}
+
+// Stop stops watching the inventory, e.g. at logout
+func (inv *Inventory) Stop() {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if inv.watch != nil {
+		inv.watch.cancel()
+	}
+}
+
+// Refresh loads the inventory again from scratch and waits until it is in
+func (inv *Inventory) Refresh(ctx context.Context, cli *Vcli) error {
+	inv.mu.Lock()
+	if inv.watch != nil {
+		inv.watch.cancel()
+	}
+	w := inv.start(cli, timingFrom(ctx))
+	inv.mu.Unlock()
+	return w.wait(ctx)
+}
+
+// Invalidate makes the next lookup wait for the changes vCenter has not
+// reported yet, e.g. after VMs were powered off or destroyed
+func (inv *Inventory) Invalidate() {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.changed = true
+}
+
+// Objects returns the cached objects of a kind sorted by name, it waits
+// for the inventory to be loaded
+func (inv *Inventory) Objects(ctx context.Context, cli *Vcli, kind string) ([]*InventoryObject, error) {
+	inv.mu.Lock()
+	w := inv.watch
+	if w == nil || w.done {
+		w = inv.start(cli, timingFrom(ctx))
+		inv.changed = false
+	}
+	changed := inv.changed
+	inv.changed = false
+	inv.mu.Unlock()
+	if err := w.wait(ctx); err != nil {
+		return nil, err
+	}
+	if changed {
+		if err := inv.sync(ctx, w); err != nil {
+			return nil, err
+		}
+	}
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	var objects []*InventoryObject
+	for ref, o := range inv.objects {
+		if ref.Type == kind {
+			c := *o
+			c.Path = inv.path(ref)
+			objects = append(objects, &c)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		if objects[i].Name != objects[j].Name {
+			return objects[i].Name < objects[j].Name
+		}
+		return objects[i].Ref.Value < objects[j].Ref.Value
+	})
+	return objects, nil
+}
+
+// Name returns the cached name of an object, "" if it is unknown
+func (inv *Inventory) Name(ref *types.ManagedObjectReference) string {
+	if ref == nil {
+		return ""
+	}
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if o, ok := inv.objects[*ref]; ok {
+		return o.Name
+	}
+	return ""
+}
+
+// Path returns the cached inventory path of an object, "" if it is unknown
+func (inv *Inventory) Path(ref types.ManagedObjectReference) string {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return inv.path(ref)
+}
+
+// Names returns the cached names of a kind, sorted, for tab completion.
+// It never waits for vCenter: what is cached is returned and a watch that
+// is not running is started in the background.
+func (inv *Inventory) Names(cli *Vcli, kind string) []string {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if inv.watch == nil || (inv.watch.done && time.Since(inv.watch.updated) > INVENTORY_RETRY) {
+		inv.start(cli, nil)
+	}
+
+	var names []string
+	for ref, o := range inv.objects {
+		switch {
+		case kind == INVENTORY_EXTENSION && ref.Type == INVENTORY_EXTENSION_LIST:
+			if list, ok := o.props["extensionList"].(types.ArrayOfExtension); ok {
+				for _, e := range list.Extension {
+					names = append(names, e.Key)
+				}
+			}
+		case ref.Type == kind:
+			names = append(names, o.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// path returns the inventory path of an object, e.g. /DC0/vm/Team/web-01
+func (inv *Inventory) path(ref types.ManagedObjectReference) string {
+	var names []string
+	for i := 0; i < len(inv.objects); i++ {
+		o, ok := inv.objects[ref]
+		if !ok {
+			break
+		}
+		names = append(names, o.Name)
+		parent := o.Reference("parent")
+		if parent == nil {
+			break
+		}
+		ref = *parent
+	}
+
+	var b strings.Builder
+	for i := len(names) - 1; i >= 0; i-- {
+		b.WriteString("/" + names[i])
+	}
+	return b.String()
+}
+
+func (inv *Inventory) start(cli *Vcli, timing *Timing) *inventoryWatch {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &inventoryWatch{
+		cancel:  cancel,
+		loaded:  make(chan struct{}),
+		objects: make(map[types.ManagedObjectReference]*InventoryObject),
+		started: time.Now(),
+		timing:  timing,
+	}
+	inv.watch = w
+
+	go func() {
+		err := inv.run(ctx, cli, w)
+
+		inv.mu.Lock()
+		defer inv.mu.Unlock()
+		w.done = true
+		w.err = err
+		if ctx.Err() != nil {
+			w.err = errors.New("Inventory watch stopped")
+		}
+		w.updated = time.Now()
+		if w.objects != nil {
+			w.loadErr = w.err
+			close(w.loaded)
+		}
+		for _, s := range w.syncs {
+			close(s.done)
+		}
+		w.syncs = nil
+		cancel()
+	}()
+	return w
+}
+
+// run loads the inventory and applies the changes until the watch is
+// stopped or fails
+func (inv *Inventory) run(ctx context.Context, cli *Vcli, w *inventoryWatch) error {
+	c := cli.client.Client
+	// the calls that load the inventory count for the command waiting for it
+	loadCtx := withTiming(ctx, w.timing)
+	m := view.NewManager(c)
+	kinds := []string{INVENTORY_VM, INVENTORY_CLUSTER, INVENTORY_COMPUTE, INVENTORY_HOST, INVENTORY_DATACENTER, INVENTORY_FOLDER, INVENTORY_DATASTORE}
+	v, err := m.CreateContainerView(loadCtx, c.ServiceContent.RootFolder, kinds, true)
+	if err != nil {
+		return err
+	}
+	// the watch context is done when the watch ends
+	defer v.Destroy(context.Background())
+
+	pc, err := property.DefaultCollector(c).Create(loadCtx)
+	if err != nil {
+		return err
+	}
+	defer pc.Destroy(context.Background())
+
+	filter := types.CreateFilter{
+		This: pc.Reference(),
+		Spec: types.PropertyFilterSpec{
+			ObjectSet: []types.ObjectSpec{{
+				Obj:       v.Reference(),
+				Skip:      types.NewBool(true),
+				SelectSet: []types.BaseSelectionSpec{&types.TraversalSpec{Type: "ContainerView", Path: "view"}},
+			}},
+		},
+	}
+	if em := c.ServiceContent.ExtensionManager; em != nil {
+		filter.Spec.ObjectSet = append(filter.Spec.ObjectSet, types.ObjectSpec{Obj: *em})
+	}
+	for kind, props := range inventoryProps {
+		filter.Spec.PropSet = append(filter.Spec.PropSet, types.PropertySpec{Type: kind, PathSet: props})
+	}
+	if err = pc.CreateFilter(loadCtx, filter); err != nil {
+		return err
+	}
+	inv.mu.Lock()
+	w.collector = pc
+	inv.mu.Unlock()
+
+	req := types.WaitForUpdatesEx{
+		This:    pc.Reference(),
+		Options: &types.WaitOptions{},
+	}
+	for {
+		// a sync only collects the pending changes, without waiting
+		inv.mu.Lock()
+		syncs := w.syncs
+		w.syncs = nil
+		w.waiting = len(syncs) == 0
+		wait := int32(INVENTORY_WAIT)
+		callCtx := ctx
+		if w.objects != nil {
+			callCtx = loadCtx
+		}
+		if !w.waiting {
+			wait = 0
+			callCtx = withTiming(ctx, syncs[0].timing)
+		}
+		inv.mu.Unlock()
+		req.Options.MaxWaitSeconds = &wait
+
+		res, err := methods.WaitForUpdatesEx(callCtx, c, &req)
+		inv.mu.Lock()
+		w.waiting = false
+		inv.mu.Unlock()
+		if err != nil {
+			if !isRequestCanceled(err) || ctx.Err() != nil {
+				return err
+			}
+			// cancelled by a sync, collect the changes with the next call
+			inv.mu.Lock()
+			w.syncs = append(w.syncs, syncs...)
+			inv.mu.Unlock()
+			continue
+		}
+		inv.apply(w, res.Returnval)
+		if res.Returnval != nil {
+			req.Version = res.Returnval.Version
+		}
+		for _, s := range syncs {
+			close(s.done)
+		}
+	}
+}
+
+// sync waits until the changes made on vCenter so far are in the cache.
+// The long running WaitForUpdatesEx call of the watch is cancelled, so
+// the watch collects the pending changes right away.
+func (inv *Inventory) sync(ctx context.Context, w *inventoryWatch) error {
+	s := &inventorySync{done: make(chan struct{}), timing: timingFrom(ctx)}
+	inv.mu.Lock()
+	if w.done {
+		inv.mu.Unlock()
+		return nil
+	}
+	w.syncs = append(w.syncs, s)
+	pc := w.collector
+	inv.mu.Unlock()
+
+	ticker := time.NewTicker(INVENTORY_SYNC_RETRY)
+	defer ticker.Stop()
+	for {
+		// the cancel may reach vCenter before the wait does, so it is
+		// repeated while the watch is still waiting
+		inv.mu.Lock()
+		waiting := w.waiting
+		inv.mu.Unlock()
+		if waiting && pc != nil {
+			_ = pc.CancelWaitForUpdates(ctx)
+		}
+		select {
+		case <-s.done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// isRequestCanceled reports whether a call failed because of
+// CancelWaitForUpdates
+func isRequestCanceled(err error) bool {
+	if !soap.IsSoapFault(err) {
+		return false
+	}
+	switch soap.ToSoapFault(err).VimFault().(type) {
+	case types.RequestCanceled, *types.RequestCanceled:
+		return true
+	}
+	return false
+}
+
+// apply applies an update set of a watch, the first complete set replaces
+// the cached objects
+func (inv *Inventory) apply(w *inventoryWatch, set *types.UpdateSet) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if inv.watch != w {
+		return
+	}
+
+	objects := inv.objects
+	if w.objects != nil {
+		objects = w.objects
+	}
+	if set != nil {
+		w.version = set.Version
+		for _, fs := range set.FilterSet {
+			for _, update := range fs.ObjectSet {
+				applyObjectUpdate(objects, update)
+				w.updates++
+			}
+		}
+	}
+	w.updated = time.Now()
+
+	// a truncated set is continued by the next call
+	if w.objects != nil && (set == nil || set.Truncated == nil || !*set.Truncated) {
+		inv.objects = w.objects
+		w.objects = nil
+		w.loadTime = time.Since(w.started)
+		close(w.loaded)
+	}
+}
+
+func applyObjectUpdate(objects map[types.ManagedObjectReference]*InventoryObject, update types.ObjectUpdate) {
+	if update.Kind == types.ObjectUpdateKindLeave {
+		delete(objects, update.Obj)
+		return
+	}
+
+	o := &InventoryObject{Ref: update.Obj, props: make(map[string]types.AnyType)}
+	if old, ok := objects[update.Obj]; ok && update.Kind == types.ObjectUpdateKindModify {
+		for name, val := range old.props {
+			o.props[name] = val
+		}
+	}
+	for _, change := range update.ChangeSet {
+		switch change.Op {
+		case types.PropertyChangeOpRemove, types.PropertyChangeOpIndirectRemove:
+			delete(o.props, change.Name)
+		default:
+			o.props[change.Name] = change.Val
+		}
+	}
+	o.Name = o.String("name")
+	objects[update.Obj] = o
+}
+
+// wait waits until the watch has loaded the inventory
+func (w *inventoryWatch) wait(ctx context.Context) error {
+	select {
+	case <-w.loaded:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return w.loadErr
+}
+
+// InventoryStatus describes the state of the inventory cache
+type InventoryStatus struct {
+	State    string
+	Objects  map[string]int
+	Version  string
+	Updates  int
+	Started  time.Time
+	Updated  time.Time
+	LoadTime time.Duration
+	Err      error
+}
+
+func (inv *Inventory) Status() *InventoryStatus {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	s := &InventoryStatus{State: "not started", Objects: make(map[string]int)}
+	for ref, o := range inv.objects {
+		if list, ok := o.props["extensionList"].(types.ArrayOfExtension); ok {
+			s.Objects[INVENTORY_EXTENSION] += len(list.Extension)
+			continue
+		}
+		s.Objects[ref.Type]++
+	}
+	w := inv.watch
+	if w == nil {
+		return s
+	}
+
+	s.Version, s.Updates, s.Started, s.Updated, s.LoadTime = w.version, w.updates, w.started, w.updated, w.loadTime
+	switch {
+	case w.done:
+		s.State = "stopped"
+		s.Err = w.err
+	case w.objects != nil:
+		s.State = "loading"
+	default:
+		s.State = "watching"
+	}
+	return s
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/go/vcli/filter"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	SORT_ASC  = "asc"
+	SORT_DESC = "desc"
+)
+
+// options of the list commands, shown in their usage
+const listOptionsHelp = `  -filter=expr      Show only the rows matching a filter expression, e.g.
+                    'state==poweredOn && mem>=8GB && name=~"^hx"'
+  -cols=a,b,c       Columns to show, # is always shown first
+  -sort=col[:desc]  Sort by a column, ascending unless :desc is given,
+                    several columns are separated by comma
+  -limit=N          Show only the first N rows`
+
+// filterHelp describes the filter expressions, shown in the usage of the
+// commands with -filter
+const filterHelp = `Filter expressions compare columns with ==, !=, <, <=, >, >=, =~ (regex) and
+!~, combined with &&, || and ! and grouped with parentheses. Sizes, frequencies
+and durations take units, e.g. mem>=8GB, uptime<1d. Text is compared ignoring
+case, * and ? are globs: name==web-* || ip=~"^10\.64\."`
+
+// listFlags adds the filter, column selection, sorting and limit options
+// to the flags of a list command
+type listFlags struct {
+	filter *string
+	cols   *string
+	sort   *string
+	limit  *int
+}
+
+type sortKey struct {
+	col  int
+	desc bool
+}
+
+func newListFlags(fs *flag.FlagSet) *listFlags {
+	return &listFlags{
+		filter: fs.String("filter", "", "Filter expression"),
+		cols:   fs.String("cols", "", "Columns to show"),
+		sort:   fs.String("sort", "", "Columns to sort by"),
+		limit:  fs.Int("limit", 0, "Maximum number of rows"),
+	}
+}
+
+// Apply filters and sorts the rows of a list table, cuts them to the
+// limit and selects the columns. defaults are the keys of the columns
+// shown without -cols, all columns if nil. The # column keeps the number
+// each row got before sorting, so it can still be given to the info
+// commands.
+func (f *listFlags) Apply(t *Table, defaults []string) (*Table, error) {
+	if *f.limit < 0 {
+		return nil, errors.New("-limit must be 0 or more")
+	}
+
+	rowFilter, err := compileFilter(t.Columns, *f.filter)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := t.sortKeys(*f.sort)
+	if err != nil {
+		return nil, err
+	}
+
+	cols := defaults
+	if *f.cols != "" {
+		cols = strings.Split(*f.cols, ",")
+	}
+	indexes, err := t.columnIndexes(cols)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := filterRows(t, rowFilter)
+	if len(keys) > 0 {
+		sort.SliceStable(rows, func(i, j int) bool {
+			for _, k := range keys {
+				c := compareValues(cellAt(rows[i], k.col), cellAt(rows[j], k.col))
+				if c != 0 {
+					return (c < 0) != k.desc
+				}
+			}
+			return false
+		})
+	}
+	if *f.limit > 0 && len(rows) > *f.limit {
+		rows = rows[:*f.limit]
+	}
+
+	tbl := NewTable()
+	tbl.Vertical = t.Vertical
+	for _, i := range indexes {
+		tbl.Columns = append(tbl.Columns, t.Columns[i])
+	}
+	for _, row := range rows {
+		values := make([]interface{}, 0, len(indexes))
+		for _, i := range indexes {
+			values = append(values, cellAt(row, i))
+		}
+		tbl.AddRow(values...)
+	}
+	return tbl, nil
+}
+
+// compileFilter parses a filter expression on the columns of a table, the
+// filter is nil if expr is empty
+func compileFilter(cols []Column, expr string) (*filter.Filter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+	fields := make([]filter.Field, 0, len(cols))
+	for _, c := range cols {
+		if c.Header != "#" {
+			fields = append(fields, filter.Field{Name: c.key(), Kind: c.Kind})
+		}
+	}
+	return filter.Parse(expr, fields)
+}
+
+// filterRows returns a copy of the rows of a table matched by a filter,
+// all rows if the filter is nil
+func filterRows(t *Table, f *filter.Filter) [][]interface{} {
+	rows := make([][]interface{}, 0, len(t.Rows))
+	for _, row := range t.Rows {
+		if f == nil || f.Match(func(key string) interface{} {
+			i, _ := t.column(key)
+			return rawValue(cellAt(row, i))
+		}) {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// columnIndexes returns the indexes of the columns with the given keys,
+// behind the # column, or of all columns if keys is nil
+func (t *Table) columnIndexes(keys []string) ([]int, error) {
+	var indexes []int
+	for i, c := range t.Columns {
+		if keys == nil || c.Header == "#" {
+			indexes = append(indexes, i)
+		}
+	}
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if key == "" || key == "#" {
+			continue
+		}
+		i, err := t.column(key)
+		if err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, i)
+	}
+	return indexes, nil
+}
+
+// sortKeys parses a comma separated list of col[:asc|desc]
+func (t *Table) sortKeys(spec string) ([]sortKey, error) {
+	var keys []sortKey
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		parts := strings.SplitN(s, ":", 2)
+		i, err := t.column(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		k := sortKey{col: i}
+		if len(parts) == 2 {
+			switch strings.ToLower(parts[1]) {
+			case SORT_ASC:
+			case SORT_DESC:
+				k.desc = true
+			default:
+				return nil, fmt.Errorf("Invalid sort order '%s', expected %s or %s", parts[1], SORT_ASC, SORT_DESC)
+			}
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// column returns the index of the column with a key
+func (t *Table) column(key string) (int, error) {
+	key = strings.ToLower(strings.TrimSpace(key))
+	var keys []string
+	for i, c := range t.Columns {
+		if c.key() == key || (key == "#" && c.Header == "#") {
+			return i, nil
+		}
+		if c.Header != "#" {
+			keys = append(keys, c.key())
+		}
+	}
+	return -1, fmt.Errorf("Unknown column '%s', expected one of %s", key, strings.Join(keys, ", "))
+}
+
+func cellAt(row []interface{}, i int) interface{} {
+	if i < len(row) {
+		return row[i]
+	}
+	return nil
+}
+
+// compareValues orders two cells by their raw values: numbers and times by
+// value, anything else by text ignoring case. Empty cells come first.
+func compareValues(a, b interface{}) int {
+	a, b = rawValue(a), rawValue(b)
+	switch {
+	case isEmpty(a) && isEmpty(b):
+		return 0
+	case isEmpty(a):
+		return -1
+	case isEmpty(b):
+		return 1
+	}
+
+	if x, ok := filter.ToFloat(a); ok {
+		if y, ok := filter.ToFloat(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+
+	// false comes before true
+	if x, ok := a.(bool); ok {
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0
+			case y:
+				return -1
+			}
+			return 1
+		}
+	}
+
+	if x, ok := a.(time.Time); ok {
+		if y, ok := b.(time.Time); ok {
+			switch {
+			case x.Before(y):
+				return -1
+			case x.After(y):
+				return 1
+			}
+			return 0
+		}
+	}
+
+	x, y := textValue(a), textValue(b)
+	if c := strings.Compare(strings.ToLower(x), strings.ToLower(y)); c != 0 {
+		return c
+	}
+	return strings.Compare(x, y)
+}
+
+func isEmpty(v interface{}) bool {
+	switch vv := v.(type) {
+	case nil:
+		return true
+	case string:
+		return vv == ""
+	case time.Time:
+		return vv.IsZero()
+	}
+	return false
+}
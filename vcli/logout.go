@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+)
+
+type LogoutCommand struct{}
+
+func (cmd *LogoutCommand) Usage() string {
+	return `Usage: logout
+
+End the vCenter session of the active connection, or of the connections
+given with -on, and remove the session saved for the next vcli run. The
+next command logs in again.
+
+Examples:
+  logout
+  logout -on all
+`
+}
+
+func (cmd *LogoutCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	if len(args) > 0 {
+		return UsageResult(cmd.Usage()), nil
+	}
+	if err := cli.EndSession(); err != nil {
+		return nil, err
+	}
+	return MessageResult("Logged out of %s", cli.host), nil
+}
+
+// EndSession logs out of vCenter even if the session is saved, and
+// removes the saved session
+func (v *Vcli) EndSession() error {
+	v.inventory.Stop()
+	if v.sessionFile != "" {
+		if err := os.Remove(v.sessionFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return v.client.Logout(v.ctx)
+}
@@ -4,85 +4,176 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"golang.org/x/crypto/ssh/terminal"
+	"io/ioutil"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
-	"syscall"
-	"time"
 
 	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/session/keepalive"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/soap"
 )
 
 type Credentials struct {
 	username string
 	password string
+	// profile the password can be read from, "" for the default profile
+	profile string
+	// mu serializes looking up a password that is not known yet
+	mu sync.Mutex
+}
+
+type Args struct {
+	url        string
+	username   string
+	password   string
+	insecure   bool
+	caFile     string
+	thumbprint string
+	pins       pinList
+	profile    string
+	command    string
+	script     string
+	keepGoing  bool
 }
 
 type Vcli struct {
+	// ctx is the context of the running command, see commandContext
 	ctx    context.Context
 	client *govmomi.Client
 	auth   *Credentials
+	// name of the connection and the host it was opened with
+	name string
+	host string
+	// sessionFile keeps the session for reuse by the next vcli run
+	sessionFile string
+	// inventory caches the inventory objects for the session
+	inventory *Inventory
 }
 
 type Exit int
 
 const (
-	VCLI_VERSION        = "1.0.0"
-	IDLE_ACTION_TIMEOUT = 5 * time.Second
-	SessionCookieName   = "vmware_soap_session"
-)
-
-var (
-	once     sync.Once
-	instance *Vcli
+	VCLI_VERSION      = "1.0.0"
+	SessionCookieName = "vmware_soap_session"
 )
 
-var IdleActionTimer *time.Timer
 var protocolMatch = regexp.MustCompile(`^\w+://`)
 
 // show vcli usage
 func printUsage() {
 	prog := filepath.Base(os.Args[0])
-	fmt.Println("Usage: \t", prog, "-h <ESXi or vCenter host> -u <Username> -p <Password>")
-	os.Exit(1)
+	fmt.Println("Usage: \t", prog, "-h <ESXi or vCenter host> -u <Username> -p <Password> [-k | -ca-file <file> | -thumbprint <thumbprint>] [-pin <host=thumbprint>] [-c <command> | -f <script> [-keep-going]]")
+	fmt.Println("       \t", prog, "-profile <name> [-no-session] [-c <command> | -f <script> [-keep-going]]")
+	fmt.Println()
+	fmt.Println("Profiles are read from", configPath()+",", "the host and credentials can also be set with")
+	fmt.Println(ENV_HOST + ", " + ENV_USER + " and " + ENV_PASSWORD)
+	os.Exit(EXIT_USAGE)
 }
 
-func New(url string, username string, passwd string, insecure bool) (*Vcli, error) {
-	if instance == nil {
-		u, err := getURL(url, username, passwd)
-		if err != nil {
+// New opens a connection to an ESXi or vCenter host, the connection is
+// known by name for 'use' and -on
+func New(name string, url string, username string, passwd string, profile string) (*Vcli, error) {
+	u, err := getURL(url, username, passwd)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	var path string
+	if SaveSessions {
+		path = sessionPath(profile, u)
+	}
+	c, err := newClient(ctx, u, path)
+	if err != nil {
+		return nil, err
+	}
+
+	// the password may have been asked for by newClient
+	passwd, _ = u.User.Password()
+	return &Vcli{
+		ctx:         ctx,
+		client:      c,
+		auth:        &Credentials{username: username, password: passwd, profile: profile},
+		name:        name,
+		host:        u.Host,
+		sessionFile: path,
+		inventory:   NewInventory(),
+	}, nil
+}
+
+// newClient connects like govmomi.NewClient, verifying the server
+// certificate with the Trust settings. A session saved in sessionFile is
+// reused if it is still valid, otherwise it logs in and saves the new one.
+// The password is only asked for if it is needed to log in.
+func newClient(ctx context.Context, u *url.URL, sessionFile string) (*govmomi.Client, error) {
+	// certificates are verified by the TLS config, see TrustSettings
+	sc := soap.NewClient(u, true)
+	sc.DefaultTransport().TLSClientConfig = Trust.TLSConfig(u.Host)
+
+	vc, err := vim25.NewClient(ctx, sc)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the session from expiring while the prompt is idle, the
+	// keepalive starts with Login
+	ka := keepalive.NewHandlerSOAP(vc.RoundTripper, KEEPALIVE_INTERVAL, nil)
+	vc.RoundTripper = &timingRoundTripper{ka}
+
+	c := &govmomi.Client{
+		Client:         vc,
+		SessionManager: session.NewManager(vc),
+	}
+
+	if u.User == nil {
+		return c, nil
+	}
+
+	if sessionFile != "" && restoreSession(ctx, c, u, sessionFile) {
+		ka.Start()
+		return c, nil
+	}
+
+	if password, _ := u.User.Password(); password == "" {
+		username := u.User.Username()
+		if password, err = readPassword(fmt.Sprintf("Password for %s@%s: ", username, u.Host)); err != nil {
 			return nil, err
 		}
-		ctx := context.Background()
-		c, err := govmomi.NewClient(ctx, u, insecure)
-		if err != nil {
-			return nil, err
+		u.User = url.UserPassword(username, password)
+	}
+
+	if err = c.Login(ctx, u.User); err != nil {
+		return nil, err
+	}
+
+	if sessionFile != "" {
+		if err := saveSession(c, u, sessionFile); err != nil {
+			Warnln("Failed to save session: " + err.Error())
 		}
+	}
+	return c, nil
+}
 
-		/*
-			for _, cookie := range c.Client.Client.Client.Jar.Cookies(u) {
-				if cookie.Name == SessionCookieName {
-					fmt.Println("soap session cookie: ", cookie.Value)
-				}
-			}
-		*/
-		once.Do(func() {
-			instance = &Vcli{
-				ctx:    ctx,
-				client: c,
-			}
-		})
+// Logout ends the vCenter session, unless it is saved to be reused by
+// the next vcli run
+func (v *Vcli) Logout() error {
+	v.inventory.Stop()
+	if v.sessionFile != "" {
+		return nil
 	}
-	return instance, nil
+	return v.client.Logout(v.ctx)
 }
 
+// GetVcli returns the active connection
 func GetVcli() *Vcli {
-	return instance
+	return Sessions.Active()
 }
 
 func getURL(host string, user string, password string) (*url.URL, error) {
@@ -113,19 +204,23 @@ func getURL(host string, user string, password string) (*url.URL, error) {
 	return u, nil
 }
 
-func getArgs() (string, string, string, bool) {
+func getArgs() *Args {
 	vcliArgs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ExitOnError)
 	url := vcliArgs.String("h", "", "ESXi or vCenter host")
 	username := vcliArgs.String("u", "", "Username")
 	password := vcliArgs.String("p", "", "Password")
 	version := vcliArgs.Bool("v", false, "Version")
-	// insecure := vcliArgs.Bool("k", true, "Insecure")
-	// Don't verify the server's certificate chain (default)
-	insecure := true
-
-	if len(os.Args) <= 1 {
-		printUsage()
-	}
+	command := vcliArgs.String("c", "", "Run a single command and exit")
+	script := vcliArgs.String("f", "", "Run commands from a script file ('-' for stdin) and exit")
+	keepGoing := vcliArgs.Bool("keep-going", false, "Continue running a script after a command fails")
+	output := vcliArgs.String("o", OUTPUT_TABLE, "Output format: table, json, yaml or csv")
+	insecure := vcliArgs.Bool("k", false, "Don't verify the server certificates")
+	caFile := vcliArgs.String("ca-file", "", "CA bundle(s) to verify the server certificates with")
+	thumbprint := vcliArgs.String("thumbprint", "", "SHA-1 or SHA-256 thumbprint of the vCenter certificate")
+	var pins pinList
+	vcliArgs.Var(&pins, "pin", "Thumbprint of the certificate of another server, e.g. an HX controller, as host=thumbprint (repeatable)")
+	profileName := vcliArgs.String("profile", "", "Connection profile of "+configPath())
+	noSession := vcliArgs.Bool("no-session", false, "Don't reuse or save the vCenter session")
 
 	vcliArgs.Parse(os.Args[1:])
 
@@ -133,11 +228,79 @@ func getArgs() (string, string, string, bool) {
 		fmt.Println(VCLI_VERSION)
 		os.Exit(0)
 	}
+	SaveSessions = !*noSession
+
+	// flags given explicitly win over the environment and the profile
+	setFlags := make(map[string]bool)
+	vcliArgs.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	config, err := loadConfig()
+	if err != nil {
+		Errorln(err)
+		os.Exit(EXIT_USAGE)
+	}
+
+	if *profileName == "" {
+		*profileName = os.Getenv(ENV_PROFILE)
+	}
+	profile, err := config.Profile(*profileName)
+	if err != nil {
+		Errorln(err)
+		os.Exit(EXIT_USAGE)
+	}
+	if profile == nil {
+		profile = &Profile{}
+	} else {
+		ActiveProfile = profile
+		if *profileName == "" {
+			*profileName = config.Default
+		}
+	}
+
+	*url = firstOf(*url, os.Getenv(ENV_HOST), profile.Host)
+	*username = firstOf(*username, os.Getenv(ENV_USER), profile.Username)
+	*caFile = expandHome(firstOf(*caFile, os.Getenv(ENV_CA_FILE), profile.CAFile))
+	*thumbprint = firstOf(*thumbprint, os.Getenv(ENV_THUMBPRINT), profile.Thumbprint)
+	// pins given with -pin win over the pins of the profile
+	pins = append(profile.pinList(), pins...)
+	if !setFlags["k"] {
+		if env := os.Getenv(ENV_INSECURE); env != "" {
+			*insecure, _ = strconv.ParseBool(env)
+		} else {
+			*insecure = profile.Insecure
+		}
+	}
+	if !setFlags["o"] && profile.Output != "" {
+		*output = profile.Output
+	}
 
 	if strings.Trim(*url, " ") == "" {
 		printUsage()
 	}
 
+	if err := SetOutputFormat(*output); err != nil {
+		Errorln(err)
+		os.Exit(EXIT_USAGE)
+	}
+
+	if *command != "" && *script != "" {
+		Errorln("-c and -f can't be used together")
+		os.Exit(EXIT_USAGE)
+	}
+
+	if strings.Trim(*password, " ") == "" {
+		*password = os.Getenv(ENV_PASSWORD)
+	}
+	if strings.Trim(*password, " ") == "" {
+		*password, err = profile.Password()
+		if err != nil {
+			Errorln(err)
+			os.Exit(EXIT_USAGE)
+		}
+	}
+
 	if strings.Trim(*username, " ") == "" {
 		var user string
 		fmt.Print("Enter username: ")
@@ -145,29 +308,82 @@ func getArgs() (string, string, string, bool) {
 		*username = user
 	}
 
-	if strings.Trim(*password, " ") == "" {
-		fmt.Print("Enter password: ")
-		passwd, err := terminal.ReadPassword(int(syscall.Stdin))
-		if err == nil {
-			*password = string(passwd)
+	return &Args{
+		url:        *url,
+		username:   *username,
+		password:   *password,
+		insecure:   *insecure,
+		caFile:     *caFile,
+		thumbprint: *thumbprint,
+		pins:       pins,
+		profile:    *profileName,
+		command:    *command,
+		script:     *script,
+		keepGoing:  *keepGoing,
+	}
+}
+
+// firstOf returns the first non-empty value
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
 		}
-		fmt.Println()
 	}
+	return ""
+}
 
-	return *url, *username, *password, insecure
+// connectionName names the first connection after the profile or host
+func connectionName(args *Args) string {
+	if args.profile != "" {
+		return args.profile
+	}
+	u, err := getURL(args.url, "", "")
+	if err != nil || u == nil {
+		return args.url
+	}
+	return u.Hostname()
 }
 
-func handleExit(cli *Vcli) {
+// setupTrust applies the certificate verification options. Unknown
+// certificates can only be trusted on first use in interactive mode.
+func setupTrust(args *Args) error {
+	Trust.Insecure = args.insecure
+	Trust.TrustOnFirstUse = args.command == "" && args.script == ""
+
+	if args.caFile != "" {
+		if err := Trust.LoadCAFile(args.caFile); err != nil {
+			return err
+		}
+	}
+
+	for _, pin := range args.pins {
+		if err := Trust.Pin(pin.host, pin.thumbprint); err != nil {
+			return err
+		}
+	}
+
+	if args.thumbprint != "" {
+		u, err := getURL(args.url, "", "")
+		if err != nil {
+			return err
+		}
+		return Trust.Pin(u.Host, args.thumbprint)
+	}
+	return nil
+}
+
+func handleExit() {
 	if Spinner.Active() {
 		Spinner.Stop()
 	}
 	switch v := recover().(type) {
 	case nil:
-		cli.client.Logout(cli.ctx)
+		Sessions.LogoutAll()
 		Message("Good Bye!")
 		return
 	case Exit:
-		cli.client.Logout(cli.ctx)
+		Sessions.LogoutAll()
 		os.Exit(int(v))
 	default:
 		fmt.Println(string(debug.Stack()))
@@ -175,35 +391,42 @@ func handleExit(cli *Vcli) {
 }
 
 func main() {
-	h, u, p, s := getArgs()
+	args := getArgs()
 
-	if h == "" || u == "" || p == "" {
+	// the password is asked for when the saved session can't be reused
+	if args.url == "" || args.username == "" {
 		printUsage()
 	}
 
-	cli, err := New(h, u, p, s)
+	if err := setupTrust(args); err != nil {
+		Errorln(err)
+		os.Exit(EXIT_USAGE)
+	}
+
+	cli, err := New(connectionName(args), args.url, args.username, args.password, args.profile)
 
 	if err != nil {
 		Errorln(err)
-		os.Exit(1)
-	}
-	auth := &Credentials{username: u, password: p}
-	cli.auth = auth
-
-	// go-prompt haven't exposed the api to reset the terminal settings
-	// Till we figure out that, don't disconnect session on idle timeout
-	/*
-		IdleActionTimer = time.NewTimer(IDLE_ACTION_TIMEOUT)
-
-		go func() {
-			<-IdleActionTimer.C
-			cli.client.Logout(cli.ctx)
-			Message("Session disconnected!")
-			panic(Exit(0))
-		}()
-	*/
-	defer handleExit(cli)
-	defer cli.client.Logout(cli.ctx)
+		os.Exit(EXIT_ERROR)
+	}
+	Sessions.Add(cli)
+
+	// Non-interactive mode, run the command or script and exit with its status
+	if args.command != "" || args.script != "" {
+		Spinner.Writer = ioutil.Discard
+		PagerEnabled = false
+		var code int
+		if args.command != "" {
+			code = runCommand(args.command)
+		} else {
+			code = runScript(args.script, args.keepGoing)
+		}
+		Sessions.LogoutAll()
+		os.Exit(code)
+	}
+
+	defer handleExit()
+	defer Sessions.LogoutAll()
 	defer func() {
 		if x := recover(); x != nil {
 			if Spinner.Active() {
@@ -213,7 +436,16 @@ func main() {
 		}
 	}()
 
+	if u, err := getURL(args.url, args.username, ""); err == nil {
+		if err := CmdHistory.Load(historyPath(args.profile, u)); err != nil {
+			Warnln("Failed to read history: " + err.Error())
+		}
+	}
+
+	// load the inventory cache while the prompt starts
+	cli.inventory.Start(cli)
+
 	a := cli.client.Client.ServiceContent.About
-	Success("Connected to %s running %s %s\n", h, a.Name, a.Version)
+	Success("Connected to %s running %s %s\n", args.url, a.Name, a.Version)
 	showPrompt()
 }
@@ -6,13 +6,44 @@ import (
 
 func optionCompleter(args []string, long bool) []prompt.Suggest {
 	l := len(args)
-	if l > 2 && ((args[0] == "vm" && args[1] == "list") || (args[0] == "hx" && args[1] == "info") || (args[0] == "en" && args[1] == "list")) {
-		return optionHelp
+	if l <= 2 {
+		return []prompt.Suggest{}
 	}
 
-	return []prompt.Suggest{}
+	var options []prompt.Suggest
+	switch args[0] + " " + args[1] {
+	case "vm list", "en list", "host list":
+		options = append(options, optionHelp...)
+		options = append(options, listOptionHelp...)
+	case "hx info":
+		options = append(options, optionHelp...)
+		options = append(options, listOptionHelp[0])
+	case "cr list", "dc list", "hx list":
+		options = listOptionHelp
+	case "task list":
+		options = append(options, taskOptionHelp...)
+		options = append(options, listOptionHelp...)
+	case "task watch":
+		options = taskOptionHelp[1:]
+	}
+	return prompt.FilterHasPrefix(options, args[l-1], true)
 }
 
 var optionHelp = []prompt.Suggest{
 	{Text: "-grep"},
 }
+
+// options selecting tasks, see taskQueryFlags
+var taskOptionHelp = []prompt.Suggest{
+	{Text: "-running", Description: "Queued and running tasks only"},
+	{Text: "-entity", Description: "Tasks of an inventory object and the objects below it"},
+	{Text: "-user", Description: "Tasks started by the logged in user"},
+}
+
+// options of the list commands, see listFlags
+var listOptionHelp = []prompt.Suggest{
+	{Text: "-filter", Description: "Show the rows matching an expression"},
+	{Text: "-cols", Description: "Columns to show"},
+	{Text: "-sort", Description: "Sort by column[:desc]"},
+	{Text: "-limit", Description: "Show only the first N rows"},
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/go/vcli/filter"
+	"github.com/tatsushid/go-prettytable"
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"strings"
+)
+
+const (
+	OUTPUT_TABLE = "table"
+	OUTPUT_JSON  = "json"
+	OUTPUT_YAML  = "yaml"
+	OUTPUT_CSV   = "csv"
+)
+
+var OutputFormats = []string{OUTPUT_TABLE, OUTPUT_JSON, OUTPUT_YAML, OUTPUT_CSV}
+
+// OutputFormat is the active renderer for command results, set with -o
+// or 'set output'
+var OutputFormat = OUTPUT_TABLE
+
+func SetOutputFormat(format string) error {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if isOutputFormat(format) {
+		OutputFormat = format
+		return nil
+	}
+	return fmt.Errorf("Unknown output format '%s', expected one of %s", format, strings.Join(OutputFormats, ", "))
+}
+
+func isOutputFormat(format string) bool {
+	format = strings.ToLower(strings.TrimSpace(format))
+	for _, f := range OutputFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// Column describes one field of a result table. Header is shown in
+// table output, Field is the stable name used by json, yaml and csv. Key
+// is the short name given to -cols, -sort and -filter of the list
+// commands, Kind the type of the raw values for -filter.
+type Column struct {
+	Header   string
+	Field    string
+	Key      string
+	Kind     filter.Kind
+	MinWidth int
+}
+
+// Cell carries a display text for table output and a raw value for
+// machine-readable formats, e.g. "12.00GB" and 12884901888
+type Cell struct {
+	Text string
+	Raw  interface{}
+}
+
+func (c Cell) String() string {
+	return c.Text
+}
+
+func NewCell(text string, raw interface{}) Cell {
+	return Cell{Text: text, Raw: raw}
+}
+
+// Table holds the records produced by a command. Vertical tables are
+// printed as 'Key: Value' pairs, one block per record.
+type Table struct {
+	Columns  []Column
+	Rows     [][]interface{}
+	Vertical bool
+}
+
+func NewTable(cols ...Column) *Table {
+	return &Table{Columns: cols}
+}
+
+func (t *Table) AddRow(values ...interface{}) {
+	t.Rows = append(t.Rows, values)
+}
+
+func (t *Table) Len() int {
+	return len(t.Rows)
+}
+
+// Print renders the table in the active output format, long tables are
+// shown in a pager
+func (t *Table) Print() {
+	out, err := t.Render(OutputFormat)
+	if err != nil {
+		Errorln(err)
+		return
+	}
+	writeOutput(out)
+}
+
+func (t *Table) Render(format string) ([]byte, error) {
+	switch format {
+	case OUTPUT_JSON:
+		return t.renderJSON()
+	case OUTPUT_YAML:
+		return t.renderYAML()
+	case OUTPUT_CSV:
+		return t.renderCSV()
+	default:
+		return t.renderTable()
+	}
+}
+
+func (t *Table) renderTable() ([]byte, error) {
+	if t.Vertical {
+		return t.renderVertical()
+	}
+
+	cols := make([]prettytable.Column, 0, len(t.Columns))
+	for _, c := range t.Columns {
+		cols = append(cols, prettytable.Column{Header: c.Header, MinWidth: c.MinWidth})
+	}
+
+	tbl, err := prettytable.NewTable(cols...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range t.Rows {
+		values := make([]interface{}, 0, len(row))
+		for _, v := range row {
+			values = append(values, textValue(v))
+		}
+		if err := tbl.AddRow(values...); err != nil {
+			return nil, err
+		}
+	}
+	return tbl.Bytes(), nil
+}
+
+func (t *Table) renderVertical() ([]byte, error) {
+	tbl, err := prettytable.NewTable([]prettytable.Column{
+		{Header: "Key", MinWidth: 12},
+		{Header: "Value"},
+	}...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tbl.NoHeader = true
+	for index, row := range t.Rows {
+		for i, c := range t.Columns {
+			if i < len(row) {
+				tbl.AddRow(c.Header+":", textValue(row[i]))
+			}
+		}
+		if len(t.Rows) > 1 && (index+1) != len(t.Rows) {
+			tbl.AddRow("-------------------", "-------------------------------------")
+		}
+	}
+	return tbl.Bytes(), nil
+}
+
+func (t *Table) renderJSON() ([]byte, error) {
+	records := make([]json.RawMessage, 0, len(t.Rows))
+	for _, row := range t.Rows {
+		var buf bytes.Buffer
+		buf.WriteByte('{')
+		for i, c := range t.Columns {
+			if i >= len(row) {
+				break
+			}
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, _ := json.Marshal(c.field())
+			value, err := json.Marshal(rawValue(row[i]))
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(key)
+			buf.WriteByte(':')
+			buf.Write(value)
+		}
+		buf.WriteByte('}')
+		records = append(records, json.RawMessage(buf.Bytes()))
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func (t *Table) renderYAML() ([]byte, error) {
+	records := make([]yaml.MapSlice, 0, len(t.Rows))
+	for _, row := range t.Rows {
+		record := yaml.MapSlice{}
+		for i, c := range t.Columns {
+			if i < len(row) {
+				record = append(record, yaml.MapItem{Key: c.field(), Value: rawValue(row[i])})
+			}
+		}
+		records = append(records, record)
+	}
+	return yaml.Marshal(records)
+}
+
+func (t *Table) renderCSV() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	header := make([]string, 0, len(t.Columns))
+	for _, c := range t.Columns {
+		header = append(header, c.field())
+	}
+	w.Write(header)
+
+	for _, row := range t.Rows {
+		record := make([]string, 0, len(row))
+		for _, v := range row {
+			record = append(record, csvValue(v))
+		}
+		w.Write(record)
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// field returns the stable record field name of a column, derived from
+// the header if not set explicitly
+func (c Column) field() string {
+	if c.Field != "" {
+		return c.Field
+	}
+	if c.Header == "#" {
+		return "index"
+	}
+	return strings.ToLower(strings.Replace(strings.TrimSpace(c.Header), " ", "_", -1))
+}
+
+// key returns the name of a column for -cols and -sort, the field if not
+// set explicitly
+func (c Column) key() string {
+	if c.Key != "" {
+		return c.Key
+	}
+	return c.field()
+}
+
+func textValue(v interface{}) string {
+	switch vv := v.(type) {
+	case nil:
+		return ""
+	case fmt.Stringer:
+		return vv.String()
+	default:
+		return fmt.Sprint(vv)
+	}
+}
+
+func rawValue(v interface{}) interface{} {
+	if c, ok := v.(Cell); ok {
+		return c.Raw
+	}
+	return v
+}
+
+func csvValue(v interface{}) string {
+	switch vv := rawValue(v).(type) {
+	case nil:
+		return ""
+	case string:
+		return vv
+	case []string:
+		return strings.Join(vv, ";")
+	case int, int32, int64, float64, bool:
+		return fmt.Sprint(vv)
+	default:
+		if reflect.ValueOf(vv).Kind() == reflect.String {
+			return reflect.ValueOf(vv).String()
+		}
+		// nested values are written as json documents
+		data, err := json.Marshal(vv)
+		if err != nil {
+			return textValue(v)
+		}
+		return string(data)
+	}
+}
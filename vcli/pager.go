@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"github.com/mattn/go-isatty"
+	"golang.org/x/crypto/ssh/terminal"
+	"os"
+	"os/exec"
+)
+
+// pager used when $PAGER is not set, -F quits at once if the output fits
+// on the screen and -R keeps colors
+const DEFAULT_PAGER = "less -FRX"
+
+// PagerEnabled pipes tables longer than the terminal through a pager, it
+// is changed with 'set pager' and off for commands run with -c or -f
+var PagerEnabled = true
+
+// writeOutput writes the output of a command to stdout, through the pager
+// if it doesn't fit on the terminal
+func writeOutput(out []byte) {
+	if !usePager(out) {
+		os.Stdout.Write(out)
+		return
+	}
+
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = DEFAULT_PAGER
+	}
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdin = bytes.NewReader(out)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		// without a working pager the output is written as is
+		if ee, ok := err.(*exec.ExitError); !ok || ee.ExitCode() == 127 {
+			os.Stdout.Write(out)
+		}
+	}
+}
+
+func usePager(out []byte) bool {
+	if !PagerEnabled || stdinScript || !isatty.IsTerminal(os.Stdout.Fd()) || !isatty.IsTerminal(os.Stdin.Fd()) {
+		return false
+	}
+	_, height, err := terminal.GetSize(int(os.Stdout.Fd()))
+	if err != nil || height <= 0 {
+		return false
+	}
+	// one line is left for the prompt
+	return bytes.Count(out, []byte("\n")) >= height
+}
@@ -5,18 +5,38 @@ import (
 )
 
 func showPrompt() {
+	colors := PromptColors{}
+	if ActiveProfile != nil {
+		colors = ActiveProfile.Prompt
+	}
+
 	p := prompt.New(
 		executor,
 		completer,
 		prompt.OptionPrefix("==> "),
+		prompt.OptionLivePrefix(livePrefix),
 		prompt.OptionTitle("vcli"),
+		prompt.OptionCompletionWordSeparator(COMPLETION_SEPARATORS),
 		// prompt.OptionPrefixTextColor(prompt.Turquoise),
 		// prompt.OptionPrefixTextColor(prompt.Fuchsia),
-		prompt.OptionPrefixTextColor(prompt.Yellow),
-		prompt.OptionPreviewSuggestionTextColor(prompt.Blue),
-		prompt.OptionSelectedSuggestionBGColor(prompt.LightGray),
-		prompt.OptionInputTextColor(prompt.Green),
-		// prompt.OptionHistory([]string{"about", "version", "vm list"}),
-		prompt.OptionSuggestionBGColor(prompt.DarkGray))
+		prompt.OptionPrefixTextColor(promptColor(colors.Prefix, prompt.Yellow)),
+		prompt.OptionPreviewSuggestionTextColor(promptColor(colors.Preview, prompt.Blue)),
+		prompt.OptionSelectedSuggestionBGColor(promptColor(colors.Selected, prompt.LightGray)),
+		prompt.OptionInputTextColor(promptColor(colors.Input, prompt.Green)),
+		prompt.OptionHistory(CmdHistory.Entries()),
+		prompt.OptionAddKeyBind(historyKeyBinds...),
+		prompt.OptionSuggestionBGColor(promptColor(colors.Suggestion, prompt.DarkGray)))
 	p.Run()
 }
+
+// livePrefix shows the active connection in the prompt, or the command
+// found by a history search
+func livePrefix() (string, bool) {
+	if Search.active {
+		return Search.Prefix(), true
+	}
+	if v := GetVcli(); v != nil {
+		return v.name + " ==> ", true
+	}
+	return "", false
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Result is returned by every command: typed records with their column
+// metadata, informational messages and errors of individual items. The
+// executor decides how it is rendered.
+type Result struct {
+	Table    *Table
+	Messages []string
+	Errors   []error
+	Usage    string
+	exit     bool
+	mu       sync.Mutex
+}
+
+func NewResult() *Result {
+	return &Result{}
+}
+
+func TableResult(t *Table) *Result {
+	return &Result{Table: t}
+}
+
+func UsageResult(usage string) *Result {
+	return &Result{Usage: usage}
+}
+
+func MessageResult(format string, a ...interface{}) *Result {
+	r := NewResult()
+	r.Message(format, a...)
+	return r
+}
+
+// Message and AddError are safe to call from concurrent workers of a command
+func (r *Result) Message(format string, a ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Messages = append(r.Messages, fmt.Sprintf(format, a...))
+}
+
+func (r *Result) AddError(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Errors = append(r.Errors, err)
+}
+
+// Err summarizes the per-item errors of a result, it is nil if every
+// item succeeded
+func (r *Result) Err() error {
+	switch len(r.Errors) {
+	case 0:
+		return nil
+	case 1:
+		return r.Errors[0]
+	}
+	return fmt.Errorf("%d errors", len(r.Errors))
+}
+
+// Print renders the result. Records go to stdout in the active output
+// format, messages and errors go to stderr when a machine-readable format
+// is active so they don't break the output.
+func (r *Result) Print() {
+	if r.Usage != "" {
+		Usage(r.Usage)
+	}
+
+	if r.Table != nil {
+		r.Table.Print()
+	}
+
+	for _, m := range r.Messages {
+		if OutputFormat == OUTPUT_TABLE {
+			Infoln(m)
+		} else {
+			fmt.Fprintln(os.Stderr, m)
+		}
+	}
+
+	for _, e := range r.Errors {
+		if OutputFormat == OUTPUT_TABLE {
+			Errorln(e.Error())
+		} else {
+			fmt.Fprintln(os.Stderr, e.Error())
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VM attributes of -where conditions
+const (
+	VM_ATTR_NAME   = "name"
+	VM_ATTR_STATE  = "state"
+	VM_ATTR_POWER  = "power"
+	VM_ATTR_FOLDER = "folder"
+	VM_ATTR_HOST   = "host"
+	VM_ATTR_GUEST  = "guest"
+	VM_ATTR_IP     = "ip"
+)
+
+var vmAttributes = []string{VM_ATTR_NAME, VM_ATTR_STATE, VM_ATTR_POWER, VM_ATTR_FOLDER, VM_ATTR_HOST, VM_ATTR_GUEST, VM_ATTR_IP}
+
+var vmMorefMatch = regexp.MustCompile(`^vm-\d+$`)
+
+// help of the selector options, shared by the usage of the VM commands
+const vmSelectorOptions = `  -re=regex        Select VMs whose name matches a regular expression
+  -where=attr=value[,attr=value]
+                   Select VMs by attributes: name, state (or power),
+                   folder, host, guest and ip. Values may be globs.`
+
+// vmSelectorHelp explains the ways to name VMs in the usage of VM commands
+const vmSelectorHelp = `VMs are given as a comma separated list of names, globs (web-*), inventory
+paths (/DC1/vm/Team/*), managed object IDs (vm-1234) or numbers of 'vm list'.
+A VM named exactly like the given text is taken before globs and IDs.`
+
+// VmSelector selects VMs by a comma separated list of names, numbers,
+// globs, inventory paths or managed object IDs, narrowed down by a regular
+// expression on the name and by attribute conditions
+type VmSelector struct {
+	Names  string
+	Regex  *regexp.Regexp
+	Where  []vmCondition
+	DryRun bool
+}
+
+type vmCondition struct {
+	attr  string
+	value string
+}
+
+func newVmSelector(names string) *VmSelector {
+	return &VmSelector{Names: names}
+}
+
+// vmSelectorFlags adds the selector options to the flags of a command
+type vmSelectorFlags struct {
+	re     *string
+	where  *string
+	dryRun *bool
+}
+
+func newVmSelectorFlags(fs *flag.FlagSet, dryRun bool) *vmSelectorFlags {
+	f := &vmSelectorFlags{
+		re:    fs.String("re", "", "Regular expression on VM names"),
+		where: fs.String("where", "", "Attribute conditions"),
+	}
+	if dryRun {
+		f.dryRun = fs.Bool("dry-run", false, "Only show the selected VMs")
+	}
+	return f
+}
+
+// Selector returns the selection of the options and the positional
+// arguments, nil if no VM has been given at all
+func (f *vmSelectorFlags) Selector(args []string) (*VmSelector, error) {
+	s := newVmSelector(strings.Join(args, ","))
+	if f.dryRun != nil {
+		s.DryRun = *f.dryRun
+	}
+
+	if *f.re != "" {
+		re, err := regexp.Compile(*f.re)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regular expression '%s': %s", *f.re, err)
+		}
+		s.Regex = re
+	}
+
+	if *f.where != "" {
+		for _, cond := range strings.Split(*f.where, ",") {
+			kv := strings.SplitN(cond, "=", 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[1]) == "" {
+				return nil, fmt.Errorf("Invalid condition '%s', expected attr=value", cond)
+			}
+			attr := strings.ToLower(strings.TrimSpace(kv[0]))
+			if !isVmAttribute(attr) {
+				return nil, fmt.Errorf("Unknown attribute '%s', expected one of: %s", attr, strings.Join(vmAttributes, ", "))
+			}
+			value := strings.ToLower(strings.TrimSpace(kv[1]))
+			if _, err := path.Match(value, ""); err != nil {
+				return nil, fmt.Errorf("Invalid pattern '%s': %s", value, err)
+			}
+			s.Where = append(s.Where, vmCondition{attr: attr, value: value})
+		}
+	}
+
+	if s.Names == "" && s.Regex == nil && len(s.Where) == 0 {
+		return nil, nil
+	}
+	return s, nil
+}
+
+func isVmAttribute(attr string) bool {
+	for _, a := range vmAttributes {
+		if a == attr {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPattern reports whether the selection may match other VMs than the
+// ones named, so the matched set is worth showing
+func (s *VmSelector) IsPattern() bool {
+	if s.Regex != nil || len(s.Where) > 0 {
+		return true
+	}
+	for _, name := range strings.Split(s.Names, ",") {
+		if isGlob(name) || strings.HasPrefix(name, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+func isGlob(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// Find retrieves the given properties, which must include 'summary', of
+// the selected VMs. Every element of the list has to match a VM. VMs are
+// looked up in the inventory cache, only the selected ones are retrieved.
+func (s *VmSelector) Find(cli *Vcli, props []string) ([]mo.VirtualMachine, error) {
+	vms, err := cli.inventory.Objects(cli.ctx, cli, INVENTORY_VM)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := vms
+	if s.Names != "" {
+		candidates = nil
+		seen := make(map[types.ManagedObjectReference]bool)
+		for _, name := range strings.Split(s.Names, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			matched, err := matchVmName(vms, name)
+			if err != nil {
+				return nil, err
+			}
+			if len(matched) == 0 {
+				return nil, fmt.Errorf("Virtual machine '%s' is not found", name)
+			}
+			for _, vm := range matched {
+				if !seen[vm.Ref] {
+					seen[vm.Ref] = true
+					candidates = append(candidates, vm)
+				}
+			}
+		}
+	}
+
+	var refs []types.ManagedObjectReference
+	for _, vm := range candidates {
+		if s.Regex != nil && !s.Regex.MatchString(vm.Name) {
+			continue
+		}
+		if s.matchWhere(vmAttributeValues(cli, vm)) {
+			refs = append(refs, vm.Ref)
+		}
+	}
+
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("No virtual machine matches the selection")
+	}
+	return retrieveVms(cli, refs, props)
+}
+
+// matchVmName returns the VMs matching one element of the list. A VM
+// named exactly like the element wins, so VMs named like a glob or a
+// managed object ID, e.g. db[1] or vm-42, can still be given literally.
+func matchVmName(vms []*InventoryObject, name string) ([]*InventoryObject, error) {
+	var matched []*InventoryObject
+	for _, vm := range vms {
+		if vm.Name == name || vm.Path == name {
+			matched = append(matched, vm)
+		}
+	}
+	if len(matched) > 0 {
+		return matched, nil
+	}
+
+	switch {
+	case strings.HasPrefix(name, "/") || isGlob(name):
+		if _, err := path.Match(name, ""); err != nil {
+			return nil, fmt.Errorf("Invalid pattern '%s': %s", name, err)
+		}
+		for _, vm := range vms {
+			target := vm.Name
+			if strings.HasPrefix(name, "/") {
+				target = vm.Path
+			}
+			if ok, _ := path.Match(name, target); ok {
+				matched = append(matched, vm)
+			}
+		}
+	case vmMorefMatch.MatchString(name):
+		for _, vm := range vms {
+			if vm.Ref.Value == name {
+				matched = append(matched, vm)
+			}
+		}
+	default:
+		for index, vm := range vms {
+			if strconv.Itoa(index+1) == name {
+				matched = append(matched, vm)
+			}
+		}
+	}
+	return matched, nil
+}
+
+// vmAttributeValues returns the -where attributes of a cached VM
+func vmAttributeValues(cli *Vcli, vm *InventoryObject) map[string]string {
+	return map[string]string{
+		VM_ATTR_NAME:   vm.Name,
+		VM_ATTR_STATE:  vm.String("runtime.powerState"),
+		VM_ATTR_POWER:  vm.String("runtime.powerState"),
+		VM_ATTR_GUEST:  vm.String("summary.config.guestFullName"),
+		VM_ATTR_IP:     vm.String("guest.ipAddress"),
+		VM_ATTR_FOLDER: cli.inventory.Name(vm.Reference("parent")),
+		VM_ATTR_HOST:   cli.inventory.Name(vm.Reference("runtime.host")),
+	}
+}
+
+func (s *VmSelector) matchWhere(attrs map[string]string) bool {
+	for _, cond := range s.Where {
+		if ok, _ := path.Match(cond.value, strings.ToLower(attrs[cond.attr])); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// retrieveVms retrieves the properties of VMs in the order of refs
+func retrieveVms(cli *Vcli, refs []types.ManagedObjectReference, props []string) ([]mo.VirtualMachine, error) {
+	var vms []mo.VirtualMachine
+	pc := property.DefaultCollector(cli.client.Client)
+	if err := pc.Retrieve(cli.ctx, refs, props, &vms); err != nil {
+		return nil, err
+	}
+
+	byRef := make(map[types.ManagedObjectReference]mo.VirtualMachine, len(vms))
+	for _, vm := range vms {
+		byRef[vm.Self] = vm
+	}
+	ordered := make([]mo.VirtualMachine, 0, len(vms))
+	for _, ref := range refs {
+		if vm, ok := byRef[ref]; ok {
+			ordered = append(ordered, vm)
+		}
+	}
+	return ordered, nil
+}
+
+// previewVms lists the VMs a pattern selected before they are changed
+func previewVms(vms []mo.VirtualMachine) {
+	names := make([]string, 0, len(vms))
+	for _, vm := range vms {
+		names = append(names, vm.Summary.Config.Name)
+	}
+	sort.Strings(names)
+	Progress("Selected %d VM(s): %s", len(vms), strings.Join(names, ", "))
+}
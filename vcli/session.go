@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	SESSIONS_DIR = "sessions"
+	// vCenter drops sessions idle for 30 minutes by default
+	KEEPALIVE_INTERVAL = 10 * time.Minute
+)
+
+// SaveSessions keeps the sessions for the next vcli run, it is turned off
+// with -no-session
+var SaveSessions = true
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._@-]`)
+
+// SavedSession is the SOAP session cookie of a connection kept between
+// vcli runs, so short non-interactive runs don't log in every time
+type SavedSession struct {
+	Host     string `json:"host"`
+	Username string `json:"username"`
+	Cookie   string `json:"cookie"`
+}
+
+// sessionPath returns the file of the saved session of a profile, or of
+// user@host if vcli runs without a profile
+func sessionPath(profile string, u *url.URL) string {
+	return filepath.Join(configDir(), SESSIONS_DIR, connectionFileName(profile, u))
+}
+
+// connectionFileName names the files kept per profile, or per user@host
+func connectionFileName(profile string, u *url.URL) string {
+	name := profile
+	if name == "" {
+		name = u.User.Username() + "@" + u.Host
+	}
+	return unsafeFileChars.ReplaceAllString(name, "_")
+}
+
+// restoreSession puts a saved session cookie into the client and checks
+// with the SessionManager that the session is still valid for the user
+func restoreSession(ctx context.Context, c *govmomi.Client, u *url.URL, path string) bool {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	var s SavedSession
+	if err = json.Unmarshal(data, &s); err != nil || s.Cookie == "" {
+		return false
+	}
+	if s.Host != u.Host || !strings.EqualFold(s.Username, u.User.Username()) {
+		return false
+	}
+
+	c.Client.Jar.SetCookies(c.URL(), []*http.Cookie{{Name: SessionCookieName, Value: s.Cookie}})
+	us, err := c.SessionManager.UserSession(ctx)
+	return err == nil && us != nil
+}
+
+// saveSession writes the session cookie of a logged in client, readable
+// only by the user
+func saveSession(c *govmomi.Client, u *url.URL, path string) error {
+	var cookie string
+	for _, ck := range c.Client.Jar.Cookies(c.URL()) {
+		if ck.Name == SessionCookieName {
+			cookie = ck.Value
+		}
+	}
+	if cookie == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(SavedSession{Host: u.Host, Username: u.User.Username(), Cookie: cookie})
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// an existing file may have been created with other permissions
+	if err = f.Chmod(0600); err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// errNoPassword is returned by Password when the password is neither known
+// nor can be asked for
+var errNoPassword = errors.New("No password is known and there is no terminal to ask for it, use a profile with a credential reference")
+
+// Password returns the password of the connection user. A session restored
+// from the session file has been opened without it, then it is read from
+// the profile or asked for on the terminal, once.
+func (v *Vcli) Password() (string, error) {
+	if v.auth == nil {
+		return "", errors.New("No credentials of the connection")
+	}
+	v.auth.mu.Lock()
+	defer v.auth.mu.Unlock()
+	if v.auth.password != "" {
+		return v.auth.password, nil
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	profile, err := config.Profile(v.auth.profile)
+	if err != nil {
+		return "", err
+	}
+	var password string
+	if profile != nil {
+		if password, err = profile.Password(); err != nil {
+			return "", err
+		}
+	}
+
+	if password == "" {
+		if !canPrompt() {
+			return "", errNoPassword
+		}
+		password, err = readPassword(fmt.Sprintf("Password for %s@%s: ", v.auth.username, v.host))
+		if err != nil {
+			return "", err
+		}
+	}
+	v.auth.password = password
+	return password, nil
+}
+
+// Relogin logs in again with the credentials the session was started
+// with, e.g. after the session expired
+func (v *Vcli) Relogin() error {
+	if v.auth == nil {
+		return errors.New("No credentials to log in again")
+	}
+	password, err := v.Password()
+	if err != nil {
+		return err
+	}
+
+	u := v.client.URL()
+	u.User = url.UserPassword(v.auth.username, password)
+	if err := v.client.Login(v.ctx, u.User); err != nil {
+		return err
+	}
+
+	if v.sessionFile != "" {
+		if err := saveSession(v.client, u, v.sessionFile); err != nil {
+			Warnln("Failed to save session: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// isSessionExpired reports whether a command failed because vCenter no
+// longer knows the session
+func isSessionExpired(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var fault interface{}
+	if soap.IsSoapFault(err) {
+		fault = soap.ToSoapFault(err).VimFault()
+	} else if soap.IsVimFault(err) {
+		fault = soap.ToVimFault(err)
+	}
+	switch fault.(type) {
+	case types.NotAuthenticated, *types.NotAuthenticated:
+		return true
+	}
+
+	// faults wrapped into other errors only keep their message
+	return strings.Contains(err.Error(), INVALID_SESSION)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withConfig points the config directory to a temporary one holding the
+// given config file, until the returned function is called
+func withConfig(t *testing.T, config string) (string, func()) {
+	dir, err := ioutil.TempDir("", "vcli-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "vcli"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "vcli", CONFIG_FILE), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	old, set := os.LookupEnv("XDG_CONFIG_HOME")
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	// no terminal is asked for a password
+	stdinScript = true
+	return dir, func() {
+		if set {
+			os.Setenv("XDG_CONFIG_HOME", old)
+		} else {
+			os.Unsetenv("XDG_CONFIG_HOME")
+		}
+		stdinScript = false
+		os.RemoveAll(dir)
+	}
+}
+
+func TestPassword(t *testing.T) {
+	dir, cleanup := withConfig(t, `
+default: lab
+profiles:
+  lab:
+    host: vc.lab
+    username: admin
+  blr:
+    host: vc.blr
+    username: admin
+    password_command: echo secret-blr
+  plain:
+    host: vc.plain
+    username: admin
+`)
+	defer cleanup()
+
+	tests := []struct {
+		name     string
+		password string
+		profile  string
+		want     string
+		err      string
+	}{
+		{"known password", "given", "blr", "given", ""},
+		{"profile password", "", "blr", "secret-blr", ""},
+		{"no credential reference", "", "plain", "", errNoPassword.Error()},
+		{"default profile", "", "", "", errNoPassword.Error()},
+		{"unknown profile", "", "nope", "", "Unknown profile 'nope' in " + filepath.Join(dir, "vcli", CONFIG_FILE) + ", expected one of: blr, lab, plain"},
+	}
+
+	for _, tt := range tests {
+		v := &Vcli{host: "vc", auth: &Credentials{username: "admin", password: tt.password, profile: tt.profile}}
+		got, err := v.Password()
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+		// the password is kept for the next HX login or relogin
+		if v.auth.password != tt.want {
+			t.Errorf("%s: kept %q, want %q", tt.name, v.auth.password, tt.want)
+		}
+	}
+}
+
+func TestHxCredentials(t *testing.T) {
+	_, cleanup := withConfig(t, `
+profiles:
+  lab:
+    host: vc.lab
+    username: admin
+    password_command: echo secret
+`)
+	defer cleanup()
+
+	// a session restored without a password logs in to HX with the
+	// password of the profile
+	v := &Vcli{host: "vc", auth: &Credentials{username: "admin", profile: "lab"}}
+	auth, err := hxCredentials(v)
+	if err != nil || auth.username != "admin" || auth.password != "secret" {
+		t.Errorf("hxCredentials = %+v, %v, want admin with the profile password", auth, err)
+	}
+
+	v = &Vcli{host: "vc", auth: &Credentials{username: "admin"}}
+	if _, err := hxCredentials(v); err == nil || err.Error() != "Password required for HX: "+errNoPassword.Error() {
+		t.Errorf("hxCredentials without a password: got error %v", err)
+	}
+}
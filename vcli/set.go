@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+)
+
+type SetCommand struct{}
+
+const (
+	SET_OUTPUT = "output"
+	SET_PAGER  = "pager"
+)
+
+func (c *SetCommand) Usage() string {
+	return `Usage: set [setting value]
+
+Show or change vcli settings
+
+Settings:
+  output    Output format: table, json, yaml or csv
+  pager     Show tables longer than the terminal in $PAGER: on or off
+
+Examples:
+  set
+  set output json
+  set pager off
+`
+}
+
+// 'set' command handler
+func (c *SetCommand) Execute(v *Vcli, args ...string) (*Result, error) {
+	if len(args) == 0 {
+		tbl := NewTable(Column{Header: "Output", Field: "output"}, Column{Header: "Pager", Field: "pager"})
+		tbl.Vertical = true
+		tbl.AddRow(OutputFormat, onOff(PagerEnabled))
+		return TableResult(tbl), nil
+	}
+
+	if len(args) != 2 {
+		return UsageResult(c.Usage()), nil
+	}
+
+	switch args[0] {
+	case SET_OUTPUT:
+		return nil, SetOutputFormat(args[1])
+	case SET_PAGER:
+		switch args[1] {
+		case "on":
+			PagerEnabled = true
+		case "off":
+			PagerEnabled = false
+		default:
+			return nil, fmt.Errorf("Invalid value '%s' for pager, expected on or off", args[1])
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("Unknown setting '%s'", args[0])
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+	"strings"
+)
+
+type VmSnapshotCommand struct{}
+type VmSnapshotListCommand struct{}
+type VmSnapshotCreateCommand struct{}
+type VmSnapshotRevertCommand struct{}
+type VmSnapshotRemoveCommand struct{}
+type VmSnapshotRemoveAllCommand struct{}
+
+const (
+	SNAPSHOT_LIST       = "list"
+	SNAPSHOT_CREATE     = "create"
+	SNAPSHOT_REVERT     = "revert"
+	SNAPSHOT_REMOVE     = "remove"
+	SNAPSHOT_REMOVE_ALL = "remove-all"
+)
+
+var snapshotCommands = map[string]Command{
+	SNAPSHOT_LIST:       &VmSnapshotListCommand{},
+	SNAPSHOT_CREATE:     &VmSnapshotCreateCommand{},
+	SNAPSHOT_REVERT:     &VmSnapshotRevertCommand{},
+	SNAPSHOT_REMOVE:     &VmSnapshotRemoveCommand{},
+	SNAPSHOT_REMOVE_ALL: &VmSnapshotRemoveAllCommand{},
+}
+
+var snapshotActions = map[string]vmAction{
+	SNAPSHOT_CREATE:     vmAction{"CreateSnapshot", "Creating snapshot of"},
+	SNAPSHOT_REVERT:     vmAction{"RevertToSnapshot", "Reverting"},
+	SNAPSHOT_REMOVE:     vmAction{"RemoveSnapshot", "Removing snapshot of"},
+	SNAPSHOT_REMOVE_ALL: vmAction{"RemoveAllSnapshots", "Removing all snapshots of"},
+}
+
+func (c *VmSnapshotCommand) Execute(v *Vcli, args ...string) (*Result, error) {
+	if len(args) > 0 {
+		cmd := args[0]
+		options := args[1:]
+		if fn, ok := snapshotCommands[cmd]; ok {
+			t, err := fn.Execute(v, options...)
+			return t, err
+		}
+		return nil, fmt.Errorf("Unknown subcommand '%s' for vm snapshot", cmd)
+	}
+	return UsageResult(c.Usage()), nil
+}
+
+func (c *VmSnapshotCommand) Usage() string {
+	return `Usage: vm snapshot [command]
+
+Commands:
+  list          Show snapshot tree of VM(s)
+  create        Create a snapshot of VM(s)
+  revert        Revert VM(s) to a snapshot
+  remove        Remove a snapshot of VM(s)
+  remove-all    Remove all snapshots of VM(s)
+`
+}
+
+func (cmd *VmSnapshotListCommand) Usage() string {
+	return `Usage: vm snapshot list [options] vm-name1 [,vm-name2, ...]
+
+Show snapshot tree of VM(s), the current snapshot is marked with '*'
+
+` + vmSelectorHelp + `
+
+Options:
+` + vmSelectorOptions + `
+
+Examples:
+  vm snapshot list Ubuntu-01
+  vm snapshot list hx-01,hx-02
+  vm snapshot list -re '^hx-'
+`
+}
+
+func (cmd *VmSnapshotListCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	listCmd := flag.NewFlagSet(SNAPSHOT_LIST, flag.ContinueOnError)
+	selectorFlags := newVmSelectorFlags(listCmd, false)
+	names, err := parseFlags(listCmd, args)
+	if err != nil {
+		return UsageResult(cmd.Usage()), nil
+	}
+	sel, err := selectorFlags.Selector(names)
+	if err != nil {
+		return nil, err
+	}
+	if sel == nil {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	vms, err := sel.Find(cli, []string{"summary", "snapshot"})
+	if err != nil {
+		return nil, err
+	}
+
+	tbl := NewTable([]Column{
+		{Header: "VM", Field: "vm"},
+		{Header: "Snapshot", Field: "name"},
+		{Header: "Parent", Field: "parent"},
+		{Header: "Current", Field: "current"},
+		{Header: "Created", Field: "create_time"},
+		{Header: "Quiesced", Field: "quiesced"},
+		{Header: "Memory", Field: "memory"},
+		{Header: "Description", Field: "description"},
+	}...)
+
+	r := TableResult(tbl)
+	for _, vm := range vms {
+		vmName := vm.Summary.Config.Name
+		if vm.Snapshot == nil || len(vm.Snapshot.RootSnapshotList) == 0 {
+			r.Message("'%s' has no snapshots", vmName)
+			continue
+		}
+		addSnapshotRows(tbl, vmName, "", vm.Snapshot.CurrentSnapshot, vm.Snapshot.RootSnapshotList, 0)
+	}
+	return r, nil
+}
+
+// addSnapshotRows adds the snapshot tree depth first, indenting the
+// snapshot name by its depth in table output
+func addSnapshotRows(tbl *Table, vmName string, parent string, current *types.ManagedObjectReference, snapshots []types.VirtualMachineSnapshotTree, depth int) {
+	for _, s := range snapshots {
+		isCurrent := current != nil && current.Value == s.Snapshot.Value
+		marker := ""
+		if isCurrent {
+			marker = "*"
+		}
+
+		// snapshots taken while the VM was powered on include its memory
+		memory := s.State == types.VirtualMachinePowerStatePoweredOn
+
+		tbl.AddRow(vmName,
+			NewCell(strings.Repeat("  ", depth)+s.Name, s.Name),
+			parent,
+			NewCell(marker, isCurrent),
+			NewCell(s.CreateTime.Local().Format("2006-01-02 15:04:05"), s.CreateTime),
+			s.Quiesced,
+			memory,
+			s.Description)
+
+		addSnapshotRows(tbl, vmName, s.Name, current, s.ChildSnapshotList, depth+1)
+	}
+}
+
+func (cmd *VmSnapshotCreateCommand) Usage() string {
+	return `Usage: vm snapshot create [options] vm-name1 [,vm-name2, ...] snapshot-name
+
+Create a snapshot of VM(s). With -re or -where the VM names may be left out.
+
+` + vmSelectorHelp + `
+
+Options:
+  -memory          Include the memory of powered on VMs
+  -quiesce         Quiesce the guest file system through VMware Tools
+  -desc=text       Snapshot description
+` + vmSelectorOptions + `
+  -dry-run         Only show the selected VMs
+
+Examples:
+  vm snapshot create Ubuntu-01 before-upgrade
+  vm snapshot create -memory -desc=pre-4.5 hx-01,hx-02 before-upgrade
+  vm snapshot create -where folder=QA before-upgrade
+`
+}
+
+func (cmd *VmSnapshotCreateCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	createCmd := flag.NewFlagSet(SNAPSHOT_CREATE, flag.ContinueOnError)
+	memory := createCmd.Bool("memory", false, "Include VM memory")
+	quiesce := createCmd.Bool("quiesce", false, "Quiesce guest file system")
+	desc := createCmd.String("desc", "", "Snapshot description")
+	selectorFlags := newVmSelectorFlags(createCmd, true)
+	names, err := parseFlags(createCmd, args)
+	if err != nil || len(names) == 0 {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	// the snapshot name comes last
+	snapshot := names[len(names)-1]
+	sel, err := selectorFlags.Selector(names[:len(names)-1])
+	if err != nil {
+		return nil, err
+	}
+	if sel == nil {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	return runVmAction(cli, sel, snapshotActions[SNAPSHOT_CREATE], func(ctx context.Context, vm *object.VirtualMachine) error {
+		task, err := vm.CreateSnapshot(ctx, snapshot, *desc, *memory, *quiesce)
+		return waitForTask(ctx, task, err, vm.Name())
+	})
+}
+
+func (cmd *VmSnapshotRevertCommand) Usage() string {
+	return `Usage: vm snapshot revert [options] vm-name1 [,vm-name2, ...] [snapshot-name]
+
+Revert VM(s) to a snapshot, or to the current snapshot if no name is given.
+The VM name, or the number of VMs, has to be typed to confirm.
+
+` + vmSelectorHelp + `
+
+Options:
+  -suppress-poweron   Don't power on the VM if the snapshot includes memory
+  -yes                Don't ask for confirmation
+  -dry-run            Only show the VMs that would be reverted
+` + vmSelectorOptions + `
+
+Examples:
+  vm snapshot revert Ubuntu-01
+  vm snapshot revert hx-01,hx-02 before-upgrade
+  vm snapshot revert -dry-run -where folder=QA '*' before-upgrade
+`
+}
+
+func (cmd *VmSnapshotRevertCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	revertCmd := flag.NewFlagSet(SNAPSHOT_REVERT, flag.ContinueOnError)
+	suppressPowerOn := revertCmd.Bool("suppress-poweron", false, "Don't power on the VM")
+	yes := revertCmd.Bool("yes", false, "Don't ask for confirmation")
+	selectorFlags := newVmSelectorFlags(revertCmd, true)
+	names, err := parseFlags(revertCmd, args)
+	if err != nil || len(names) > 2 {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	var snapshot string
+	if len(names) == 2 {
+		snapshot = names[1]
+		names = names[:1]
+	}
+	sel, err := selectorFlags.Selector(names)
+	if err != nil {
+		return nil, err
+	}
+	if sel == nil {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	question := func(count int) string {
+		if snapshot == "" {
+			return fmt.Sprintf("%d VM(s) will be reverted to their current snapshot.", count)
+		}
+		return fmt.Sprintf("%d VM(s) will be reverted to snapshot '%s'.", count, snapshot)
+	}
+	return runSnapshotChange(cli, sel, SNAPSHOT_REVERT, question, *yes, func(ctx context.Context, vm *object.VirtualMachine) error {
+		var task *object.Task
+		var err error
+		if snapshot == "" {
+			task, err = vm.RevertToCurrentSnapshot(ctx, *suppressPowerOn)
+		} else {
+			task, err = vm.RevertToSnapshot(ctx, snapshot, *suppressPowerOn)
+		}
+		return waitForTask(ctx, task, err, vm.Name())
+	})
+}
+
+func (cmd *VmSnapshotRemoveCommand) Usage() string {
+	return `Usage: vm snapshot remove [options] vm-name1 [,vm-name2, ...] snapshot-name
+
+Remove a snapshot of VM(s). With -re or -where the VM names may be left out.
+The VM name, or the number of VMs, has to be typed to confirm.
+
+` + vmSelectorHelp + `
+
+Options:
+  -children    Remove the child snapshots as well
+  -yes         Don't ask for confirmation
+  -dry-run     Only show the VMs whose snapshot would be removed
+` + vmSelectorOptions + `
+
+Examples:
+  vm snapshot remove Ubuntu-01 before-upgrade
+  vm snapshot remove -children hx-01,hx-02 before-upgrade
+  vm snapshot remove -dry-run -re '^hx-' before-upgrade
+`
+}
+
+func (cmd *VmSnapshotRemoveCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	removeCmd := flag.NewFlagSet(SNAPSHOT_REMOVE, flag.ContinueOnError)
+	children := removeCmd.Bool("children", false, "Remove child snapshots")
+	yes := removeCmd.Bool("yes", false, "Don't ask for confirmation")
+	selectorFlags := newVmSelectorFlags(removeCmd, true)
+	names, err := parseFlags(removeCmd, args)
+	if err != nil || len(names) == 0 {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	// the snapshot name comes last
+	snapshot := names[len(names)-1]
+	sel, err := selectorFlags.Selector(names[:len(names)-1])
+	if err != nil {
+		return nil, err
+	}
+	if sel == nil {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	question := func(count int) string {
+		return fmt.Sprintf("Snapshot '%s' of %d VM(s) will be removed.", snapshot, count)
+	}
+	return runSnapshotChange(cli, sel, SNAPSHOT_REMOVE, question, *yes, func(ctx context.Context, vm *object.VirtualMachine) error {
+		task, err := vm.RemoveSnapshot(ctx, snapshot, *children, nil)
+		return waitForTask(ctx, task, err, vm.Name())
+	})
+}
+
+func (cmd *VmSnapshotRemoveAllCommand) Usage() string {
+	return `Usage: vm snapshot remove-all [options] vm-name1 [,vm-name2, ...]
+
+Remove all snapshots of VM(s). The VM name, or the number of VMs, has to be
+typed to confirm.
+
+` + vmSelectorHelp + `
+
+Options:
+  -yes             Don't ask for confirmation
+  -dry-run         Only show the VMs whose snapshots would be removed
+` + vmSelectorOptions + `
+
+Examples:
+  vm snapshot remove-all Ubuntu-01
+  vm snapshot remove-all -dry-run -where folder=QA
+`
+}
+
+func (cmd *VmSnapshotRemoveAllCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	removeAllCmd := flag.NewFlagSet(SNAPSHOT_REMOVE_ALL, flag.ContinueOnError)
+	yes := removeAllCmd.Bool("yes", false, "Don't ask for confirmation")
+	selectorFlags := newVmSelectorFlags(removeAllCmd, true)
+	names, err := parseFlags(removeAllCmd, args)
+	if err != nil {
+		return UsageResult(cmd.Usage()), nil
+	}
+	sel, err := selectorFlags.Selector(names)
+	if err != nil {
+		return nil, err
+	}
+	if sel == nil {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	question := func(count int) string {
+		return fmt.Sprintf("All snapshots of %d VM(s) will be removed.", count)
+	}
+	return runSnapshotChange(cli, sel, SNAPSHOT_REMOVE_ALL, question, *yes, func(ctx context.Context, vm *object.VirtualMachine) error {
+		task, err := vm.RemoveAllSnapshot(ctx, nil)
+		return waitForTask(ctx, task, err, vm.Name())
+	})
+}
+
+// runSnapshotChange shows the selected VMs and asks for confirmation before
+// a snapshot action that discards VM state, with -dry-run they are only
+// shown. question returns the confirmation question for the number of VMs.
+func runSnapshotChange(cli *Vcli, sel *VmSelector, action string, question func(int) string, yes bool, fn vmActionFunc) (*Result, error) {
+	vms, err := sel.Find(cli, []string{"summary"})
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := getVmPlan(cli, vms)
+	if err != nil {
+		return nil, err
+	}
+	if sel.DryRun {
+		return DryRunResult(plan), nil
+	}
+
+	vmNames := make([]string, 0, len(vms))
+	for _, vm := range vms {
+		vmNames = append(vmNames, vm.Summary.Config.Name)
+	}
+	if err := confirm(question(len(vms)), confirmValue(vmNames), plan, yes); err != nil {
+		return nil, err
+	}
+
+	return runVmActionOn(cli, vms, snapshotActions[action], fn)
+}
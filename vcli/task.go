@@ -0,0 +1,708 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/go/vcli/filter"
+	"github.com/mattn/go-isatty"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type TaskCommand struct{}
+type TaskListCommand struct{}
+type TaskWatchCommand struct{}
+type TaskCancelCommand struct{}
+
+const (
+	TASK_LIST   = "list"
+	TASK_WATCH  = "watch"
+	TASK_CANCEL = "cancel"
+)
+
+// number of tasks read from the task history unless -limit asks for more
+const TASK_HISTORY_PAGE = 100
+
+var taskCommands = map[string]Command{
+	TASK_LIST:   &TaskListCommand{},
+	TASK_WATCH:  &TaskWatchCommand{},
+	TASK_CANCEL: &TaskCancelCommand{},
+}
+
+// columns of 'task list' and 'task watch', the keys are given to -cols,
+// -sort and -filter
+var taskColumns = []Column{
+	{Header: "ID", Field: "id"},
+	{Header: "Task", Field: "task"},
+	{Header: "Target", Field: "target", Key: "entity"},
+	{Header: "User", Field: "user"},
+	{Header: "State", Field: "state"},
+	{Header: "Progress", Field: "progress", Kind: filter.Number},
+	{Header: "Queued", Field: "queued"},
+	{Header: "Started", Field: "started"},
+	{Header: "Completed", Field: "completed"},
+	{Header: "Result", Field: "result"},
+}
+
+var taskCancelColumns = []Column{
+	{Header: "ID", Field: "id"},
+	{Header: "Action", Field: "action"},
+	{Header: "Status", Field: "status"},
+}
+
+// columns shown by 'task list' without -cols
+var taskListDefaults = []string{"id", "task", "entity", "user", "state", "progress", "queued", "result"}
+
+func (c *TaskCommand) Execute(v *Vcli, args ...string) (*Result, error) {
+	if len(args) > 0 {
+		cmd := args[0]
+		options := args[1:]
+		if fn, ok := taskCommands[cmd]; ok {
+			t, err := fn.Execute(v, options...)
+			return t, err
+		}
+		return nil, fmt.Errorf("Unknown subcommand '%s' for task", cmd)
+	}
+	return UsageResult(c.Usage()), nil
+}
+
+func (c *TaskCommand) Usage() string {
+	return `Usage: task [command]
+
+Commands:
+  list      List the recent tasks of vCenter
+  watch     Follow the progress of running tasks
+  cancel    Cancel running tasks`
+}
+
+// taskQuery selects the tasks of 'task list' and 'task watch'
+type taskQuery struct {
+	running bool
+	entity  *InventoryObject
+	user    string
+	max     int
+}
+
+// taskQueryFlags adds the options selecting tasks to the flags of a command
+type taskQueryFlags struct {
+	running *bool
+	entity  *string
+	user    *bool
+}
+
+func newTaskQueryFlags(fs *flag.FlagSet, running bool) *taskQueryFlags {
+	f := &taskQueryFlags{
+		entity: fs.String("entity", "", "Tasks of an inventory object and the objects below it"),
+		user:   fs.Bool("user", false, "Tasks started by the logged in user"),
+	}
+	if running {
+		f.running = fs.Bool("running", false, "Queued and running tasks only")
+	}
+	return f
+}
+
+// Query looks up the entity and the user of the options
+func (f *taskQueryFlags) Query(cli *Vcli) (*taskQuery, error) {
+	q := &taskQuery{max: TASK_HISTORY_PAGE}
+	if f.running != nil {
+		q.running = *f.running
+	}
+
+	if *f.entity != "" {
+		entity, err := findEntity(cli, *f.entity)
+		if err != nil {
+			return nil, err
+		}
+		q.entity = entity
+	}
+
+	if *f.user {
+		s, err := session.NewManager(cli.client.Client).UserSession(cli.ctx)
+		if err != nil {
+			return nil, err
+		}
+		if s == nil {
+			return nil, errors.New("Not logged in")
+		}
+		q.user = s.UserName
+	}
+	return q, nil
+}
+
+// spec returns the filter of the task history collector
+func (q *taskQuery) spec() types.TaskFilterSpec {
+	var spec types.TaskFilterSpec
+	if q.running {
+		spec.State = []types.TaskInfoState{types.TaskInfoStateQueued, types.TaskInfoStateRunning}
+	}
+	if q.entity != nil {
+		spec.Entity = &types.TaskFilterSpecByEntity{
+			Entity:    q.entity.Ref,
+			Recursion: types.TaskFilterSpecRecursionOptionAll,
+		}
+	}
+	if q.user != "" {
+		spec.UserName = &types.TaskFilterSpecByUsername{UserList: []string{q.user}}
+	}
+	return spec
+}
+
+// match applies the query to a task, for the recent tasks which can't be
+// filtered by vCenter
+func (q *taskQuery) match(cli *Vcli, info *types.TaskInfo) bool {
+	if q.running && !isTaskActive(info) {
+		return false
+	}
+	if q.entity != nil {
+		if info.Entity == nil {
+			return false
+		}
+		if *info.Entity != q.entity.Ref && !strings.HasPrefix(cli.inventory.Path(*info.Entity), q.entity.Path+"/") {
+			return false
+		}
+	}
+	if q.user != "" && !strings.EqualFold(taskUser(info), q.user) {
+		return false
+	}
+	return true
+}
+
+func (cmd *TaskListCommand) Usage() string {
+	return `Usage: task list [options]
+
+List the recent tasks of vCenter, the newest first
+
+Options:
+  -running          Show only queued and running tasks
+  -entity=name      Show only the tasks of an inventory object and the objects
+                    below it, e.g. a cluster, host, folder or VM
+  -user             Show only the tasks started by the logged in user
+` + listOptionsHelp + `
+
+Columns:
+  id, task, entity, user, state, progress, queued, started, completed, result
+  (default: ` + strings.Join(taskListDefaults, ",") + `)
+
+` + filterHelp + `
+
+Examples:
+  task list -running
+  task list -entity BLR-EDGE -limit 20
+  task list -user -filter 'state==error'
+`
+}
+
+func (cmd *TaskListCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	listCmd := flag.NewFlagSet("list", flag.ContinueOnError)
+	queryFlags := newTaskQueryFlags(listCmd, true)
+	opts := newListFlags(listCmd)
+	names, err := parseFlags(listCmd, args)
+	if err != nil || len(names) > 0 {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	q, err := queryFlags.Query(cli)
+	if err != nil {
+		return nil, err
+	}
+	if *opts.limit > q.max {
+		q.max = *opts.limit
+	}
+
+	tasks, err := getTasks(cli, q)
+	if err != nil {
+		return nil, err
+	}
+
+	tbl := NewTable(taskColumns...)
+	for i := range tasks {
+		tbl.AddRow(taskRow(cli, &tasks[i])...)
+	}
+
+	tbl, err = opts.Apply(tbl, taskListDefaults)
+	if err != nil {
+		return nil, err
+	}
+	return TableResult(tbl), nil
+}
+
+func (cmd *TaskWatchCommand) Usage() string {
+	return `Usage: task watch [options] [ID1,ID2, ...]
+
+Follow the progress of tasks until they have completed, Ctrl-C stops
+watching. Without IDs the tasks running at the moment are followed.
+
+Options:
+  -entity=name      Follow the running tasks of an inventory object and the
+                    objects below it
+  -user             Follow the running tasks of the logged in user
+
+Examples:
+  task watch
+  task watch task-1234
+  task watch -entity BLR-EDGE
+`
+}
+
+func (cmd *TaskWatchCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	watchCmd := flag.NewFlagSet("watch", flag.ContinueOnError)
+	queryFlags := newTaskQueryFlags(watchCmd, false)
+	ids, err := parseFlags(watchCmd, args)
+	if err != nil {
+		return UsageResult(cmd.Usage()), nil
+	}
+
+	var refs []types.ManagedObjectReference
+	if len(ids) > 0 {
+		refs, err = parseTaskIds(ids)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		q, err := queryFlags.Query(cli)
+		if err != nil {
+			return nil, err
+		}
+		q.running = true
+		tasks, err := getTasks(cli, q)
+		if err != nil {
+			return nil, err
+		}
+		if len(tasks) == 0 {
+			return MessageResult("No running tasks"), nil
+		}
+		for _, t := range tasks {
+			refs = append(refs, t.Task)
+		}
+	}
+
+	tasks, err := watchTasks(cli, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	tbl := NewTable(taskColumns...)
+	for i := range tasks {
+		tbl.AddRow(taskRow(cli, &tasks[i])...)
+	}
+	return TableResult(tbl), nil
+}
+
+func (cmd *TaskCancelCommand) Usage() string {
+	return `Usage: task cancel ID1[,ID2, ...]
+
+Cancel running tasks. Only tasks vCenter reports as cancelable can be
+cancelled, 'task list -running' shows the IDs.
+
+Examples:
+  task cancel task-1234
+  task cancel task-1234,task-1235
+`
+}
+
+func (cmd *TaskCancelCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	if len(args) == 0 {
+		return UsageResult(cmd.Usage()), nil
+	}
+	refs, err := parseTaskIds(args)
+	if err != nil {
+		return nil, err
+	}
+
+	r := TableResult(NewTable(taskCancelColumns...))
+	for _, ref := range refs {
+		if err := object.NewTask(cli.client.Client, ref).Cancel(cli.ctx); err != nil {
+			r.Table.AddRow(ref.Value, "Cancel", "failed")
+			r.AddError(fmt.Errorf("Failed to cancel task '%s': %s", ref.Value, err))
+			continue
+		}
+		r.Table.AddRow(ref.Value, "Cancel", "requested")
+	}
+	return r, nil
+}
+
+// parseTaskIds returns the tasks of a comma separated list of IDs like
+// task-1234
+func parseTaskIds(ids []string) ([]types.ManagedObjectReference, error) {
+	var refs []types.ManagedObjectReference
+	for _, id := range strings.Split(strings.Join(ids, ","), ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		var ref types.ManagedObjectReference
+		if !ref.FromString(id) {
+			ref = types.ManagedObjectReference{Type: "Task", Value: id}
+		}
+		if ref.Type != "Task" {
+			return nil, fmt.Errorf("'%s' is not a task ID", id)
+		}
+		refs = append(refs, ref)
+	}
+	if len(refs) == 0 {
+		return nil, errors.New("No task IDs given")
+	}
+	return refs, nil
+}
+
+// getTasks returns the tasks of a query, the newest first. The tasks are
+// read from a task history collector, or from the recent tasks of the
+// task manager if the server doesn't support the collector.
+func getTasks(cli *Vcli, q *taskQuery) ([]types.TaskInfo, error) {
+	ctx := cli.ctx
+	c := cli.client.Client
+
+	if err := loadTaskTargets(cli); err != nil {
+		return nil, err
+	}
+
+	var tasks []types.TaskInfo
+	res, err := methods.CreateCollectorForTasks(ctx, c, &types.CreateCollectorForTasks{
+		This:   *c.ServiceContent.TaskManager,
+		Filter: q.spec(),
+	})
+	switch {
+	case err == nil:
+		collector := res.Returnval
+		// collectors are limited per session, they are destroyed even if the
+		// command has been cancelled
+		defer methods.DestroyCollector(context.Background(), c, &types.DestroyCollector{This: collector})
+
+		_, err = methods.SetCollectorPageSize(ctx, c, &types.SetCollectorPageSize{This: collector, MaxCount: int32(q.max)})
+		if err != nil {
+			return nil, err
+		}
+		var hc mo.TaskHistoryCollector
+		if err := property.DefaultCollector(c).RetrieveOne(ctx, collector, []string{"latestPage"}, &hc); err != nil {
+			return nil, err
+		}
+		tasks = hc.LatestPage
+	case isNotSupported(err):
+		tasks, err = getRecentTasks(cli)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	var matched []types.TaskInfo
+	for i := range tasks {
+		if q.match(cli, &tasks[i]) {
+			matched = append(matched, tasks[i])
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].QueueTime.After(matched[j].QueueTime)
+	})
+	if len(matched) > q.max {
+		matched = matched[:q.max]
+	}
+	return matched, nil
+}
+
+// getRecentTasks returns the recent tasks of the task manager
+func getRecentTasks(cli *Vcli) ([]types.TaskInfo, error) {
+	ctx := cli.ctx
+	c := cli.client.Client
+	pc := property.DefaultCollector(c)
+
+	var tm mo.TaskManager
+	if err := pc.RetrieveOne(ctx, *c.ServiceContent.TaskManager, []string{"recentTask"}, &tm); err != nil {
+		return nil, err
+	}
+	if len(tm.RecentTask) == 0 {
+		return nil, nil
+	}
+
+	var tasks []mo.Task
+	if err := pc.Retrieve(ctx, tm.RecentTask, []string{"info"}, &tasks); err != nil {
+		return nil, err
+	}
+	infos := make([]types.TaskInfo, 0, len(tasks))
+	for _, t := range tasks {
+		infos = append(infos, t.Info)
+	}
+	return infos, nil
+}
+
+// watchTasks shows the progress of tasks until all of them have completed
+// and returns their final state. On a terminal the tasks are shown as a
+// table updated in place, otherwise every change is printed as a line.
+func watchTasks(cli *Vcli, refs []types.ManagedObjectReference) ([]types.TaskInfo, error) {
+	ctx := cli.ctx
+	c := cli.client.Client
+
+	if err := loadTaskTargets(cli); err != nil {
+		return nil, err
+	}
+
+	// unknown IDs are reported before watching
+	for _, ref := range refs {
+		var t mo.Task
+		if err := property.DefaultCollector(c).RetrieveOne(ctx, ref, []string{"info.state"}, &t); err != nil {
+			if isManagedObjectNotFound(err) {
+				return nil, fmt.Errorf("Task '%s' not found", ref.Value)
+			}
+			return nil, err
+		}
+	}
+
+	wf := new(property.WaitFilter)
+	wf.Spec.PropSet = []types.PropertySpec{{Type: "Task", PathSet: []string{"info"}}}
+	for _, ref := range refs {
+		wf.Spec.ObjectSet = append(wf.Spec.ObjectSet, types.ObjectSpec{Obj: ref})
+	}
+
+	infos := make(map[types.ManagedObjectReference]types.TaskInfo, len(refs))
+	view := newTaskView(cli)
+	err := property.WaitForUpdates(ctx, property.DefaultCollector(c), wf, func(updates []types.ObjectUpdate) bool {
+		for _, update := range updates {
+			for _, change := range update.ChangeSet {
+				if info, ok := change.Val.(types.TaskInfo); ok {
+					infos[update.Obj] = info
+				}
+			}
+		}
+
+		tasks := make([]types.TaskInfo, 0, len(refs))
+		done := true
+		for _, ref := range refs {
+			if info, ok := infos[ref]; ok {
+				tasks = append(tasks, info)
+				done = done && !isTaskActive(&info)
+			} else {
+				done = false
+			}
+		}
+		view.Show(tasks)
+		return done
+	})
+	view.Clear()
+	if err == nil {
+		// a cancelled wait returns without an error
+		err = ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make([]types.TaskInfo, 0, len(refs))
+	for _, ref := range refs {
+		tasks = append(tasks, infos[ref])
+	}
+	return tasks, nil
+}
+
+// taskView shows the progress of watched tasks
+type taskView struct {
+	// live is set when the tasks are redrawn in place on a terminal
+	live bool
+	// lines of the table drawn last
+	lines int
+	// last progress line printed per task
+	last map[string]string
+	cli  *Vcli
+}
+
+func newTaskView(cli *Vcli) *taskView {
+	return &taskView{
+		cli:  cli,
+		live: OutputFormat == OUTPUT_TABLE && isatty.IsTerminal(os.Stdout.Fd()),
+		last: make(map[string]string),
+	}
+}
+
+func (v *taskView) Show(tasks []types.TaskInfo) {
+	if !v.live {
+		for i := range tasks {
+			t := &tasks[i]
+			line := fmt.Sprintf("%s %s '%s': %s", t.Task.Value, taskName(t), taskTarget(v.cli, t), t.State)
+			if isTaskActive(t) {
+				line += fmt.Sprintf(" %d%%", t.Progress)
+			}
+			if v.last[t.Task.Value] != line {
+				v.last[t.Task.Value] = line
+				Progress("%s", line)
+			}
+		}
+		return
+	}
+
+	tbl := NewTable(taskColumns...)
+	for i := range tasks {
+		tbl.AddRow(taskRow(v.cli, &tasks[i])...)
+	}
+	out, err := tbl.Render(OUTPUT_TABLE)
+	if err != nil {
+		return
+	}
+	Spinner.Stop()
+	v.Clear()
+	os.Stdout.Write(out)
+	v.lines = bytes.Count(out, []byte("\n"))
+}
+
+// Clear removes the table drawn last from the terminal
+func (v *taskView) Clear() {
+	if v.lines > 0 {
+		fmt.Printf("\x1b[%dA\x1b[J", v.lines)
+		v.lines = 0
+	}
+}
+
+func taskRow(cli *Vcli, info *types.TaskInfo) []interface{} {
+	var progress interface{}
+	switch {
+	case isTaskActive(info):
+		progress = NewCell(fmt.Sprintf("%d%%", info.Progress), info.Progress)
+	case info.State == types.TaskInfoStateSuccess:
+		progress = NewCell("100%", int32(100))
+	}
+
+	var result string
+	switch {
+	case info.State == types.TaskInfoStateError && info.Error != nil:
+		result = strings.TrimSuffix(info.Error.LocalizedMessage, ".")
+	case info.State == types.TaskInfoStateSuccess && info.StartTime != nil && info.CompleteTime != nil:
+		result = info.CompleteTime.Sub(*info.StartTime).Round(time.Millisecond).String()
+	}
+
+	return []interface{}{
+		info.Task.Value,
+		taskName(info),
+		taskTarget(cli, info),
+		taskUser(info),
+		string(info.State),
+		progress,
+		timeCell(&info.QueueTime),
+		timeCell(info.StartTime),
+		timeCell(info.CompleteTime),
+		result,
+	}
+}
+
+func timeCell(t *time.Time) interface{} {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	return NewCell(t.Local().Format("2006-01-02 15:04:05"), *t)
+}
+
+// taskName describes a task by its method, e.g. PowerOffVM or
+// VirtualMachine.Destroy
+func taskName(info *types.TaskInfo) string {
+	name := strings.TrimSuffix(info.Name, "_Task")
+	switch name {
+	case "":
+		return info.DescriptionId
+	case "Destroy", "Rename":
+		if info.Entity != nil {
+			return info.Entity.Type + "." + name
+		}
+	}
+	return name
+}
+
+// loadTaskTargets waits for the inventory cache, which gives the names of
+// the objects of the tasks
+func loadTaskTargets(cli *Vcli) error {
+	_, err := cli.inventory.Objects(cli.ctx, cli, INVENTORY_DATACENTER)
+	return err
+}
+
+// taskTarget returns the name of the object of a task, from the inventory
+// cache if the server only gives its ID
+func taskTarget(cli *Vcli, info *types.TaskInfo) string {
+	if info.Entity != nil && (info.EntityName == "" || info.EntityName == info.Entity.Value) {
+		if name := cli.inventory.Name(info.Entity); name != "" {
+			return name
+		}
+	}
+	return info.EntityName
+}
+
+// taskUser returns who started a task
+func taskUser(info *types.TaskInfo) string {
+	switch r := info.Reason.(type) {
+	case *types.TaskReasonUser:
+		return r.UserName
+	case *types.TaskReasonSchedule:
+		return "Scheduled task " + r.Name
+	case *types.TaskReasonAlarm:
+		return "Alarm " + r.AlarmName
+	case *types.TaskReasonSystem:
+		return "System"
+	}
+	return ""
+}
+
+func isTaskActive(info *types.TaskInfo) bool {
+	return info.State == types.TaskInfoStateQueued || info.State == types.TaskInfoStateRunning
+}
+
+// findEntity looks up an inventory object by name, inventory path or
+// managed object ID in the inventory cache
+func findEntity(cli *Vcli, name string) (*InventoryObject, error) {
+	kinds := []string{INVENTORY_VM, INVENTORY_HOST, INVENTORY_CLUSTER, INVENTORY_DATACENTER, INVENTORY_DATASTORE, INVENTORY_FOLDER}
+	var found []*InventoryObject
+	for _, kind := range kinds {
+		objects, err := cli.inventory.Objects(cli.ctx, cli, kind)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range objects {
+			if o.Name == name || o.Path == name || o.Ref.Value == name {
+				found = append(found, o)
+			}
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("'%s' not found", name)
+	case 1:
+		return found[0], nil
+	}
+	var paths []string
+	for _, o := range found {
+		paths = append(paths, o.Path)
+	}
+	return nil, fmt.Errorf("'%s' matches %s, use the inventory path", name, strings.Join(paths, ", "))
+}
+
+// isNotSupported reports whether a call failed because the server doesn't
+// implement the method, e.g. the task history on the simulator
+func isNotSupported(err error) bool {
+	if !soap.IsSoapFault(err) {
+		return false
+	}
+	switch soap.ToSoapFault(err).VimFault().(type) {
+	case types.MethodNotFound, *types.MethodNotFound, types.NotSupported, *types.NotSupported, types.NotImplemented, *types.NotImplemented:
+		return true
+	}
+	return false
+}
+
+func isManagedObjectNotFound(err error) bool {
+	if !soap.IsSoapFault(err) {
+		return false
+	}
+	switch soap.ToSoapFault(err).VimFault().(type) {
+	case types.ManagedObjectNotFound, *types.ManagedObjectNotFound:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"github.com/vmware/govmomi/vim25/soap"
+	"sync/atomic"
+	"time"
+)
+
+// option of every command to print its round trips and elapsed time
+const TIMING_OPTION = "-timing"
+
+// Timing counts the round trips of a command to vCenter and HX controllers
+type Timing struct {
+	start   time.Time
+	vcenter int64
+	hx      int64
+}
+
+type timingKey struct{}
+
+// getTiming removes the -timing option from the arguments of a command,
+// the Timing is nil without it
+func getTiming(args []string) (*Timing, []string) {
+	var timing *Timing
+	rest := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == TIMING_OPTION {
+			timing = &Timing{start: time.Now()}
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return timing, rest
+}
+
+// withTiming returns a context whose round trips are counted by timing
+func withTiming(ctx context.Context, timing *Timing) context.Context {
+	if timing == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, timingKey{}, timing)
+}
+
+func timingFrom(ctx context.Context) *Timing {
+	timing, _ := ctx.Value(timingKey{}).(*Timing)
+	return timing
+}
+
+// countHxRequest counts an HTTP request to an HX controller
+func countHxRequest(ctx context.Context) {
+	if timing := timingFrom(ctx); timing != nil {
+		atomic.AddInt64(&timing.hx, 1)
+	}
+}
+
+func (t *Timing) Print() {
+	Progress("Round trips: %d vCenter, %d HX; elapsed %s",
+		atomic.LoadInt64(&t.vcenter), atomic.LoadInt64(&t.hx), time.Since(t.start).Round(time.Millisecond))
+}
+
+// timingRoundTripper counts the vCenter calls made with a context of a
+// command run with -timing
+type timingRoundTripper struct {
+	soap.RoundTripper
+}
+
+func (rt *timingRoundTripper) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	if timing := timingFrom(ctx); timing != nil {
+		atomic.AddInt64(&timing.vcenter, 1)
+	}
+	return rt.RoundTripper.RoundTrip(ctx, req, res)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const KNOWN_HOSTS_FILE = "known_hosts"
+
+// TrustSettings decides which server certificates vcli accepts. A
+// certificate is trusted if it chains to a trusted CA, or if its SHA-1 or
+// SHA-256 thumbprint is pinned for the host, either explicitly or in the
+// known_hosts trust store.
+type TrustSettings struct {
+	// Insecure skips all verification
+	Insecure bool
+	// RootCAs replaces the system CA pool if set
+	RootCAs *x509.CertPool
+	// pins holds explicit thumbprints per host:port, which must match
+	pins map[string]string
+	// hostCAs and insecureHosts are settings of single connections
+	hostCAs       map[string]*x509.CertPool
+	insecureHosts map[string]bool
+	// TrustOnFirstUse asks to trust and remember unknown certificates
+	TrustOnFirstUse bool
+
+	mu sync.Mutex
+}
+
+var Trust = &TrustSettings{
+	pins:          make(map[string]string),
+	hostCAs:       make(map[string]*x509.CertPool),
+	insecureHosts: make(map[string]bool),
+}
+
+// LoadCAFile reads custom CA bundles, multiple files are separated like PATH
+func (t *TrustSettings) LoadCAFile(file string) error {
+	pool, err := readCAFile(file)
+	if err != nil {
+		return err
+	}
+	t.RootCAs = pool
+	return nil
+}
+
+// SetHostCAFile verifies the certificate of host with custom CA bundles
+func (t *TrustSettings) SetHostCAFile(host string, file string) error {
+	pool, err := readCAFile(file)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.hostCAs[hostPort(host)] = pool
+	return nil
+}
+
+// SkipVerify accepts any certificate of host
+func (t *TrustSettings) SkipVerify(host string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.insecureHosts[hostPort(host)] = true
+}
+
+func readCAFile(file string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, name := range filepath.SplitList(file) {
+		pem, err := ioutil.ReadFile(filepath.Clean(name))
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No valid CA certificate found in '%s'", name)
+		}
+	}
+	return pool, nil
+}
+
+// Pin requires the certificate of host to have the given thumbprint
+func (t *TrustSettings) Pin(host string, thumbprint string) error {
+	if !thumbprintMatch.MatchString(thumbprint) {
+		return fmt.Errorf("Invalid thumbprint '%s', expected SHA-1 or SHA-256 hex pairs separated by colons", thumbprint)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pins[hostPort(host)] = strings.ToUpper(thumbprint)
+	return nil
+}
+
+// hostPin is a thumbprint pinned for a host with -pin or the pins of a
+// profile
+type hostPin struct {
+	host       string
+	thumbprint string
+}
+
+// pinList collects the repeatable -pin host=thumbprint option
+type pinList []hostPin
+
+func (l *pinList) String() string {
+	pins := make([]string, 0, len(*l))
+	for _, p := range *l {
+		pins = append(pins, p.host+"="+p.thumbprint)
+	}
+	return strings.Join(pins, ",")
+}
+
+func (l *pinList) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+		return fmt.Errorf("expected host=thumbprint, got '%s'", value)
+	}
+	thumbprint := strings.TrimSpace(kv[1])
+	if !thumbprintMatch.MatchString(thumbprint) {
+		return fmt.Errorf("invalid thumbprint '%s', expected SHA-1 or SHA-256 hex pairs separated by colons", thumbprint)
+	}
+	*l = append(*l, hostPin{strings.TrimSpace(kv[0]), thumbprint})
+	return nil
+}
+
+// TLSConfig returns the client TLS configuration for connections to host
+func (t *TrustSettings) TLSConfig(host string) *tls.Config {
+	addr := hostPort(host)
+	t.mu.Lock()
+	insecure := t.Insecure || t.insecureHosts[addr]
+	t.mu.Unlock()
+	if insecure {
+		return &tls.Config{InsecureSkipVerify: true}
+	}
+
+	// the standard verification can't fall back to thumbprints, so it
+	// is done in VerifyPeerCertificate instead
+	return &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return t.verify(addr, rawCerts)
+		},
+	}
+}
+
+func (t *TrustSettings) verify(addr string, rawCerts [][]byte) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("%s presented no certificate", addr)
+	}
+
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+	leaf := certs[0]
+
+	t.mu.Lock()
+	pin := t.pins[addr]
+	roots := t.RootCAs
+	if pool, ok := t.hostCAs[addr]; ok {
+		roots = pool
+	}
+	t.mu.Unlock()
+	if pin != "" {
+		if !thumbprintEqual(leaf, pin) {
+			return fmt.Errorf("Certificate thumbprint of %s doesn't match the pinned thumbprint %s", addr, pin)
+		}
+		return nil
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+	_, verifyErr := leaf.Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	if verifyErr == nil {
+		return nil
+	}
+
+	// serialize lookups and prompts, e.g. for HX clusters queried in parallel
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	known, err := readKnownHosts()
+	if err != nil {
+		return err
+	}
+	if thumbprint, ok := known[addr]; ok {
+		if !thumbprintEqual(leaf, thumbprint) {
+			return fmt.Errorf("Certificate of %s has changed, got %s instead of %s. Remove the entry from %s if the change is expected",
+				addr, thumbprintSHA256(leaf), thumbprint, knownHostsPath())
+		}
+		return nil
+	}
+
+	if !t.TrustOnFirstUse || !canPrompt() {
+		return fmt.Errorf("Certificate of %s is not trusted (%s), SHA-256 thumbprint %s. Use -ca-file, -thumbprint, -pin or -k",
+			addr, verifyErr, thumbprintSHA256(leaf))
+	}
+
+	if !askTrust(addr, leaf, verifyErr) {
+		return fmt.Errorf("Certificate of %s is not trusted", addr)
+	}
+	return addKnownHost(addr, thumbprintSHA256(leaf))
+}
+
+// askTrust shows an untrusted certificate and asks whether to trust it
+func askTrust(addr string, cert *x509.Certificate, reason error) bool {
+	defer lockPrompt()()
+
+	warn := color.New(color.FgYellow)
+	warn.Fprintf(os.Stderr, "The certificate of %s is not trusted: %s\n", addr, reason)
+	fmt.Fprintf(os.Stderr, "  Subject:  %s\n", cert.Subject)
+	fmt.Fprintf(os.Stderr, "  Issuer:   %s\n", cert.Issuer)
+	fmt.Fprintf(os.Stderr, "  Expires:  %s\n", cert.NotAfter.Local().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(os.Stderr, "  SHA-256:  %s\n", thumbprintSHA256(cert))
+	fmt.Fprintf(os.Stderr, "  SHA-1:    %s\n", thumbprintSHA1(cert))
+	warn.Fprint(os.Stderr, "Trust this certificate and remember it? [y/N]: ")
+
+	answer, _ := stdinReader.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func thumbprintSHA1(cert *x509.Certificate) string {
+	sum := sha1.Sum(cert.Raw)
+	return hexPairs(sum[:])
+}
+
+func thumbprintSHA256(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hexPairs(sum[:])
+}
+
+func hexPairs(sum []byte) string {
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, ":")
+}
+
+// thumbprintEqual compares a SHA-1 or SHA-256 thumbprint with a certificate
+func thumbprintEqual(cert *x509.Certificate, thumbprint string) bool {
+	thumbprint = strings.ToUpper(thumbprint)
+	return thumbprint == thumbprintSHA256(cert) || thumbprint == thumbprintSHA1(cert)
+}
+
+// hostPort adds the default https port to a host name
+func hostPort(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), "443")
+}
+
+func knownHostsPath() string {
+	return filepath.Join(configDir(), KNOWN_HOSTS_FILE)
+}
+
+// readKnownHosts reads the trust store, one 'host:port thumbprint' per
+// line. Blank lines and lines starting with '#' are skipped.
+func readKnownHosts() (map[string]string, error) {
+	known := make(map[string]string)
+	f, err := os.Open(knownHostsPath())
+	if os.IsNotExist(err) {
+		return known, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !thumbprintMatch.MatchString(fields[1]) {
+			continue
+		}
+		known[hostPort(fields[0])] = strings.ToUpper(fields[1])
+	}
+	return known, scanner.Err()
+}
+
+func addKnownHost(addr string, thumbprint string) error {
+	path := knownHostsPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = fmt.Fprintf(f, "%s %s\n", addr, thumbprint); err != nil {
+		return errors.New("Failed to save the certificate thumbprint: " + err.Error())
+	}
+	return nil
+}
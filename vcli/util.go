@@ -1,15 +1,13 @@
 package main
 
 import (
+	"fmt"
 	"github.com/fatih/color"
 	_ "github.com/mattn/go-colorable"
+	"os"
+	"sync"
 )
 
-type KeyValue struct {
-	key   string
-	value string
-}
-
 var (
 	Error   = color.New(color.FgRed).PrintfFunc()
 	ErrorSp = color.New(color.FgRed).SprintFunc()
@@ -33,6 +31,24 @@ var (
 		}
 	}
 
+	// Progress streams status lines of long running commands while the
+	// spinner is active. With a machine-readable output format the lines
+	// go to stderr.
+	Progress = func(format string, args ...interface{}) {
+		progressLock.Lock()
+		defer progressLock.Unlock()
+		msg := fmt.Sprintf(format, args...)
+		if OutputFormat != OUTPUT_TABLE {
+			fmt.Fprintln(os.Stderr, msg)
+		} else if Spinner.Active() {
+			Spinner.Stop()
+			color.New(color.FgHiWhite).Println(msg)
+			Spinner.Start()
+		} else {
+			color.New(color.FgHiWhite).Println(msg)
+		}
+	}
+
 	Warn      = color.New(color.FgYellow).PrintFunc()
 	Warnln    = color.New(color.FgYellow).PrintlnFunc()
 	Info      = color.New(color.FgHiWhite).PrintFunc()
@@ -57,4 +73,6 @@ var (
 	W  = color.New(color.FgWhite)
 	T  = color.New(color.Bold, color.FgCyan)
 	HW = color.New(color.FgHiWhite)
+
+	progressLock sync.Mutex
 )
@@ -2,15 +2,14 @@ package main
 
 import (
 	"context"
-	"errors"
 	"flag"
 	"fmt"
-	"github.com/tatsushid/go-prettytable"
+	"github.com/go/vcli/filter"
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/property"
-	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25/methods"
 	"github.com/vmware/govmomi/vim25/mo"
-	_ "regexp"
+	"github.com/vmware/govmomi/vim25/types"
 	"strconv"
 	"strings"
 	"sync"
@@ -23,7 +22,11 @@ type VmInfoCommand struct{}
 type VmListCommand struct{}
 type VmPowerOffCommand struct{}
 type VmPowerOnCommand struct{}
+type VmRebootCommand struct{}
 type VmResetCommand struct{}
+type VmShutdownCommand struct{}
+type VmStandbyCommand struct{}
+type VmSuspendCommand struct{}
 
 const (
 	VM_DESTROY  = "destroy"
@@ -31,19 +34,34 @@ const (
 	VM_LIST     = "list"
 	VM_POWEROFF = "poweroff"
 	VM_POWERON  = "poweron"
+	VM_REBOOT   = "reboot"
 	VM_RESET    = "reset"
+	VM_SHUTDOWN = "shutdown"
+	VM_SNAPSHOT = "snapshot"
+	VM_STANDBY  = "standby"
+	VM_SUSPEND  = "suspend"
 )
 
+// seconds to wait for a guest shutdown to power off the VM
+const defaultShutdownTimeout = 300
+
 var vmCommands = map[string]Command{
+	VM_CLONE:    &VmCloneCommand{},
+	VM_DEPLOY:   &VmDeployCommand{},
 	VM_DESTROY:  &VmDestroyCommand{},
 	VM_INFO:     &VmInfoCommand{},
 	VM_LIST:     &VmListCommand{},
 	VM_POWEROFF: &VmPowerOffCommand{},
 	VM_POWERON:  &VmPowerOnCommand{},
+	VM_REBOOT:   &VmRebootCommand{},
 	VM_RESET:    &VmResetCommand{},
+	VM_SHUTDOWN: &VmShutdownCommand{},
+	VM_SNAPSHOT: &VmSnapshotCommand{},
+	VM_STANDBY:  &VmStandbyCommand{},
+	VM_SUSPEND:  &VmSuspendCommand{},
 }
 
-// type vmActionFunc func(string, context.Context) (*mo.Task, error)
+type vmActionFunc func(context.Context, *object.VirtualMachine) error
 
 type vmAction struct {
 	action             string
@@ -54,62 +72,103 @@ var vmActions = map[string]vmAction{
 	VM_DESTROY:  vmAction{"Destroy", "Destroying"},
 	VM_POWEROFF: vmAction{"PowerOff", "Powering off"},
 	VM_POWERON:  vmAction{"PowerOn", "Powering on"},
+	VM_REBOOT:   vmAction{"Reboot", "Rebooting guest of"},
 	VM_RESET:    vmAction{"Reset", "Resetting"},
+	VM_SHUTDOWN: vmAction{"Shutdown", "Shutting down guest of"},
+	VM_STANDBY:  vmAction{"Standby", "Putting in standby"},
+	VM_SUSPEND:  vmAction{"Suspend", "Suspending"},
 }
 
-func (c *VmCommand) Execute(v *Vcli, args ...string) (*prettytable.Table, error) {
+func (c *VmCommand) Execute(v *Vcli, args ...string) (*Result, error) {
 	if len(args) > 0 {
 		cmd := args[0]
 		options := args[1:]
 		if fn, ok := vmCommands[cmd]; ok {
 			t, err := fn.Execute(v, options...)
 			return t, err
-		} else {
-			Error("Unknown subcommand '%s' for vm\n", cmd)
 		}
-		return nil, nil
+		return nil, fmt.Errorf("Unknown subcommand '%s' for vm", cmd)
 	}
-	Usage(c.Usage())
-	return nil, nil
+	return UsageResult(c.Usage()), nil
 }
 
 func (c *VmCommand) Usage() string {
 	return `Usage: vm [command]
 
 Commands:
+  clone        Clone a VM or template
+  deploy       Deploy VM(s) from a template
   destroy      Destroy VM(s)
   info         Display summary info of VM(s)
   list         List all VMs
   poweroff     Power off VM(s)
   poweron      Power on VM(s)
+  reboot       Reboot guest OS of VM(s)
   reset        Reset VM(s)
+  shutdown     Shut down guest OS of VM(s)
+  snapshot     Manage snapshots of VM(s)
+  standby      Put guest OS of VM(s) in standby
+  suspend      Suspend VM(s)
 `
 }
 
-func (cmd *VmListCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table, error) {
-	ctx := cli.ctx
-	c := cli.client.Client
-	pc := property.DefaultCollector(c)
-	m := view.NewManager(c)
-	v, err := m.CreateContainerView(ctx, c.ServiceContent.RootFolder, []string{"VirtualMachine"}, true)
-	if err != nil {
-		return nil, err
-	}
+// columns of 'vm list', the keys are given to -cols and -sort
+var vmListColumns = []Column{
+	{Header: "#", Field: "index"},
+	{Header: "Name", Field: "name"},
+	{Header: "IP Address", Field: "ip_address", Key: "ip"},
+	{Header: "State", Field: "state"},
+	{Header: "Folder", Field: "folder"},
+	{Header: "CPUs", Field: "cpus", Key: "cpu", Kind: filter.Number},
+	{Header: "Memory", Field: "memory_bytes", Key: "mem", Kind: filter.Size},
+	{Header: "Host", Field: "host"},
+	{Header: "Cluster", Field: "cluster"},
+	{Header: "Datastore", Field: "datastores", Key: "datastore"},
+	{Header: "OS", Field: "guest_os", Key: "os"},
+	{Header: "Uptime", Field: "uptime_secs", Key: "uptime", Kind: filter.Duration},
+}
+
+// columns shown by 'vm list' without -cols
+var vmListDefaults = []string{"name", "ip", "state", "folder"}
+
+func (cmd *VmListCommand) Usage() string {
+	return `Usage: vm list [options]
+
+List all VMs. The # of a VM stays the same whatever the sort order, it
+can be given to the other vm commands.
+
+Options:
+  -grep=pattern     Show only the VMs whose name, IP address or folder
+                    contains pattern
+` + listOptionsHelp + `
+
+Columns:
+  name, ip, state, folder, cpu, mem, host, cluster, datastore, os, uptime
+  (default: ` + strings.Join(vmListDefaults, ",") + `)
 
-	var filter string
+` + filterHelp + `
+
+Examples:
+  vm list -grep web
+  vm list -filter 'state==poweredOn && mem>=8GB && name=~"^hx"'
+  vm list -filter '!ip || datastore==nfs-*' -cols name,state,datastore
+  vm list -cols name,cpu,mem,host -sort mem:desc -limit 10
+  vm list -sort state,uptime:desc
+`
+}
+
+func (cmd *VmListCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
 	listCmd := flag.NewFlagSet("list", flag.ContinueOnError)
 	listGrep := listCmd.String("grep", "", "Search pattern")
-	listCmd.Parse(args)
-
-	if listGrep != nil {
-		filter = *listGrep
+	opts := newListFlags(listCmd)
+	if _, err := parseFlags(listCmd, args); err != nil {
+		return UsageResult(cmd.Usage()), nil
 	}
+	filter := *listGrep
 
-	// Retrieve summary property for all machines
-	var vms []mo.VirtualMachine
-	props := []string{"summary", "datastore", "network", "parent"}
-	props = append(props, "guest.ipAddress")
-	err = v.Retrieve(ctx, []string{"VirtualMachine"}, props, &vms)
+	// VMs and the names of their folders, hosts, clusters and datastores
+	// come from the inventory cache
+	vms, err := cli.inventory.Objects(cli.ctx, cli, INVENTORY_VM)
 	if err != nil {
 		return nil, err
 	}
@@ -118,272 +177,510 @@ func (cmd *VmListCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table
 		return nil, nil
 	}
 
-	tbl, err := prettytable.NewTable([]prettytable.Column{
-		{Header: "#"},
-		{Header: "Name"},
-		{Header: "IP Address"},
-		{Header: "State"},
-		{Header: "Folder"},
-	}...)
+	hosts, err := cli.inventory.Objects(cli.ctx, cli, INVENTORY_HOST)
+	if err != nil {
+		return nil, err
+	}
+	hostParents := make(map[types.ManagedObjectReference]*types.ManagedObjectReference, len(hosts))
+	for _, h := range hosts {
+		hostParents[h.Ref] = h.Reference("parent")
+	}
+
+	tbl := NewTable(vmListColumns...)
 
-	// tbl.Separator = " | "
 	for index, vm := range vms {
-		var ip string
-		if vm.Guest != nil {
-			ip = vm.Guest.IpAddress
-		}
+		ip := vm.String("guest.ipAddress")
+		folder := cli.inventory.Name(vm.Reference("parent"))
 
-		var folder mo.Folder
-		_ = pc.RetrieveOne(ctx, *vm.Entity().Parent, []string{"name"}, &folder)
+		// apply search filter on vm name or ip address or parent(folder)
+		if filter != "" && !strings.Contains(vm.Name, filter) && !strings.Contains(folder, filter) && !(ip != "" && strings.Contains(ip, filter)) {
+			continue
+		}
 
-		if listGrep != nil {
-			// apply search filter on vm name or ip address or parent(folder)
-			//r, _ := regexp.Compile(filter)
-			//if r.MatchString(vm.Summary.Config.Name) || r.MatchString(ip) || r.MatchString(folder.Name) {
-			if strings.Contains(vm.Summary.Config.Name, filter) || strings.Contains(folder.Name, filter) || (ip != "" && strings.Contains(ip, filter)) {
-				tbl.AddRow(index+1, vm.Summary.Config.Name, ip, string(vm.Summary.Runtime.PowerState), folder.Name)
+		host := vm.Reference("runtime.host")
+		var cluster string
+		if host != nil {
+			if parent := hostParents[*host]; parent != nil && parent.Type == INVENTORY_CLUSTER {
+				cluster = cli.inventory.Name(parent)
 			}
-		} else {
-			tbl.AddRow(index+1, vm.Summary.Config.Name, ip, string(vm.Summary.Runtime.PowerState), folder.Name)
 		}
+
+		var datastores []string
+		for _, ds := range vm.References("datastore") {
+			datastores = append(datastores, cli.inventory.Name(&ds))
+		}
+
+		state := vm.String("runtime.powerState")
+		var uptime interface{}
+		if boot := vm.Time("summary.runtime.bootTime"); !boot.IsZero() && state == string(types.VirtualMachinePowerStatePoweredOn) {
+			secs := int64(time.Since(boot).Seconds())
+			uptime = NewCell(getUptimeString(secs), secs)
+		}
+
+		tbl.AddRow(index+1,
+			vm.Name,
+			ip,
+			state,
+			folder,
+			vm.Int("summary.config.numCpu"),
+			memoryCell(vm.Int("summary.config.memorySizeMB")*1024*1024),
+			cli.inventory.Name(host),
+			cluster,
+			NewCell(strings.Join(datastores, ","), datastores),
+			vm.String("summary.config.guestFullName"),
+			uptime)
 	}
 
-	return tbl, nil
+	tbl, err = opts.Apply(tbl, vmListDefaults)
+	if err != nil {
+		return nil, err
+	}
+	return TableResult(tbl), nil
 }
 
 func (cmd *VmInfoCommand) Usage() string {
-	return `Usage: vm info vm-name OR #
+	return `Usage: vm info [options] vm-name1 [,vm-name2, ...]
+
+Display summary info of VM(s)
+
+` + vmSelectorHelp + `
+
+Options:
+` + vmSelectorOptions + `
 
 Examples:
   vm info Ubuntu-01
   vm info 1
+  vm info 'web-*'
+  vm info /DC1/vm/Team/*
+  vm info -re '^hx-.*-0[1-4]$'
+  vm info -where power=poweredOn,folder=QA
 `
 }
 
-func (cmd *VmInfoCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table, error) {
-	if len(args) <= 0 {
-		Usage(cmd.Usage())
-		return nil, nil
-	}
-
-	vmName := args[0]
-	ctx := cli.ctx
-	c := cli.client.Client
-	m := view.NewManager(c)
-
-	v, err := m.CreateContainerView(ctx, c.ServiceContent.RootFolder, []string{"VirtualMachine"}, true)
+func (cmd *VmInfoCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	infoCmd := flag.NewFlagSet(VM_INFO, flag.ContinueOnError)
+	selectorFlags := newVmSelectorFlags(infoCmd, false)
+	names, err := parseFlags(infoCmd, args)
 	if err != nil {
-		return nil, err
+		return UsageResult(cmd.Usage()), nil
 	}
-
-	var vms []mo.VirtualMachine
-	err = v.Retrieve(ctx, []string{"VirtualMachine"}, []string{"summary"}, &vms)
-
+	sel, err := selectorFlags.Selector(names)
 	if err != nil {
 		return nil, err
 	}
-
-	var targetVm *mo.VirtualMachine
-	for index, vm := range vms {
-		if vm.Summary.Config.Name == vmName || strconv.Itoa(index+1) == vmName {
-			targetVm = &vm
-			break
-		}
-	}
-
-	if targetVm == nil {
-		return nil, errors.New("Virtual machine '" + vmName + "' is not found")
+	if sel == nil {
+		return UsageResult(cmd.Usage()), nil
 	}
 
-	// vmRef := object.NewVirtualMachine(c, targetVm.Reference())
-
-	tbl, err := prettytable.NewTable([]prettytable.Column{
-		{Header: "Key"},
-		{Header: "Value"},
-	}...)
-
+	vms, err := sel.Find(cli, []string{"summary"})
 	if err != nil {
 		return nil, err
 	}
 
-	s := targetVm.Summary
+	tbl := NewTable([]Column{
+		{Header: "Name", Field: "name"},
+		{Header: "UUID", Field: "uuid"},
+		{Header: "Guest name", Field: "guest_name"},
+		{Header: "Memory", Field: "memory_mb"},
+		{Header: "CPU", Field: "cpu"},
+		{Header: "Power state", Field: "power_state"},
+		{Header: "Boot time", Field: "boot_time"},
+		{Header: "IP address", Field: "ip_address"},
+	}...)
 
-	infoTbl := []KeyValue{
-		{"Name", s.Config.Name},
-		{"UUID:", s.Config.Uuid},
-		{"Guest name:", s.Config.GuestFullName},
-		{"Memory:", strconv.FormatInt(int64(s.Config.MemorySizeMB), 10) + "MB"},
-		{"CPU:", strconv.FormatInt(int64(s.Config.NumCpu), 10) + " vCPU(s)"},
-		{"Power state:", fmt.Sprintf("%s", s.Runtime.PowerState)},
-		{"Boot time:", fmt.Sprintf("%s", s.Runtime.BootTime)},
-		{"IP address:", s.Guest.IpAddress},
-	}
+	tbl.Vertical = true
+	for _, vm := range vms {
+		s := vm.Summary
+		var bootTime interface{}
+		if s.Runtime.BootTime != nil {
+			bootTime = NewCell(s.Runtime.BootTime.String(), *s.Runtime.BootTime)
+		}
 
-	tbl.NoHeader = true
-	for _, k := range infoTbl {
-		tbl.AddRow(k.key, k.value)
+		var ip string
+		if s.Guest != nil {
+			ip = s.Guest.IpAddress
+		}
+
+		tbl.AddRow(s.Config.Name,
+			s.Config.Uuid,
+			s.Config.GuestFullName,
+			NewCell(strconv.FormatInt(int64(s.Config.MemorySizeMB), 10)+"MB", s.Config.MemorySizeMB),
+			NewCell(strconv.FormatInt(int64(s.Config.NumCpu), 10)+" vCPU(s)", s.Config.NumCpu),
+			string(s.Runtime.PowerState),
+			bootTime,
+			ip)
 	}
 
-	return tbl, nil
+	return TableResult(tbl), nil
 }
 
 func (c *VmPowerOnCommand) Usage() string {
-	return `Usage: vm poweron vm-name1 [,vm-name2, ...]
+	return `Usage: vm poweron [options] vm-name1 [,vm-name2, ...]
 
 PowerOn VM(s)
 
+` + vmSelectorHelp + `
+
+Options:
+` + vmSelectorOptions + `
+  -dry-run         Only show the selected VMs
+
 Examples:
   vm poweron vm1
   vm poweron WinVm1,Ubuntu01
+  vm poweron -where state=poweredOff 'web-*'
 `
 }
 
-func (c *VmPowerOnCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table, error) {
-	if len(strings.Join(args, "")) == 0 {
-		Usage(c.Usage())
-		return nil, nil
-	}
-	err := executeVmCommand(VM_POWERON, cli, args...)
-	return nil, err
+func (c *VmPowerOnCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	return executeVmCommand(c.Usage(), VM_POWERON, cli, args...)
 }
 
 func (c *VmPowerOffCommand) Usage() string {
-	return `Usage: vm poweroff vm-name1 [,vm-name2, ...]
+	return `Usage: vm poweroff [options] vm-name1 [,vm-name2, ...]
 
 PowerOff VM(s)
 
+` + vmSelectorHelp + `
+
+Options:
+` + vmSelectorOptions + `
+  -dry-run         Only show the selected VMs
+
 Examples:
   vm poweroff vm1
   vm poweroff WinVm1,Ubuntu01
+  vm poweroff -where state=poweredOn 'web-*'
 `
 }
 
-func (c *VmPowerOffCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table, error) {
-	if len(strings.Join(args, "")) == 0 {
-		Usage(c.Usage())
-		return nil, nil
-	}
-	err := executeVmCommand(VM_POWEROFF, cli, args...)
-	return nil, err
+func (c *VmPowerOffCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	return executeVmCommand(c.Usage(), VM_POWEROFF, cli, args...)
 }
 
 func (c *VmDestroyCommand) Usage() string {
-	return `Usage: vm destroy vm-name1 [,vm-name2, ...]
+	return `Usage: vm destroy [options] vm-name1 [,vm-name2, ...]
+
+Destroy VM(s). The VM name, or the number of VMs, has to be typed to confirm.
+
+` + vmSelectorHelp + `
 
-Destroy VM(s)
+Options:
+  -yes             Don't ask for confirmation
+  -dry-run         Only show the VMs that would be destroyed
+` + vmSelectorOptions + `
 
 Examples:
   vm destroy vm1
-  vm destroy WinVm1,Ubuntu01
+  vm destroy -dry-run WinVm1,Ubuntu01
+  vm destroy -yes WinVm1,Ubuntu01
+  vm destroy -dry-run -re '^test-.*-0[1-4]$'
 `
 }
 
-func (c *VmDestroyCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table, error) {
-	if len(strings.Join(args, "")) == 0 {
-		Usage(c.Usage())
-		return nil, nil
+func (c *VmDestroyCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	destroyCmd := flag.NewFlagSet(VM_DESTROY, flag.ContinueOnError)
+	yes := destroyCmd.Bool("yes", false, "Don't ask for confirmation")
+	selectorFlags := newVmSelectorFlags(destroyCmd, true)
+	names, err := parseFlags(destroyCmd, args)
+	if err != nil {
+		return UsageResult(c.Usage()), nil
+	}
+	sel, err := selectorFlags.Selector(names)
+	if err != nil {
+		return nil, err
+	}
+	if sel == nil {
+		return UsageResult(c.Usage()), nil
 	}
-	err := executeVmCommand(VM_DESTROY, cli, args...)
-	return nil, err
+
+	vms, err := sel.Find(cli, []string{"summary"})
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := getVmPlan(cli, vms)
+	if err != nil {
+		return nil, err
+	}
+	if sel.DryRun {
+		return DryRunResult(plan), nil
+	}
+
+	vmNames := make([]string, 0, len(vms))
+	for _, vm := range vms {
+		vmNames = append(vmNames, vm.Summary.Config.Name)
+	}
+	question := fmt.Sprintf("%d VM(s) will be destroyed.", len(vms))
+	if err := confirm(question, confirmValue(vmNames), plan, *yes); err != nil {
+		return nil, err
+	}
+
+	return runVmActionOn(cli, vms, vmActions[VM_DESTROY], func(ctx context.Context, vm *object.VirtualMachine) error {
+		return doVmAction(vm, VM_DESTROY, ctx)
+	})
+}
+
+// getVmPlan lists the VMs with the name of their host
+func getVmPlan(cli *Vcli, vms []mo.VirtualMachine) (*Table, error) {
+	hostNames := make(map[types.ManagedObjectReference]string)
+	var refs []types.ManagedObjectReference
+	for _, vm := range vms {
+		if ref := vm.Summary.Runtime.Host; ref != nil {
+			if _, ok := hostNames[*ref]; !ok {
+				hostNames[*ref] = ""
+				refs = append(refs, *ref)
+			}
+		}
+	}
+
+	if len(refs) > 0 {
+		var hosts []mo.HostSystem
+		err := property.DefaultCollector(cli.client.Client).Retrieve(cli.ctx, refs, []string{"name"}, &hosts)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range hosts {
+			hostNames[h.Reference()] = h.Name
+		}
+	}
+
+	plan := NewPlanTable()
+	for _, vm := range vms {
+		var host string
+		if ref := vm.Summary.Runtime.Host; ref != nil {
+			host = hostNames[*ref]
+		}
+		plan.AddRow("VM", vm.Summary.Config.Name, host)
+	}
+	return plan, nil
 }
 
 func (c *VmResetCommand) Usage() string {
-	return `Usage: vm reset vm-name1 [,vm-name2, ...]
+	return `Usage: vm reset [options] vm-name1 [,vm-name2, ...]
 
 Reset VM(s)
 
+` + vmSelectorHelp + `
+
+Options:
+` + vmSelectorOptions + `
+  -dry-run         Only show the selected VMs
+
 Examples:
   vm reset vm1
   vm reset WinVm1,Ubuntu01
+  vm reset -where state=poweredOn 'web-*'
 `
 }
 
-func (c *VmResetCommand) Execute(cli *Vcli, args ...string) (*prettytable.Table, error) {
-	if len(strings.Join(args, "")) == 0 {
-		Usage(c.Usage())
-		return nil, nil
-	}
-	err := executeVmCommand(VM_RESET, cli, args...)
-	return nil, err
+func (c *VmResetCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	return executeVmCommand(c.Usage(), VM_RESET, cli, args...)
 }
 
-func executeVmCommand(action string, cli *Vcli, args ...string) error {
-	var vmArgs []string
-	if len(args) > 0 {
-		vmArgs = strings.Split(args[0], ",")
-	} else {
-		return errors.New("usage error")
+func (c *VmShutdownCommand) Usage() string {
+	return `Usage: vm shutdown [options] vm-name1 [,vm-name2, ...]
+
+Shut down the guest OS of VM(s) through VMware Tools and wait until the
+VM is powered off.
+
+` + vmSelectorHelp + `
+
+Options:
+  -timeout=secs    Time to wait for the VM to power off (default 300)
+  -force           Power off the VM if the guest has not stopped in time
+                   or VMware Tools are not running
+` + vmSelectorOptions + `
+  -dry-run         Only show the selected VMs
+
+Examples:
+  vm shutdown Ubuntu01
+  vm shutdown -timeout 120 -force WinVm1,Ubuntu01
+`
+}
+
+func (c *VmShutdownCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	shutdownCmd := flag.NewFlagSet(VM_SHUTDOWN, flag.ContinueOnError)
+	timeout := shutdownCmd.Int("timeout", defaultShutdownTimeout, "Timeout in seconds")
+	force := shutdownCmd.Bool("force", false, "Power off if the guest has not stopped in time")
+	selectorFlags := newVmSelectorFlags(shutdownCmd, true)
+	names, err := parseFlags(shutdownCmd, args)
+	if err != nil {
+		return UsageResult(c.Usage()), nil
+	}
+	sel, err := selectorFlags.Selector(names)
+	if err != nil {
+		return nil, err
+	}
+	if sel == nil {
+		return UsageResult(c.Usage()), nil
 	}
 
-	ctx := cli.ctx
-	c := cli.client.Client
-	m := view.NewManager(c)
-	v, err := m.CreateContainerView(ctx, c.ServiceContent.RootFolder, []string{"VirtualMachine"}, true)
+	return runVmAction(cli, sel, vmActions[VM_SHUTDOWN], func(ctx context.Context, vm *object.VirtualMachine) error {
+		return shutdownVm(ctx, vm, time.Duration(*timeout)*time.Second, *force)
+	})
+}
+
+func (c *VmRebootCommand) Usage() string {
+	return `Usage: vm reboot [options] vm-name1 [,vm-name2, ...]
+
+Reboot the guest OS of VM(s) through VMware Tools
+
+` + vmSelectorHelp + `
+
+Options:
+` + vmSelectorOptions + `
+  -dry-run         Only show the selected VMs
+
+Examples:
+  vm reboot Ubuntu01
+  vm reboot WinVm1,Ubuntu01
+  vm reboot -where state=poweredOn 'web-*'
+`
+}
+
+func (c *VmRebootCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	return executeVmCommand(c.Usage(), VM_REBOOT, cli, args...)
+}
+
+func (c *VmStandbyCommand) Usage() string {
+	return `Usage: vm standby [options] vm-name1 [,vm-name2, ...]
+
+Put the guest OS of VM(s) in standby through VMware Tools
+
+` + vmSelectorHelp + `
+
+Options:
+` + vmSelectorOptions + `
+  -dry-run         Only show the selected VMs
+
+Examples:
+  vm standby WinVm1
+  vm standby -where state=poweredOn 'web-*'
+`
+}
+
+func (c *VmStandbyCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	return executeVmCommand(c.Usage(), VM_STANDBY, cli, args...)
+}
+
+func (c *VmSuspendCommand) Usage() string {
+	return `Usage: vm suspend [options] vm-name1 [,vm-name2, ...]
+
+Suspend VM(s)
+
+` + vmSelectorHelp + `
+
+Options:
+` + vmSelectorOptions + `
+  -dry-run         Only show the selected VMs
+
+Examples:
+  vm suspend vm1
+  vm suspend WinVm1,Ubuntu01
+  vm suspend -where state=poweredOn 'web-*'
+`
+}
+
+func (c *VmSuspendCommand) Execute(cli *Vcli, args ...string) (*Result, error) {
+	return executeVmCommand(c.Usage(), VM_SUSPEND, cli, args...)
+}
+
+// columns of the per-VM outcome of an action
+var vmActionColumns = []Column{
+	{Header: "Name", Field: "name"},
+	{Header: "Action", Field: "action"},
+	{Header: "Status", Field: "status"},
+}
+
+// executeVmCommand runs a VM action on the VMs selected by the arguments
+func executeVmCommand(usage string, action string, cli *Vcli, args ...string) (*Result, error) {
+	actionCmd := flag.NewFlagSet(action, flag.ContinueOnError)
+	selectorFlags := newVmSelectorFlags(actionCmd, true)
+	names, err := parseFlags(actionCmd, args)
 	if err != nil {
-		return err
+		return UsageResult(usage), nil
+	}
+	sel, err := selectorFlags.Selector(names)
+	if err != nil {
+		return nil, err
 	}
+	if sel == nil {
+		return UsageResult(usage), nil
+	}
+
+	return runVmAction(cli, sel, vmActions[action], func(ctx context.Context, vm *object.VirtualMachine) error {
+		return doVmAction(vm, action, ctx)
+	})
+}
 
-	var vms, actionableVms []mo.VirtualMachine
-	// Retrieve summary property for all machines
-	props := []string{"summary"}
-	err = v.Retrieve(ctx, []string{"VirtualMachine"}, props, &vms)
+// runVmAction runs fn in parallel for every selected VM and collects the
+// outcome per VM. VMs selected by a pattern are listed before they are
+// changed, with -dry-run they are only listed.
+func runVmAction(cli *Vcli, sel *VmSelector, action vmAction, fn vmActionFunc) (*Result, error) {
+	actionableVms, err := sel.Find(cli, []string{"summary"})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for _, name := range vmArgs {
-		for index, vm := range vms {
-			if vm.Summary.Config.Name == name || strconv.Itoa(index+1) == name {
-				actionableVms = append(actionableVms, vm)
-			}
+	if sel.DryRun {
+		plan, err := getVmPlan(cli, actionableVms)
+		if err != nil {
+			return nil, err
 		}
+		return DryRunResult(plan), nil
 	}
-
-	if len(actionableVms) == 0 {
-		return errors.New(fmt.Sprintf("%v not found", vmArgs))
+	if sel.IsPattern() {
+		previewVms(actionableVms)
 	}
+	return runVmActionOn(cli, actionableVms, action, fn)
+}
 
-	Spinner.Stop()
-	var wg sync.WaitGroup
-	channel := make(chan string)
+// runVmActionOn runs fn in parallel for every VM that has been looked up
+// already
+func runVmActionOn(cli *Vcli, actionableVms []mo.VirtualMachine, action vmAction, fn vmActionFunc) (*Result, error) {
+	ctx := cli.ctx
+	c := cli.client.Client
 
-	go func() {
-		for m := range channel {
-			Infoln(m)
-		}
-	}()
+	var wg sync.WaitGroup
+	rows := make([][]interface{}, len(actionableVms))
+	errs := make([]error, len(actionableVms))
 
-	for _, vm := range actionableVms {
+	for i, vm := range actionableVms {
 		wg.Add(1)
-		go func(vm interface{}) error {
-			var machine mo.VirtualMachine = vm.(mo.VirtualMachine)
-
+		go func(i int, machine mo.VirtualMachine) {
 			defer wg.Done()
 			vmRef := object.NewVirtualMachine(c, machine.Reference())
 			vmName := machine.Summary.Config.Name
-			channel <- fmt.Sprintf("%s '%s'...", vmActions[action].startActionMessage, vmName)
-			err = doVmAction(vmRef, action, ctx)
-
-			if err != nil {
-				channel <- fmt.Sprintf("%s", err.Error())
-				return err
-			}
+			vmRef.InventoryPath = vmName
+			Progress("%s '%s'...", action.startActionMessage, vmName)
 
-			if err == nil {
-				channel <- fmt.Sprintf("%s completed for '%s'", vmActions[action].action, vmName)
-			} else {
-				channel <- fmt.Sprintf("Failed to %s vm '%s': %s", vmActions[action].action, vmName, err.Error())
+			if err := fn(ctx, vmRef); err != nil {
+				rows[i] = []interface{}{vmName, action.action, "failed"}
+				errs[i] = fmt.Errorf("Failed to %s vm '%s': %s", action.action, vmName, err.Error())
+				return
 			}
-			return nil
-		}(vm)
+			rows[i] = []interface{}{vmName, action.action, "completed"}
+		}(i, vm)
+	}
+	wg.Wait()
 
+	r := TableResult(NewTable(vmActionColumns...))
+	for i := range actionableVms {
+		r.Table.AddRow(rows[i]...)
+		if errs[i] != nil {
+			r.AddError(errs[i])
+		}
 	}
+	return r, nil
+}
 
-	wg.Wait()
-	close(channel)
-	// Wait for 1 sec to show prompt properly, after showing up all messages from channel
-	time.Sleep(1 * time.Second)
-	return nil
+// findVirtualMachines retrieves the given properties, which must include
+// 'summary', of the VMs in a comma separated list of VM names, numbers,
+// globs, inventory paths or managed object IDs
+func findVirtualMachines(cli *Vcli, names string, props []string) ([]mo.VirtualMachine, error) {
+	return newVmSelector(names).Find(cli, props)
 }
 
 func doVmAction(v *object.VirtualMachine, action string, ctx context.Context) error {
@@ -399,14 +696,19 @@ func doVmAction(v *object.VirtualMachine, action string, ctx context.Context) er
 		task, err = v.Destroy(ctx)
 	case VM_RESET:
 		task, err = v.Reset(ctx)
-	}
-
-	if err != nil {
+	case VM_SUSPEND:
+		task, err = v.Suspend(ctx)
+	case VM_SHUTDOWN:
+		return shutdownVm(ctx, v, defaultShutdownTimeout*time.Second, false)
+	case VM_REBOOT:
+		// guest operations of VMware Tools don't return a task
+		return v.RebootGuest(ctx)
+	case VM_STANDBY:
+		_, err = methods.StandbyGuest(ctx, v.Client(), &types.StandbyGuest{This: v.Reference()})
 		return err
 	}
 
-	_, err = task.WaitForResult(ctx, nil)
-	if err != nil {
+	if err = waitForTask(ctx, task, err, v.Name()); err != nil {
 		return err
 	}
 
@@ -419,3 +721,45 @@ func doVmAction(v *object.VirtualMachine, action string, ctx context.Context) er
 
 	return nil
 }
+
+// shutdownVm shuts down the guest OS and waits for the VM to power off.
+// With force the VM is powered off if the guest doesn't stop in time or
+// can't be shut down at all, e.g. when VMware Tools are not running.
+func shutdownVm(ctx context.Context, v *object.VirtualMachine, timeout time.Duration, force bool) error {
+	err := v.ShutdownGuest(ctx)
+	if err == nil {
+		waitCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		err = v.WaitForPowerState(waitCtx, types.VirtualMachinePowerStatePoweredOff)
+		if err == nil {
+			return nil
+		}
+		// the wait returns the error of the cancelled request, the timeout
+		// is told apart by the context. Other errors are not a reason to
+		// power off.
+		if ctx.Err() != nil || waitCtx.Err() != context.DeadlineExceeded {
+			return err
+		}
+		err = fmt.Errorf("guest has not stopped within %s", timeout)
+	}
+
+	// a command cancelled with Ctrl-C doesn't power off the VM
+	if err != nil && force && ctx.Err() == nil {
+		name, _ := v.ObjectName(ctx)
+		Progress("Guest of '%s' not stopped (%s), powering off...", name, err)
+		task, err := v.PowerOff(ctx)
+		return waitForTask(ctx, task, err, name)
+	}
+	return err
+}
+
+// waitForTask waits for a task started by a call that returned (task, err).
+// The task ID is printed so the task can be followed with 'task watch'.
+func waitForTask(ctx context.Context, task *object.Task, err error, target string) error {
+	if err != nil {
+		return err
+	}
+	Progress("Task %s started for '%s'", task.Reference().Value, target)
+	_, err = task.WaitForResult(ctx, nil)
+	return err
+}